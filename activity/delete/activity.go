@@ -8,9 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
-	"strings"
 
-	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/open-dovetail/fabric-chaincode/common"
 	"github.com/pkg/errors"
 	"github.com/project-flogo/core/activity"
@@ -30,6 +28,7 @@ func init() {
 type Activity struct {
 	compositeKeys map[string][]string
 	keysOnly      bool
+	sensitive     string
 }
 
 func (a *Activity) String() string {
@@ -45,9 +44,22 @@ func New(ctx activity.InitContext) (activity.Activity, error) {
 		return nil, err
 	}
 
+	// register protobuf messages for decoding enveloped values
+	if err := common.LoadProtoDescriptor(s.ProtoDescriptor); err != nil {
+		logger.Errorf("failed to load protobuf descriptor %v", err)
+		return nil, err
+	}
+
+	// register attribute types for decoding composite keys
+	if err := common.RegisterCompositeKeys(s.CompositeKeys); err != nil {
+		logger.Errorf("failed to register composite keys %v", err)
+		return nil, err
+	}
+
 	return &Activity{
 		compositeKeys: s.CompositeKeys,
 		keysOnly:      s.KeysOnly,
+		sensitive:     s.Sensitive,
 	}, nil
 }
 
@@ -66,23 +78,18 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		return false, err
 	}
 
-	if strings.HasPrefix(input.PrivateCollection, "_implicit") {
-		// override implicit collection using client's org
-		mspid, err := common.ResolveFlowData("$.cid.mspid", ctx)
-		if err != nil {
-			logger.Debugf("failed to fetch client mspid: %v\n", err)
-		} else {
-			if msp, ok := mspid.(string); ok && len(msp) > 0 {
-				input.PrivateCollection = "_implicit_org_" + msp
-				logger.Debugf("set implicit PDC to %s\n", input.PrivateCollection)
-			}
-		}
+	// resolve collection alias and implicit collection of the org
+	if input.PrivateCollection, err = common.ResolveCollection(ctx, input.PrivateCollection); err != nil {
+		logger.Errorf("failed to resolve private collection: %v", err)
+		output := &Output{Code: 400, Message: err.Error()}
+		ctx.SetOutputObject(output)
+		return false, err
 	}
 
-	// get chaincode stub
-	stub, err := common.GetChaincodeStub(ctx)
-	if err != nil || stub == nil {
-		msg := fmt.Sprintf("failed to retrieve fabric stub: %v", err)
+	// get state store
+	store, err := common.GetStateStore(ctx)
+	if err != nil || store == nil {
+		msg := fmt.Sprintf("failed to retrieve state store: %v", err)
 		logger.Errorf("%s", msg)
 		output := &Output{Code: 500, Message: msg}
 		ctx.SetOutputObject(output)
@@ -97,7 +104,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 	case reflect.Slice:
 		data := input.Data.([]interface{})
 		for _, item := range data {
-			c, v, e := a.collectData(stub, input.PrivateCollection, item)
+			c, v, e := a.collectData(store, input.PrivateCollection, item)
 			if e != nil {
 				err = e
 			}
@@ -121,7 +128,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 	case reflect.Map, reflect.String:
 		// process single data object
 		var v interface{}
-		code, v, err = a.collectData(stub, input.PrivateCollection, input.Data)
+		code, v, err = a.collectData(store, input.PrivateCollection, input.Data)
 		if v != nil {
 			if a.keysOnly {
 				compositeKeys = v.([]string)
@@ -139,9 +146,10 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 
 	var result []interface{}
 	if len(stateMap) > 0 {
-		// delete collected ledger states
+		// delete collected ledger states, and redact sensitive data in logs
+		redactor := common.GetRedactor(ctx, a.sensitive)
 		for s := range stateMap {
-			c, v, e := a.deleteDataByKey(stub, input.PrivateCollection, s)
+			c, v, e := a.deleteDataByKey(store, input.PrivateCollection, s, redactor)
 			if e != nil {
 				err = e
 			}
@@ -160,7 +168,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		keyMap := make(map[string]*common.CompositeKeyBag)
 		for _, k := range compositeKeys {
 			// construct key objects from composite key strings
-			if c, err := common.SplitCompositeKey(stub, k); err == nil {
+			if c, err := common.SplitCompositeKey(store, k); err == nil {
 				bag, ok := keyMap[c.Name]
 				if !ok {
 					bag = &common.CompositeKeyBag{
@@ -214,12 +222,12 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 // delete ledger state and associated composite keys by a specified state key
 // returns status code, deleted state object, or error
 //   It should be called only if keysOnly is false
-func (a *Activity) deleteDataByKey(stub shim.ChaincodeStubInterface, collection string, key string) (int, interface{}, error) {
+func (a *Activity) deleteDataByKey(store common.StateStore, collection string, key string, redactor *common.Redactor) (int, interface{}, error) {
 	if len(key) == 0 {
 		return 400, nil, errors.New("state key is not specified")
 	}
 
-	_, jsonBytes, err := common.GetData(stub, collection, key, false)
+	_, jsonBytes, err := common.GetData(store, collection, key, false)
 	if err != nil {
 		msg := fmt.Sprintf("failed to get data '%s @ %s'", key, collection)
 		logger.Errorf("%s: %+v", msg, err)
@@ -232,25 +240,27 @@ func (a *Activity) deleteDataByKey(stub shim.ChaincodeStubInterface, collection
 	}
 
 	// delete data
-	if err := common.DeleteData(stub, collection, key); err != nil {
+	if err := common.DeleteData(store, collection, key); err != nil {
 		msg := fmt.Sprintf("failed to delete data %s @ %s", key, collection)
 		logger.Errorf("%s: %+v", msg, err)
 		return 500, nil, errors.Wrapf(err, msg)
 	}
-	logger.Debugf("deleted %s @ %s, data: %s", key, collection, string(jsonBytes))
+	if logger.DebugEnabled() {
+		logger.Debugf("deleted %s @ %s, data: %s", key, collection, redactor.RedactJSON(jsonBytes))
+	}
 
-	var value interface{}
-	if err := json.Unmarshal(jsonBytes, &value); err != nil {
-		msg := fmt.Sprintf("failed to parse JSON data - %s", string(jsonBytes))
+	value, _, err := common.DecodeValue(jsonBytes)
+	if err != nil {
+		msg := fmt.Sprintf("failed to decode data of key %s", key)
 		logger.Errorf("%s: %+v", msg, err)
 		return 500, nil, errors.Wrapf(err, msg)
 	}
 
-	// delete composite keys if specified
-	compKeys := common.ExtractCompositeKeys(stub, a.compositeKeys, key, value)
+	// delete composite keys if specified, and unique keys defined by other activities to release their attribute values
+	compKeys := common.ExtractCompositeKeys(store, common.WithUniqueKeys(a.compositeKeys), key, value)
 	if len(compKeys) > 0 {
 		for _, k := range compKeys {
-			if err := common.DeleteData(stub, collection, k); err != nil {
+			if err := common.DeleteData(store, collection, k); err != nil {
 				logger.Warnf("failed to delete composite key %s @ %s: %+v", k, collection, err)
 			} else {
 				logger.Debugf("deleted composite key %s @ %s", k, collection)
@@ -263,7 +273,7 @@ func (a *Activity) deleteDataByKey(stub shim.ChaincodeStubInterface, collection
 
 // if keysOnly = false, collect unique state key, and return it as map[string]nil
 // if keysOnly = true, delete composite keys, and return them as []string
-func (a *Activity) collectData(stub shim.ChaincodeStubInterface, collection string, data interface{}) (int, interface{}, error) {
+func (a *Activity) collectData(store common.StateStore, collection string, data interface{}) (int, interface{}, error) {
 	switch t := reflect.TypeOf(data).Kind(); t {
 	case reflect.String:
 		// evaluate a state key
@@ -279,7 +289,7 @@ func (a *Activity) collectData(stub shim.ChaincodeStubInterface, collection stri
 		return 200, map[string]interface{}{k: nil}, nil
 	case reflect.Map:
 		request := data.(map[string]interface{})
-		return a.deleteDataByPartialKey(stub, collection, request)
+		return a.deleteDataByPartialKey(store, collection, request)
 	default:
 		msg := fmt.Sprintf("invalid input data type %T", data)
 		logger.Errorf("%s", msg)
@@ -291,11 +301,11 @@ func (a *Activity) collectData(stub shim.ChaincodeStubInterface, collection stri
 // returns status code, result, or error
 //   If keysOnly is true, delete only associated composite keys, and return the array of deleted keys
 //   if keysOnly is false, collect unique state keys, and return them as map[string]nil
-func (a *Activity) deleteDataByPartialKey(stub shim.ChaincodeStubInterface, collection string, data map[string]interface{}) (int, interface{}, error) {
+func (a *Activity) deleteDataByPartialKey(store common.StateStore, collection string, data map[string]interface{}) (int, interface{}, error) {
 	if len(data) == 0 {
 		return 400, nil, errors.New("partial composite key is not specified")
 	}
-	keys := common.ExtractCompositeKeys(stub, a.compositeKeys, "", data)
+	keys := common.ExtractCompositeKeys(store, a.compositeKeys, "", data)
 	if len(keys) == 0 {
 		msg := fmt.Sprintf("no composite key found for '%v'\n", data)
 		logger.Debugf("%s'", msg)
@@ -306,7 +316,7 @@ func (a *Activity) deleteDataByPartialKey(stub shim.ChaincodeStubInterface, coll
 		// delete composite keys
 		var compKeys []string
 		for _, k := range keys {
-			cks, err := deleteCompositeKeys(stub, collection, k)
+			cks, err := deleteCompositeKeys(store, collection, k)
 			if err != nil {
 				continue
 			}
@@ -321,7 +331,7 @@ func (a *Activity) deleteDataByPartialKey(stub shim.ChaincodeStubInterface, coll
 	// collect unique state keys
 	stateKeys := make(map[string]interface{})
 	for _, k := range keys {
-		stateMap, err := collectStatesByCompositeKey(stub, collection, k)
+		stateMap, err := collectStatesByCompositeKey(store, collection, k)
 		if err != nil {
 			continue
 		}
@@ -338,15 +348,16 @@ func (a *Activity) deleteDataByPartialKey(stub shim.ChaincodeStubInterface, coll
 
 // delete composite keys only, called when keysOnly == true
 // return list of deleted composite keys
-func deleteCompositeKeys(stub shim.ChaincodeStubInterface, collection string, key string) ([]string, error) {
-	ck, err := common.SplitCompositeKey(stub, key)
+func deleteCompositeKeys(store common.StateStore, collection string, key string) ([]string, error) {
+	// split encoded attributes for partial key query
+	name, fields, err := store.SplitCompositeKey(key)
 	if err != nil {
 		msg := fmt.Sprintf("invalid composite key %s", key)
 		logger.Warnf("%s: %v", msg, err)
 		return nil, errors.Wrapf(err, msg)
 	}
 	// query matching composite keys
-	iter, _, err := common.GetCompositeKeys(stub, collection, ck.Name, ck.Fields, 0, "")
+	iter, _, err := common.GetCompositeKeys(store, collection, name, fields, 0, "")
 	if err != nil {
 		msg := fmt.Sprintf("error executing partial key query for %s", key)
 		logger.Warnf("%s: %v", msg, err)
@@ -362,7 +373,7 @@ func deleteCompositeKeys(stub shim.ChaincodeStubInterface, collection string, ke
 			continue
 		}
 		// delete composite key
-		if err := common.DeleteData(stub, collection, resp.Key); err == nil {
+		if err := common.DeleteData(store, collection, resp.Key); err == nil {
 			// add key attributes to result array
 			compKeys = append(compKeys, resp.Key)
 		}
@@ -372,15 +383,16 @@ func deleteCompositeKeys(stub shim.ChaincodeStubInterface, collection string, ke
 
 // collect state keys to be deleted, called when keysOnly == false
 // associated composite keys will be deleted later when states are deleted
-func collectStatesByCompositeKey(stub shim.ChaincodeStubInterface, collection string, key string) (map[string]interface{}, error) {
-	ck, err := common.SplitCompositeKey(stub, key)
+func collectStatesByCompositeKey(store common.StateStore, collection string, key string) (map[string]interface{}, error) {
+	// split encoded attributes for partial key query
+	name, fields, err := store.SplitCompositeKey(key)
 	if err != nil {
 		msg := fmt.Sprintf("invalid composite key %s", key)
 		logger.Warnf("%s: %v", msg, err)
 		return nil, errors.Wrapf(err, msg)
 	}
 	// query matching composite keys
-	iter, _, err := common.GetCompositeKeys(stub, collection, ck.Name, ck.Fields, 0, "")
+	iter, _, err := common.GetCompositeKeys(store, collection, name, fields, 0, "")
 	if err != nil {
 		msg := fmt.Sprintf("error executing partial key query for %s", key)
 		logger.Warnf("%s: %v", msg, err)
@@ -396,7 +408,7 @@ func collectStatesByCompositeKey(stub shim.ChaincodeStubInterface, collection st
 			continue
 		}
 		// add state key
-		if c, err := common.SplitCompositeKey(stub, resp.Key); err != nil {
+		if c, err := common.SplitCompositeKey(store, resp.Key); err != nil {
 			logger.Warnf("ignore invalid composite key %s with parsing error %v", resp.Key, err)
 		} else {
 			// collect unique state keys
@@ -327,3 +327,28 @@ func TestDeleteKeysOnly(t *testing.T) {
 	iter.Close()
 	stub.MockTransactionEnd("6")
 }
+
+func TestDeleteUniqueKey(t *testing.T) {
+	logger.Info("TestDeleteUniqueKey")
+	act.keysOnly = false
+
+	// unique key defined by a put activity
+	assert.NoError(t, common.RegisterUniqueKeys(map[string][]string{"email~id": {"$.docType", "$.email"}}), "register unique key should not throw error")
+	store := common.NewMemoryStore()
+	data := map[string]interface{}{"docType": "user", "id": "user1", "email": "tom@example.com"}
+	value, _ := json.Marshal(data)
+	store.PutState("", "user1", value)
+	key, _ := common.MakeCompositeKey(store, "email~id", []string{"$.docType", "$.email"}, "user1", data)
+	store.PutState("", key, []byte{0x00})
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	err := tc.SetInputObject(&Input{Data: "user1"})
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err := act.Eval(tc)
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+
+	v, _ := store.GetState("", key)
+	assert.Nil(t, v, "unique key of the deleted state should be deleted")
+}
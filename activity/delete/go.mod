@@ -13,3 +13,5 @@ require (
 	github.com/project-flogo/core v1.2.0
 	github.com/stretchr/testify v1.6.1
 )
+
+replace github.com/open-dovetail/fabric-chaincode/common => ../../common
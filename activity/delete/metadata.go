@@ -12,9 +12,13 @@ import (
 )
 
 // Settings of the activity
+// sensitive is comma-delimited JSON paths of state values that are redacted in logs
+// protoDescriptor is base64-encoded protobuf FileDescriptorSet for decoding state values of protobuf encoding
 type Settings struct {
-	CompositeKeys map[string][]string `md:"compositeKeys"`
-	KeysOnly      bool                `md:"keysOnly"`
+	CompositeKeys   map[string][]string `md:"compositeKeys"`
+	KeysOnly        bool                `md:"keysOnly"`
+	Sensitive       string              `md:"sensitive"`
+	ProtoDescriptor string              `md:"protoDescriptor"`
 }
 
 // Input of the activity
@@ -37,6 +41,12 @@ func (h *Settings) FromMap(values map[string]interface{}) error {
 	if h.KeysOnly, err = coerce.ToBool(values["keysOnly"]); err != nil {
 		return err
 	}
+	if h.Sensitive, err = coerce.ToString(values["sensitive"]); err != nil {
+		return err
+	}
+	if h.ProtoDescriptor, err = coerce.ToString(values["protoDescriptor"]); err != nil {
+		return err
+	}
 
 	keys, err := common.MapToObject(values["compositeKeys"])
 	if err != nil || len(keys) == 0 {
@@ -7,11 +7,9 @@ package endorsement
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
-	"github.com/hyperledger/fabric-chaincode-go/shim"
 	cb "github.com/hyperledger/fabric-protos-go/common"
 	cm "github.com/hyperledger/fabric-protos-go/msp"
 	"github.com/hyperledger/fabric/common/policydsl"
@@ -66,23 +64,18 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		return false, err
 	}
 
-	if strings.HasPrefix(input.PrivateCollection, "_implicit") {
-		// override implicit collection using client's org
-		mspid, err := common.ResolveFlowData("$.cid.mspid", ctx)
-		if err != nil {
-			logger.Debugf("failed to fetch client mspid: %v", err)
-		} else {
-			if msp, ok := mspid.(string); ok && len(msp) > 0 {
-				input.PrivateCollection = "_implicit_org_" + msp
-				logger.Debugf("set implicit PDC to %s", input.PrivateCollection)
-			}
-		}
+	// resolve collection alias and implicit collection of the org
+	if input.PrivateCollection, err = common.ResolveCollection(ctx, input.PrivateCollection); err != nil {
+		logger.Errorf("failed to resolve private collection: %v", err)
+		output := &Output{Code: 400, Message: err.Error()}
+		ctx.SetOutputObject(output)
+		return false, err
 	}
 
-	// get chaincode stub
-	stub, err := common.GetChaincodeStub(ctx)
-	if err != nil || stub == nil {
-		msg := fmt.Sprintf("failed to retrieve fabric stub: %v", err)
+	// get state store
+	store, err := common.GetStateStore(ctx)
+	if err != nil || store == nil {
+		msg := fmt.Sprintf("failed to retrieve state store: %v", err)
 		logger.Errorf("%s", msg)
 		output := &Output{Code: 500, Message: msg}
 		ctx.SetOutputObject(output)
@@ -100,7 +93,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 	var code int
 	var value []interface{}
 	for _, key := range input.StateKeys {
-		c, v, e := a.handlePolicy(stub, input, key)
+		c, v, e := a.handlePolicy(store, input, key)
 		if e != nil {
 			err = e
 		}
@@ -138,8 +131,8 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 
 // handlePolicy performs an operation on endorsement policy of a state key
 // returns status, operation result or error
-func (a *Activity) handlePolicy(stub shim.ChaincodeStubInterface, input *Input, key string) (int, interface{}, error) {
-	ep, err := getEndorsementPolicy(stub, input.PrivateCollection, key)
+func (a *Activity) handlePolicy(store common.StateStore, input *Input, key string) (int, interface{}, error) {
+	ep, err := store.GetValidationParameter(input.PrivateCollection, key)
 	if err != nil {
 		return 500, nil, err
 	}
@@ -164,7 +157,7 @@ func (a *Activity) handlePolicy(stub shim.ChaincodeStubInterface, input *Input,
 
 	if a.operation != "LIST" {
 		// update endorsement policy for key
-		if err := setEndorsementPolicy(stub, input.PrivateCollection, key, ep); err != nil {
+		if err := store.SetValidationParameter(input.PrivateCollection, key, ep); err != nil {
 			msg := fmt.Sprintf("failed to set policy for %s @ %s", key, input.PrivateCollection)
 			logger.Errorf("%s: %+v", msg, err)
 			return 500, nil, errors.Wrapf(err, msg)
@@ -220,20 +213,6 @@ func ruleToMap(rule *cb.SignaturePolicy) map[string]interface{} {
 	return result
 }
 
-func getEndorsementPolicy(stub shim.ChaincodeStubInterface, store string, key string) ([]byte, error) {
-	if len(store) > 0 {
-		return stub.GetPrivateDataValidationParameter(store, key)
-	}
-	return stub.GetStateValidationParameter(key)
-}
-
-func setEndorsementPolicy(stub shim.ChaincodeStubInterface, store string, key string, ep []byte) error {
-	if len(store) > 0 {
-		return stub.SetPrivateDataValidationParameter(store, key, ep)
-	}
-	return stub.SetStateValidationParameter(key, ep)
-}
-
 // returns endorsement policy specified by a string, e.g., OutOf(1, 'Org1.peer', 'Org2.peer', 'Org3.peer')
 func createNewPolicy(policy string) ([]byte, error) {
 	// create new policy from policy string
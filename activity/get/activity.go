@@ -43,6 +43,8 @@ type Activity struct {
 	keysOnly    bool
 	history     bool
 	privateHash bool
+	sensitive   string
+	encrypted   string
 }
 
 func (a *Activity) String() string {
@@ -58,6 +60,24 @@ func New(ctx activity.InitContext) (activity.Activity, error) {
 		return nil, err
 	}
 
+	// register protobuf messages for decoding enveloped values
+	if err := common.LoadProtoDescriptor(s.ProtoDescriptor); err != nil {
+		logger.Errorf("failed to load protobuf descriptor %v", err)
+		return nil, err
+	}
+	if err := common.LoadMigrationRules(s.Migrations); err != nil {
+		logger.Errorf("failed to load migration rules %v", err)
+		return nil, err
+	}
+
+	// register attribute types for decoding composite keys
+	if len(s.KeyName) > 0 {
+		if err := common.RegisterCompositeKeys(map[string][]string{s.KeyName: s.Attributes}); err != nil {
+			logger.Errorf("failed to register composite key %v", err)
+			return nil, err
+		}
+	}
+
 	return &Activity{
 		keyName:     s.KeyName,
 		attributes:  s.Attributes,
@@ -65,6 +85,8 @@ func New(ctx activity.InitContext) (activity.Activity, error) {
 		keysOnly:    s.KeysOnly,
 		history:     s.History,
 		privateHash: s.PrivateHash,
+		sensitive:   s.Sensitive,
+		encrypted:   s.EncryptedFields,
 	}, nil
 }
 
@@ -83,29 +105,36 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		return false, err
 	}
 
-	if strings.HasPrefix(input.PrivateCollection, "_implicit") {
-		// override implicit collection using client's org
-		mspid, err := common.ResolveFlowData("$.cid.mspid", ctx)
-		if err != nil {
-			logger.Debugf("failed to fetch client mspid: %v\n", err)
-		} else {
-			if msp, ok := mspid.(string); ok && len(msp) > 0 {
-				input.PrivateCollection = "_implicit_org_" + msp
-				logger.Debugf("set implicit PDC to %s\n", input.PrivateCollection)
-			}
-		}
+	// resolve collection alias and implicit collection of the org
+	if input.PrivateCollection, err = common.ResolveCollection(ctx, input.PrivateCollection); err != nil {
+		logger.Errorf("failed to resolve private collection: %v", err)
+		output := &Output{Code: 400, Message: err.Error()}
+		ctx.SetOutputObject(output)
+		return false, err
 	}
 
-	// get chaincode stub
-	stub, err := common.GetChaincodeStub(ctx)
-	if err != nil || stub == nil {
-		msg := fmt.Sprintf("failed to retrieve fabric stub: %v", err)
+	// get state store
+	store, err := common.GetStateStore(ctx)
+	if err != nil || store == nil {
+		msg := fmt.Sprintf("failed to retrieve state store: %v", err)
 		logger.Errorf("%s", msg)
 		output := &Output{Code: 500, Message: msg}
 		ctx.SetOutputObject(output)
 		return false, err
 	}
 
+	// redact sensitive data in logs
+	redactor := common.GetRedactor(ctx, a.sensitive)
+
+	// decrypt fields by the key of the request
+	fc, err := common.NewFieldCipher(a.encrypted, input.EncryptionKey)
+	if err != nil {
+		logger.Errorf("invalid encryption key: %v", err)
+		output := &Output{Code: 400, Message: err.Error()}
+		ctx.SetOutputObject(output)
+		return false, err
+	}
+
 	var code int
 	var value []interface{}
 	var bookmark string
@@ -115,7 +144,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		data := input.Data.([]interface{})
 		for _, item := range data {
 			// Note: ignore pagination if multiple get operations are specified
-			c, v, _, e := a.retrieveData(stub, input.PrivateCollection, item, 0, "")
+			c, v, _, e := a.retrieveData(store, input.PrivateCollection, item, 0, "", redactor)
 			if e != nil {
 				err = e
 			}
@@ -128,7 +157,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		}
 	case reflect.Map, reflect.String:
 		// update single data object
-		code, value, bookmark, err = a.retrieveData(stub, input.PrivateCollection, input.Data, input.PageSize, input.Bookmark)
+		code, value, bookmark, err = a.retrieveData(store, input.PrivateCollection, input.Data, input.PageSize, input.Bookmark, redactor)
 	default:
 		msg := fmt.Sprintf("invalid input data type %T", input.Data)
 		logger.Errorf("%s", msg)
@@ -165,7 +194,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 			}
 			for _, v := range value {
 				if reflect.TypeOf(v).Kind() == reflect.String {
-					if k, err := common.SplitCompositeKey(stub, v.(string)); err == nil {
+					if k, err := common.SplitCompositeKey(store, v.(string)); err == nil {
 						bag.AddCompositeKey(k)
 					}
 				}
@@ -174,8 +203,9 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 			keys, _ := bag.ToMap()
 			value = []interface{}{keys}
 		} else {
-			// expand ledger state value
+			// expand ledger state value, and pass the transaction context to migration subflows
 			var result []interface{}
+			txCtx := common.TransactionContext(ctx)
 			for _, v := range value {
 				if reflect.TypeOf(v).Elem().Name() == "StateData" {
 					state := v.(*StateData)
@@ -186,14 +216,33 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 						}
 						result = append(result, rec)
 					} else {
-						var d interface{}
-						if err := json.Unmarshal(state.Value, &d); err == nil {
-							rec := map[string]interface{}{
-								common.KeyField:   state.Key,
-								common.ValueField: d,
+						d, format, err := common.DecodeValue(state.Value)
+						if err != nil {
+							logger.Warnf("ignore state %s that cannot be decoded: %v", state.Key, err)
+							continue
+						}
+						// decrypt encrypted fields, or mask them if the request does not supply the key
+						d = fc.Decrypt(state.Key, d)
+						rec := map[string]interface{}{
+							common.KeyField:   state.Key,
+							common.ValueField: d,
+						}
+						if format != nil {
+							// upgrade value of old schema version
+							if m, f, err := common.MigrateValue(txCtx, d, format, 0); err != nil {
+								logger.Warnf("return state %s of old version %d that cannot be migrated: %v", state.Key, format.Version, err)
+							} else if f != format {
+								rec[common.ValueField] = m
+								schema := f.ToMap()
+								schema[common.MigratedFromField] = format.Version
+								rec[common.SchemaField] = schema
+							}
+							if _, ok := rec[common.SchemaField]; !ok {
+								// identify schema version of enveloped value
+								rec[common.SchemaField] = format.ToMap()
 							}
-							result = append(result, rec)
 						}
+						result = append(result, rec)
 					}
 				}
 			}
@@ -217,7 +266,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 // return code, result, bookmark, or error
 //   if keysOnly is true, result contains list of composite keys as []string
 //   if keysOnly is false, result contains list of state key-value as []*StateData
-func (a *Activity) retrieveData(stub shim.ChaincodeStubInterface, collection string, data interface{}, pageSize int32, bookmark string) (int, []interface{}, string, error) {
+func (a *Activity) retrieveData(store common.StateStore, collection string, data interface{}, pageSize int32, bookmark string, redactor *common.Redactor) (int, []interface{}, string, error) {
 	switch t := reflect.TypeOf(data).Kind(); t {
 	case reflect.String:
 		// retrieve state by a key
@@ -226,7 +275,7 @@ func (a *Activity) retrieveData(stub shim.ChaincodeStubInterface, collection str
 			logger.Errorf("%s", msg)
 			return 400, nil, "", errors.New(msg)
 		}
-		code, value, err := a.retrieveDataByKey(stub, collection, data.(string))
+		code, value, err := a.retrieveDataByKey(store, collection, data.(string), redactor)
 		if err != nil {
 			return code, nil, "", err
 		}
@@ -235,16 +284,16 @@ func (a *Activity) retrieveData(stub shim.ChaincodeStubInterface, collection str
 		request := data.(map[string]interface{})
 		if len(a.query) > 0 {
 			// execute rich query if query statement is defined
-			return a.retrieveDataByQuery(stub, collection, request, pageSize, bookmark)
+			return a.retrieveDataByQuery(store, collection, request, pageSize, bookmark)
 		}
 		rangeStart, okStart := request["start"]
 		rangeEnd, okEnd := request["end"]
 		if ((okStart || okEnd) && len(request) == 1) || (okStart && okEnd && len(request) == 2) {
 			// execute range query for state keys
-			return a.retrieveDataByRange(stub, collection, rangeStart, rangeEnd, pageSize, bookmark)
+			return a.retrieveDataByRange(store, collection, rangeStart, rangeEnd, pageSize, bookmark)
 		}
 		// fetch data by partial key
-		return a.retrieveDataByPartialKey(stub, collection, request, pageSize, bookmark)
+		return a.retrieveDataByPartialKey(store, collection, request, pageSize, bookmark)
 	default:
 		msg := fmt.Sprintf("invalid input data type %T", data)
 		logger.Errorf("%s", msg)
@@ -254,7 +303,7 @@ func (a *Activity) retrieveData(stub shim.ChaincodeStubInterface, collection str
 
 // retrieve data for a specified state key or composite key from the ledger or a private data collection
 // return code, state or error
-func (a *Activity) retrieveDataByKey(stub shim.ChaincodeStubInterface, collection string, key string) (int, *StateData, error) {
+func (a *Activity) retrieveDataByKey(store common.StateStore, collection string, key string, redactor *common.Redactor) (int, *StateData, error) {
 	if common.IsCompositeKey(key) {
 		return 400, nil, errors.Errorf("Cannot get state for composite key %s", key)
 	}
@@ -262,9 +311,9 @@ func (a *Activity) retrieveDataByKey(stub shim.ChaincodeStubInterface, collectio
 	var jsonBytes []byte
 	var err error
 	if a.history && len(collection) == 0 {
-		jsonBytes, err = retrieveHistory(stub, key)
+		jsonBytes, err = retrieveHistory(store, key)
 	} else {
-		_, jsonBytes, err = common.GetData(stub, collection, key, a.privateHash)
+		_, jsonBytes, err = common.GetData(store, collection, key, a.privateHash)
 	}
 	if err != nil {
 		msg := fmt.Sprintf("failed to get data '%s @ %s'", key, collection)
@@ -276,7 +325,9 @@ func (a *Activity) retrieveDataByKey(stub shim.ChaincodeStubInterface, collectio
 		logger.Debugf("%s'", msg)
 		return 404, nil, errors.New(msg)
 	}
-	logger.Debugf("retrieved data %s @ %s, data: %s", key, collection, string(jsonBytes))
+	if logger.DebugEnabled() {
+		logger.Debugf("retrieved data %s @ %s, data: %s", key, collection, redactor.RedactJSON(jsonBytes))
+	}
 
 	return 200, &StateData{Key: key, Value: jsonBytes}, nil
 }
@@ -284,7 +335,7 @@ func (a *Activity) retrieveDataByKey(stub shim.ChaincodeStubInterface, collectio
 // execute rich query for ledger states
 // returns code, result, bookmark or error
 //   rich query does not apply to composite keys, so if keysOnly is set to true, this will return error
-func (a *Activity) retrieveDataByQuery(stub shim.ChaincodeStubInterface, collection string, parameters interface{}, pageSize int32, bookmark string) (int, []interface{}, string, error) {
+func (a *Activity) retrieveDataByQuery(store common.StateStore, collection string, parameters interface{}, pageSize int32, bookmark string) (int, []interface{}, string, error) {
 	if len(a.query) == 0 {
 		msg := "rich query is not defined"
 		logger.Errorf("%s", msg)
@@ -301,11 +352,11 @@ func (a *Activity) retrieveDataByQuery(stub shim.ChaincodeStubInterface, collect
 	}
 
 	// run rich query
-	iter, queryMd, err := common.GetDataByQuery(stub, collection, qrystmt, pageSize, bookmark)
+	iter, queryMd, err := common.GetDataByQuery(store, collection, qrystmt, pageSize, bookmark)
 	if err != nil {
-		msg := fmt.Sprintf("failed rich query '%s'; error: %v", qrystmt, err)
-		logger.Errorf("%s", msg)
-		return 500, nil, "", errors.New(msg)
+		// do not log query parameters, which may be sensitive
+		logger.Errorf("failed rich query '%s'; error: %v", a.query, err)
+		return 500, nil, "", errors.Errorf("failed rich query '%s'; error: %v", qrystmt, err)
 	}
 	defer iter.Close()
 
@@ -345,7 +396,7 @@ func prepareQueryStatement(query string, params map[string]interface{}) string {
 			value = fmt.Sprintf("%v", v)
 		default:
 			if jsonBytes, err := json.Marshal(v); err != nil {
-				logger.Debugf("failed to marshal value %v: %+v", v, err)
+				logger.Debugf("failed to marshal value of query parameter %s: %+v", k, err)
 				value = "null"
 			} else {
 				value = string(jsonBytes)
@@ -353,7 +404,7 @@ func prepareQueryStatement(query string, params map[string]interface{}) string {
 		}
 		args = append(args, fmt.Sprintf(`"$%s"`, k), value)
 	}
-	logger.Debugf("query replacer args %v", args)
+	logger.Debugf("query replacer args for %d parameters", len(params))
 
 	// replace query parameters with values
 	r := strings.NewReplacer(args...)
@@ -364,7 +415,7 @@ func prepareQueryStatement(query string, params map[string]interface{}) string {
 // returns code, result, bookmark or error
 //   If keysOnly is true, error because range query works for state keys only
 //   if keysOnly is false, result is a list of state data as []*StateData
-func (a *Activity) retrieveDataByRange(stub shim.ChaincodeStubInterface, collection string, start interface{}, end interface{}, pageSize int32, bookmark string) (int, []interface{}, string, error) {
+func (a *Activity) retrieveDataByRange(store common.StateStore, collection string, start interface{}, end interface{}, pageSize int32, bookmark string) (int, []interface{}, string, error) {
 	if a.keysOnly {
 		// when keysOnly is set, cannot run range query
 		msg := "range query does not work for composite keys"
@@ -382,7 +433,7 @@ func (a *Activity) retrieveDataByRange(stub shim.ChaincodeStubInterface, collect
 	}
 
 	// run range query
-	iter, queryMd, err := common.GetDataByRange(stub, collection, rangeStart, rangeEnd, pageSize, bookmark)
+	iter, queryMd, err := common.GetDataByRange(store, collection, rangeStart, rangeEnd, pageSize, bookmark)
 
 	if err != nil {
 		msg := fmt.Sprintf("range query error: %v", err)
@@ -415,7 +466,7 @@ func (a *Activity) retrieveDataByRange(stub shim.ChaincodeStubInterface, collect
 // returns code, result, bookmark or error
 //   If keysOnly is true, result is a list of composite keys as []string
 //   if keysOnly is false, result is a list of state data as []*StateData
-func (a *Activity) retrieveDataByPartialKey(stub shim.ChaincodeStubInterface, collection string, data map[string]interface{}, pageSize int32, bookmark string) (int, []interface{}, string, error) {
+func (a *Activity) retrieveDataByPartialKey(store common.StateStore, collection string, data map[string]interface{}, pageSize int32, bookmark string) (int, []interface{}, string, error) {
 	if len(a.keyName) == 0 || len(data) == 0 {
 		msg := fmt.Sprintf("composite key %s and data %v are not specified for partial key query", a.keyName, data)
 		logger.Errorf("%s", msg)
@@ -430,7 +481,7 @@ func (a *Activity) retrieveDataByPartialKey(stub shim.ChaincodeStubInterface, co
 	}
 
 	// run partial key query to get matching composite keys
-	iter, queryMd, err := common.GetCompositeKeys(stub, collection, a.keyName, fields, pageSize, bookmark)
+	iter, queryMd, err := common.GetCompositeKeys(store, collection, a.keyName, fields, pageSize, bookmark)
 	if err != nil {
 		msg := fmt.Sprintf("partial key query error: %v", err)
 		logger.Errorf("%s", msg)
@@ -459,7 +510,7 @@ func (a *Activity) retrieveDataByPartialKey(stub shim.ChaincodeStubInterface, co
 	// fetch corresponding state data
 	var values []interface{}
 	for _, ck := range keys {
-		k, v, err := common.GetData(stub, collection, ck.(string), a.privateHash)
+		k, v, err := common.GetData(store, collection, ck.(string), a.privateHash)
 		if err != nil {
 			logger.Warnf("failed to data for composite key %s", ck)
 			continue
@@ -473,9 +524,9 @@ func (a *Activity) retrieveDataByPartialKey(stub shim.ChaincodeStubInterface, co
 }
 
 // retrieve history records of a specified state key
-func retrieveHistory(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+func retrieveHistory(store common.StateStore, key string) ([]byte, error) {
 	// retrieve data for the key
-	resultsIterator, err := stub.GetHistoryForKey(key)
+	resultsIterator, err := store.GetHistoryForKey(key)
 	if err != nil {
 		msg := "error retrieving history"
 		logger.Errorf("%s: %+v", msg, err)
@@ -518,6 +569,17 @@ func constructHistoryResponse(resultsIterator shim.HistoryQueryIteratorInterface
 		//corresponding value null. Else, we will write the response.Value
 		if response.IsDelete {
 			buffer.WriteString("null")
+		} else if common.IsEnvelope(response.Value) {
+			// write decoded value of an envelope
+			d, _, err := common.DecodeValue(response.Value)
+			if err != nil {
+				return nil, err
+			}
+			value, err := json.Marshal(d)
+			if err != nil {
+				return nil, err
+			}
+			buffer.Write(value)
 		} else {
 			buffer.WriteString(string(response.Value))
 		}
@@ -5,6 +5,8 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package get
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"os"
 	"strings"
@@ -521,3 +523,169 @@ func TestGetHistory(t *testing.T) {
 	assert.Equal(t, 500, output.Code, "action output status should be 500")
 	assert.Contains(t, output.Message, "marble", "response error shows failed query")
 }
+
+func TestMemoryStore(t *testing.T) {
+	logger.Info("TestMemoryStore")
+	act.keysOnly = false
+	act.history = false
+	act.query = queryStmt
+
+	// copy mock states to a memory store, which overrides the mock stub
+	store := common.NewMemoryStore()
+	stub.MockTransactionStart("11")
+	iter, err := stub.GetStateByRange("", "")
+	assert.NoError(t, err, "range query of mock states should not throw error")
+	for iter.HasNext() {
+		kv, _ := iter.Next()
+		store.PutState("", kv.Key, kv.Value)
+	}
+	iter.Close()
+	stub.MockTransactionEnd("11")
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	// rich query is supported by memory store
+	input := &Input{Data: map[string]interface{}{"size": 40, "owner": "tom"}, PageSize: 1}
+	err = tc.SetInputObject(input)
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err := act.Eval(tc)
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+
+	output := &Output{}
+	err = tc.GetOutputObject(output)
+	assert.NoError(t, err, "get action output should not throw error")
+	assert.Equal(t, 200, output.Code, "action output status should be 200")
+	assert.Equal(t, 1, len(output.Result), "result should contain 1 page of 1 state")
+	assert.Equal(t, "marble2", output.Bookmark, "bookmark should be the key of the next page")
+
+	// history is supported by memory store
+	act.history = true
+	input = &Input{Data: "marble1"}
+	err = tc.SetInputObject(input)
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err = act.Eval(tc)
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+
+	output = &Output{}
+	err = tc.GetOutputObject(output)
+	assert.NoError(t, err, "get action output should not throw error")
+	assert.Equal(t, 200, output.Code, "action output status should be 200")
+	history, ok := output.Result[0].(map[string]interface{})[common.ValueField].([]interface{})
+	assert.True(t, ok, "history should be an array")
+	assert.Equal(t, 1, len(history), "history should contain 1 record")
+}
+
+func TestMigrateOnRead(t *testing.T) {
+	logger.Info("TestMigrateOnRead")
+	act.keysOnly = false
+	act.history = false
+
+	err := common.LoadMigrationRules([]interface{}{map[string]interface{}{
+		"schema":    "gadget",
+		"version":   1,
+		"transform": []interface{}{map[string]interface{}{"op": "move", "from": "colour", "path": "color"}},
+	}})
+	assert.NoError(t, err, "load migration rules should not throw error")
+
+	store := common.NewMemoryStore()
+	value, err := common.EncodeValue(map[string]interface{}{"name": "gadget1", "colour": "red"}, &common.ValueFormat{Schema: "gadget", Version: 1, Encoding: common.EncodingCBOR})
+	assert.NoError(t, err, "encode gadget should not throw error")
+	store.PutState("", "gadget1", value)
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	input := &Input{Data: "gadget1"}
+	err = tc.SetInputObject(input)
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err := act.Eval(tc)
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+
+	output := &Output{}
+	err = tc.GetOutputObject(output)
+	assert.NoError(t, err, "get action output should not throw error")
+	assert.Equal(t, 200, output.Code, "action output status should be 200")
+	rec := output.Result[0].(map[string]interface{})
+	assert.Equal(t, "red", rec[common.ValueField].(map[string]interface{})["color"], "record should be upgraded to version 2")
+	schema := rec[common.SchemaField].(map[string]interface{})
+	assert.Equal(t, 2, schema["version"], "schema version should be 2")
+	assert.Equal(t, 1, schema[common.MigratedFromField], "schema should report the original version")
+
+	// upgrade is not written to the store
+	v, _ := store.GetState("", "gadget1")
+	_, f, _ := common.DecodeValue(v)
+	assert.Equal(t, 1, f.Version, "stored record should remain version 1")
+}
+
+func TestGetByTypedKey(t *testing.T) {
+	logger.Info("TestGetByTypedKey")
+	keyName, attributes := act.keyName, act.attributes
+	act.keyName, act.attributes = "size~name", []string{"$.docType", "$.size:int"}
+	act.keysOnly = true
+	act.query = ""
+	defer func() { act.keyName, act.attributes = keyName, attributes }()
+	assert.NoError(t, common.RegisterCompositeKeys(map[string][]string{act.keyName: act.attributes}), "register composite key should not throw error")
+
+	// numeric sizes are stored in natural order
+	store := common.NewMemoryStore()
+	for name, size := range map[string]float64{"marble1": 9, "marble2": 10, "marble3": 100} {
+		data := map[string]interface{}{"docType": "marble", "size": size}
+		key, _ := common.MakeCompositeKey(store, act.keyName, act.attributes, name, data)
+		store.PutState("", key, []byte{0x00})
+	}
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	err := tc.SetInputObject(&Input{Data: map[string]interface{}{"docType": "marble"}})
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err := act.Eval(tc)
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+
+	output := &Output{}
+	err = tc.GetOutputObject(output)
+	assert.NoError(t, err, "action output should not be error")
+	assert.Equal(t, 200, output.Code, "action output status should be 200")
+	keys := output.Result[0].(map[string]interface{})["keys"].([]interface{})
+	assert.Equal(t, 3, len(keys), "returned key count should be 3")
+	for i, size := range []string{"9", "10", "100"} {
+		fields := keys[i].(map[string]interface{})["fields"].([]interface{})
+		assert.Equal(t, size, fields[1], "key %d should have decoded size %s", i, size)
+	}
+}
+
+func TestGetEncrypted(t *testing.T) {
+	logger.Info("TestGetEncrypted")
+	act.keysOnly = false
+	act.history = false
+	act.encrypted = "$.owner.ssn"
+	defer func() { act.encrypted = "" }()
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	fc, err := common.NewFieldCipher(act.encrypted, key)
+	assert.NoError(t, err, "create field cipher should not throw error")
+	data, err := fc.Encrypt("user1", map[string]interface{}{"name": "tom", "owner": map[string]interface{}{"ssn": "123-45-6789"}})
+	assert.NoError(t, err, "encrypt fields should not throw error")
+	value, _ := json.Marshal(data)
+	store := common.NewMemoryStore()
+	store.PutState("", "user1", value)
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	for k, expected := range map[string]string{key: "123-45-6789", "": common.Redacted} {
+		err = tc.SetInputObject(&Input{Data: "user1", EncryptionKey: k})
+		assert.NoError(t, err, "setting action input should not throw error")
+		done, err := act.Eval(tc)
+		assert.True(t, done, "action eval should be successful")
+		assert.NoError(t, err, "action eval should not throw error")
+
+		output := &Output{}
+		err = tc.GetOutputObject(output)
+		assert.NoError(t, err, "get action output should not throw error")
+		rec := output.Result[0].(map[string]interface{})[common.ValueField].(map[string]interface{})
+		assert.Equal(t, expected, rec["owner"].(map[string]interface{})["ssn"], "ssn should be decrypted with key or masked without key")
+		assert.Equal(t, "tom", rec["name"], "name should not be masked")
+	}
+}
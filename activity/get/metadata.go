@@ -13,21 +13,31 @@ import (
 )
 
 // Settings of the activity
+// sensitive is comma-delimited JSON paths of state values that are redacted in logs
+// protoDescriptor is base64-encoded protobuf FileDescriptorSet for decoding state values of protobuf encoding
+// migrations are rules for upgrading enveloped values of old schema versions on read
+// encryptedFields is comma-delimited JSON paths of state values that are decrypted by the input encryptionKey, or masked if the key is not specified
 type Settings struct {
-	KeyName     string   `md:"keyName"`
-	Attributes  []string `md:"attributes"`
-	QueryStmt   string   `md:"queryStmt"`
-	KeysOnly    bool     `md:"keysOnly"`
-	History     bool     `md:"history"`
-	PrivateHash bool     `md:"privateHash"`
+	KeyName         string      `md:"keyName"`
+	Attributes      []string    `md:"attributes"`
+	QueryStmt       string      `md:"queryStmt"`
+	KeysOnly        bool        `md:"keysOnly"`
+	History         bool        `md:"history"`
+	PrivateHash     bool        `md:"privateHash"`
+	Sensitive       string      `md:"sensitive"`
+	ProtoDescriptor string      `md:"protoDescriptor"`
+	Migrations      interface{} `md:"migrations"`
+	EncryptedFields string      `md:"encryptedFields"`
 }
 
 // Input of the activity
+// encryptionKey is base64-encoded 256-bit AES key, e.g., mapped from transient data of the transaction
 type Input struct {
 	Data              interface{} `md:"data,required"`
 	PrivateCollection string      `md:"privateCollection"`
 	PageSize          int32       `md:"pageSize"`
 	Bookmark          string      `md:"bookmark"`
+	EncryptionKey     string      `md:"encryptionKey"`
 }
 
 // Output of the activity
@@ -51,6 +61,18 @@ func (h *Settings) FromMap(values map[string]interface{}) error {
 	if h.PrivateHash, err = coerce.ToBool(values["privateHash"]); err != nil {
 		return err
 	}
+	if h.Sensitive, err = coerce.ToString(values["sensitive"]); err != nil {
+		return err
+	}
+	if h.ProtoDescriptor, err = coerce.ToString(values["protoDescriptor"]); err != nil {
+		return err
+	}
+	if h.Migrations, err = coerce.ToAny(values["migrations"]); err != nil {
+		return err
+	}
+	if h.EncryptedFields, err = coerce.ToString(values["encryptedFields"]); err != nil {
+		return err
+	}
 
 	query, err := common.MapToObject(values["query"])
 	if err != nil {
@@ -103,6 +125,7 @@ func (i *Input) ToMap() map[string]interface{} {
 		"privateCollection": i.PrivateCollection,
 		"pageSize":          i.PageSize,
 		"bookmark":          i.Bookmark,
+		"encryptionKey":     i.EncryptionKey,
 	}
 }
 
@@ -122,6 +145,9 @@ func (i *Input) FromMap(values map[string]interface{}) error {
 	if i.Bookmark, err = coerce.ToString(values["bookmark"]); err != nil {
 		return err
 	}
+	if i.EncryptionKey, err = coerce.ToString(values["encryptionKey"]); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -85,7 +85,8 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 
 	var value interface{}
 	if err := json.Unmarshal(jsonBytes, &value); err != nil {
-		logger.Errorf("failed to unmarshal chaincode response %+v, error: %+v\n", string(jsonBytes), err)
+		// do not log the response, which may contain sensitive data
+		logger.Errorf("failed to unmarshal chaincode response of %d bytes, error: %+v\n", len(jsonBytes), err)
 		output.Result = string(jsonBytes)
 		ctx.SetOutputObject(output)
 		return true, nil
@@ -112,9 +113,9 @@ func constructChaincodeArgs(ctx activity.Context, input *Input) ([][]byte, error
 	}
 
 	// add transaction parameters
-	for _, p := range input.Parameters {
+	for i, p := range input.Parameters {
 		param := fmt.Sprintf("%v", p)
-		logger.Debugf("add chaincode parameter: %s", p)
+		logger.Debugf("add chaincode parameter %d", i)
 		result = append(result, []byte(param))
 	}
 	return result, nil
@@ -5,12 +5,11 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package put
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
-	"strings"
 
-	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/open-dovetail/fabric-chaincode/common"
 	"github.com/pkg/errors"
 	"github.com/project-flogo/core/activity"
@@ -32,6 +31,11 @@ type Activity struct {
 	compositeKeys map[string][]string
 	keysOnly      bool
 	createOnly    bool
+	sensitive     string
+	format        *common.ValueFormat
+	migrate       bool
+	uniqueKeys    map[string]bool
+	encrypted     string
 }
 
 func (a *Activity) String() string {
@@ -47,10 +51,76 @@ func New(ctx activity.InitContext) (activity.Activity, error) {
 		return nil, err
 	}
 
+	// envelope of stored values
+	if err := common.LoadProtoDescriptor(s.ProtoDescriptor); err != nil {
+		logger.Errorf("failed to load protobuf descriptor %v", err)
+		return nil, err
+	}
+	format, err := common.NewValueFormat(s.Encoding, s.SchemaName, s.SchemaVersion, s.ProtoMessage)
+	if err != nil {
+		logger.Errorf("failed to configure value format %v", err)
+		return nil, err
+	}
+	if err := common.LoadMigrationRules(s.Migrations); err != nil {
+		logger.Errorf("failed to load migration rules %v", err)
+		return nil, err
+	}
+	if s.Migrate && (format == nil || len(format.Schema) == 0) {
+		logger.Error("schemaName must be specified to migrate records")
+		return nil, errors.New("schemaName must be specified to migrate records")
+	}
+
+	// composite keys are stored in clear text, so they must not contain values of encrypted fields
+	fc, _ := common.NewFieldCipher(s.EncryptedFields, "")
+	for name, attrs := range s.CompositeKeys {
+		for _, attr := range attrs {
+			if path, _ := common.ParseKeyAttribute(attr); fc.Covers(path) {
+				logger.Errorf("composite key %s contains encrypted field %s", name, path)
+				return nil, errors.Errorf("composite key %s contains encrypted field %s", name, path)
+			}
+		}
+	}
+
+	// register attribute types for decoding composite keys
+	if err := common.RegisterCompositeKeys(s.CompositeKeys); err != nil {
+		logger.Errorf("failed to register composite keys %v", err)
+		return nil, err
+	}
+
+	// register unique keys, so they are deleted with the states by other activities
+	uniqueKeys := make(map[string]bool)
+	uniqueDefs := make(map[string][]string)
+	for _, name := range s.UniqueKeys {
+		attrs, ok := s.CompositeKeys[name]
+		if !ok {
+			logger.Errorf("unique key %s is not defined in compositeKeys", name)
+			return nil, errors.Errorf("unique key %s is not defined in compositeKeys", name)
+		}
+		uniqueKeys[name] = true
+		uniqueDefs[name] = attrs
+	}
+	if err := common.RegisterUniqueKeys(uniqueDefs); err != nil {
+		logger.Errorf("failed to register unique keys %v", err)
+		return nil, err
+	}
+
+	if format != nil {
+		// register composite keys of the schema, so they are maintained when records are migrated
+		if err := common.RegisterSchemaKeys(format.Schema, s.CompositeKeys, s.UniqueKeys); err != nil {
+			logger.Errorf("failed to register composite keys of schema %v", err)
+			return nil, err
+		}
+	}
+
 	return &Activity{
 		compositeKeys: s.CompositeKeys,
 		keysOnly:      s.KeysOnly,
 		createOnly:    s.CreateOnly,
+		sensitive:     s.Sensitive,
+		format:        format,
+		migrate:       s.Migrate,
+		uniqueKeys:    uniqueKeys,
+		encrypted:     s.EncryptedFields,
 	}, nil
 }
 
@@ -69,29 +139,41 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		return false, err
 	}
 
-	if strings.HasPrefix(input.PrivateCollection, "_implicit") {
-		// override implicit collection using client's org
-		mspid, err := common.ResolveFlowData("$.cid.mspid", ctx)
-		if err != nil {
-			logger.Debugf("failed to fetch client mspid: %v\n", err)
-		} else {
-			if msp, ok := mspid.(string); ok && len(msp) > 0 {
-				input.PrivateCollection = "_implicit_org_" + msp
-				logger.Debugf("set implicit PDC to %s\n", input.PrivateCollection)
-			}
-		}
+	// resolve collection alias and implicit collection of the org
+	if input.PrivateCollection, err = common.ResolveCollection(ctx, input.PrivateCollection); err != nil {
+		logger.Errorf("failed to resolve private collection: %v", err)
+		output := &Output{Code: 400, Message: err.Error()}
+		ctx.SetOutputObject(output)
+		return false, err
 	}
 
-	// get chaincode stub
-	stub, err := common.GetChaincodeStub(ctx)
-	if err != nil || stub == nil {
-		msg := fmt.Sprintf("failed to retrieve fabric stub: %v", err)
+	// get state store
+	store, err := common.GetStateStore(ctx)
+	if err != nil || store == nil {
+		msg := fmt.Sprintf("failed to retrieve state store: %v", err)
 		logger.Errorf("%s", msg)
 		output := &Output{Code: 500, Message: msg}
 		ctx.SetOutputObject(output)
 		return false, err
 	}
 
+	// redact sensitive data in logs
+	redactor := common.GetRedactor(ctx, a.sensitive)
+
+	// encrypt fields by the key of the request
+	fc, err := common.NewFieldCipher(a.encrypted, input.EncryptionKey)
+	if err != nil {
+		logger.Errorf("invalid encryption key: %v", err)
+		output := &Output{Code: 400, Message: err.Error()}
+		ctx.SetOutputObject(output)
+		return false, err
+	}
+	// bind nonces to the transaction, so all endorsing peers write the same ciphertext
+	fc = fc.WithTxID(common.TransactionID(ctx))
+
+	// transaction context for migration subflows
+	txCtx := common.TransactionContext(ctx)
+
 	var code int
 	var value []interface{}
 
@@ -101,10 +183,10 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		for _, item := range data {
 			d, ok := item.(map[string]interface{})
 			if !ok {
-				logger.Warnf("ignore bad input data %v", item)
+				logger.Warnf("ignore bad input data of type %T", item)
 				continue
 			}
-			c, v, e := a.storeData(stub, input.PrivateCollection, d)
+			c, v, e := a.storeData(txCtx, store, input.PrivateCollection, d, fc, redactor)
 			if e != nil {
 				err = e
 			}
@@ -118,7 +200,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 	case reflect.Map:
 		// update single data object
 		data := input.Data.(map[string]interface{})
-		code, value, err = a.storeData(stub, input.PrivateCollection, data)
+		code, value, err = a.storeData(txCtx, store, input.PrivateCollection, data, fc, redactor)
 	default:
 		msg := fmt.Sprintf("invalid input data type %T", input.Data)
 		logger.Errorf("%s", msg)
@@ -188,10 +270,16 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 // returns status code, updated states or composite keys, or error
 //   - if input data is key-value, return the key-value object for updated states
 //   - if input data is not key-value, return list of created composite-keys
-func (a *Activity) storeData(stub shim.ChaincodeStubInterface, collection string, data map[string]interface{}) (int, []interface{}, error) {
+// key-value data may contain the schema attribute returned by the get activity, which is used to upgrade old records if migrate is true
+func (a *Activity) storeData(txCtx context.Context, store common.StateStore, collection string, data map[string]interface{}, fc *common.FieldCipher, redactor *common.Redactor) (int, []interface{}, error) {
 	key := data[common.KeyField]
 	value := data[common.ValueField]
-	if len(data) == 2 && key != nil && value != nil {
+	schema, hasSchema := data[common.SchemaField]
+	size := 2
+	if hasSchema {
+		size = 3
+	}
+	if len(data) == size && key != nil && value != nil {
 		// this is key-value for state update
 		if a.keysOnly {
 			logger.Warnf("update state key %s although activity is configured to write keys only", key)
@@ -200,7 +288,21 @@ func (a *Activity) storeData(stub shim.ChaincodeStubInterface, collection string
 		if err != nil {
 			return 400, nil, errors.Errorf("invalid state key: %v", key)
 		}
-		code, err := a.putData(stub, collection, stateKey, value)
+		if a.migrate && hasSchema {
+			upgraded, err := a.upgrade(txCtx, schema, value)
+			if err != nil {
+				return 400, nil, errors.Wrapf(err, "failed to migrate state %s", stateKey)
+			}
+			if upgraded != nil {
+				value = upgraded
+				data = map[string]interface{}{
+					common.KeyField:    stateKey,
+					common.ValueField:  value,
+					common.SchemaField: a.format.ToMap(),
+				}
+			}
+		}
+		code, err := a.putData(store, collection, stateKey, value, fc, redactor)
 		if err != nil {
 			return code, nil, err
 		}
@@ -213,14 +315,14 @@ func (a *Activity) storeData(stub shim.ChaincodeStubInterface, collection string
 	}
 
 	// store composite keys
-	code, keys, err := a.putCompositeKey(stub, collection, data)
+	code, keys, err := a.putCompositeKey(store, collection, data)
 	if err != nil {
 		return code, nil, err
 	}
 	var result []interface{}
 	for _, k := range keys {
 		// construct key objects from composite key strings
-		if c, err := common.SplitCompositeKey(stub, k); err == nil {
+		if c, err := common.SplitCompositeKey(store, k); err == nil {
 			bag := &common.CompositeKeyBag{
 				Name:       c.Name,
 				Attributes: a.compositeKeys[c.Name],
@@ -233,52 +335,104 @@ func (a *Activity) storeData(stub shim.ChaincodeStubInterface, collection string
 	return code, result, nil
 }
 
+// upgrade converts a value of the schema version of a get result to the configured schema version,
+// and returns nil if the value is of another schema or already of the configured version
+func (a *Activity) upgrade(txCtx context.Context, schema interface{}, value interface{}) (interface{}, error) {
+	attrs, err := coerce.ToObject(schema)
+	if err != nil {
+		return nil, errors.Errorf("invalid schema attribute %v", schema)
+	}
+	name, _ := coerce.ToString(attrs["name"])
+	if name != a.format.Schema {
+		return nil, nil
+	}
+	version, err := coerce.ToInt(attrs["version"])
+	if err != nil {
+		return nil, errors.Errorf("invalid schema version %v", attrs["version"])
+	}
+	if version == a.format.Version {
+		return nil, nil
+	}
+	if version > a.format.Version {
+		return nil, errors.Errorf("cannot downgrade %s from version %d to %d", name, version, a.format.Version)
+	}
+	encoding, _ := coerce.ToString(attrs["encoding"])
+	upgraded, _, err := common.MigrateValue(txCtx, value, &common.ValueFormat{Schema: name, Version: version, Encoding: encoding}, a.format.Version)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("migrated %s from version %d to %d", name, version, a.format.Version)
+	return upgraded, nil
+}
+
 // update specified key-value on ledger or private data collection, and create associated composite keys
+// composite keys of the prior value that do not match the new value are deleted
 // if createOnly setting is true, do not update it, instead return 409 if already exist
+// return 409 if a unique composite key of the new value is used by another state
+// fields of the data are encrypted if the activity specifies encryptedFields
 // returns status code, updated state object, or error
-func (a *Activity) putData(stub shim.ChaincodeStubInterface, collection string, key string, data interface{}) (int, error) {
+func (a *Activity) putData(store common.StateStore, collection string, key string, data interface{}, fc *common.FieldCipher, redactor *common.Redactor) (int, error) {
 	if len(key) == 0 {
 		return 400, errors.New("state key is not specified")
 	}
-	if a.createOnly {
-		// check if key already exist
-		if _, v, err := common.GetData(stub, collection, key, false); err == nil && v != nil {
-			return 409, errors.New("state key already exists")
+	data, err := fc.Encrypt(key, data)
+	if err != nil {
+		msg := fmt.Sprintf("failed to encrypt data of key %s", key)
+		logger.Errorf("%s: %+v", msg, err)
+		return 400, errors.Wrapf(err, msg)
+	}
+	var priorKeys []string
+	if a.createOnly || len(a.compositeKeys) > 0 {
+		// read prior value to check if key already exist, and to find its composite keys
+		_, prior, err := common.GetData(store, collection, key, false)
+		if err != nil {
+			msg := fmt.Sprintf("failed to read prior data %s @ %s", key, collection)
+			logger.Errorf("%s: %+v", msg, err)
+			return 500, errors.Wrapf(err, msg)
+		}
+		if prior != nil {
+			if a.createOnly {
+				return 409, errors.New("state key already exists")
+			}
+			if d, _, err := common.DecodeValue(prior); err != nil {
+				logger.Warnf("failed to decode prior data of key %s, its composite keys are not updated: %v", key, err)
+			} else {
+				priorKeys = common.ExtractCompositeKeys(store, a.compositeKeys, key, d)
+			}
 		}
 	}
-	jsonBytes, err := json.Marshal(data)
+	// verify new composite keys of unique constraints before updating the state
+	stale, compKeys := common.DiffCompositeKeys(priorKeys, common.ExtractCompositeKeys(store, a.compositeKeys, key, data))
+	if code, err := a.checkUniqueKeys(store, collection, compKeys); err != nil {
+		return code, err
+	}
+
+	value, err := common.EncodeValue(data, a.format)
 	if err != nil {
-		msg := fmt.Sprintf("failed to marshal data: %+v", data)
+		msg := fmt.Sprintf("failed to encode data of key %s", key)
 		logger.Errorf("%s: %+v", msg, err)
 		return 400, errors.Wrapf(err, msg)
 	}
 
 	// store data on ledger or private data collection
-	if err := common.PutData(stub, collection, key, jsonBytes); err != nil {
+	if err := common.PutData(store, collection, key, value); err != nil {
 		msg := fmt.Sprintf("failed to store data %s @ %s", key, collection)
 		logger.Errorf("%s: %+v", msg, err)
 		return 500, errors.Wrapf(err, msg)
 	}
-	logger.Debugf("stored data %s @ %s, data: %s", key, collection, string(jsonBytes))
-
-	// store composite keys if required
-	compKeys := common.ExtractCompositeKeys(stub, a.compositeKeys, key, data)
-	if len(compKeys) > 0 {
-		for _, k := range compKeys {
-			if err := common.PutData(stub, collection, k, nil); err != nil {
-				logger.Warnf("failed to store composite key %s @ %s: %+v", k, collection, err)
-			} else {
-				logger.Debugf("stored composite key %s @ %s", k, collection)
-			}
-		}
+	if logger.DebugEnabled() {
+		logger.Debugf("stored data %s @ %s, data: %s", key, collection, redactor.RedactJSON(value))
 	}
 
+	// delete composite keys of the prior value that do not match the new value, and store new composite keys
+	common.WriteCompositeKeys(store, collection, stale, compKeys)
+
 	return 200, nil
 }
 
 // create composite keys on ledger or private collection
 // returns status code, list of composite keys, or error
-func (a *Activity) putCompositeKey(stub shim.ChaincodeStubInterface, collection string, data map[string]interface{}) (int, []string, error) {
+func (a *Activity) putCompositeKey(store common.StateStore, collection string, data map[string]interface{}) (int, []string, error) {
 	if len(data) == 0 {
 		return 400, nil, errors.New("attributes for composite keys are not specified")
 	}
@@ -288,8 +442,11 @@ func (a *Activity) putCompositeKey(stub shim.ChaincodeStubInterface, collection
 	// put only complete keys that contain all attributes
 	var result []string
 	for name, attrs := range a.compositeKeys {
-		if key, isComplete := common.MakeCompositeKey(stub, name, attrs, "", data); isComplete {
-			if err := common.PutData(stub, collection, key, nil); err == nil {
+		if key, isComplete := common.MakeCompositeKey(store, name, attrs, "", data); isComplete {
+			if code, err := a.checkUniqueKeys(store, collection, []string{key}); err != nil {
+				return code, nil, err
+			}
+			if err := common.PutData(store, collection, key, nil); err == nil {
 				result = append(result, key)
 			}
 		}
@@ -300,3 +457,20 @@ func (a *Activity) putCompositeKey(stub shim.ChaincodeStubInterface, collection
 	}
 	return 404, nil, errors.New("data not complete for any composite key")
 }
+
+// checkUniqueKeys verifies that unique composite keys are not used by other states
+// returns 409 if a unique key conflicts with another state, or 500 if the partial key query failed
+func (a *Activity) checkUniqueKeys(store common.StateStore, collection string, keys []string) (int, error) {
+	if len(a.uniqueKeys) == 0 {
+		return 0, nil
+	}
+	err := common.CheckUniqueKeys(store, collection, keys, a.uniqueKeys)
+	if err == nil {
+		return 0, nil
+	}
+	if _, ok := err.(*common.UniqueKeyError); ok {
+		return 409, err
+	}
+	logger.Errorf("%+v", err)
+	return 500, err
+}
@@ -5,14 +5,18 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package put
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/hyperledger/fabric-chaincode-go/shimtest"
 	"github.com/open-dovetail/fabric-chaincode/common"
 	"github.com/pkg/errors"
 	"github.com/project-flogo/core/data/mapper"
+	"github.com/project-flogo/core/data/property"
 	"github.com/project-flogo/core/data/resolve"
 	"github.com/project-flogo/core/support/test"
 	"github.com/stretchr/testify/assert"
@@ -493,3 +497,295 @@ func TestPutCompositeKey2(t *testing.T) {
 
 	stub.MockTransactionEnd("11")
 }
+
+func TestPutEnvelope(t *testing.T) {
+	logger.Info("TestPutEnvelope")
+	act.keysOnly = false
+	act.createOnly = false
+	format, err := common.NewValueFormat(common.EncodingCBOR, "marble", 2, "")
+	assert.NoError(t, err, "CBOR value format should be valid")
+	act.format = format
+	defer func() { act.format = nil }()
+
+	// store data in a memory store
+	store := common.NewMemoryStore()
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	state := map[string]interface{}{
+		"key": "marble1",
+		"value": map[string]interface{}{
+			"docType": "marble",
+			"name":    "marble1",
+			"color":   "blue",
+			"size":    50,
+			"owner":   "tom",
+		},
+	}
+	err = tc.SetInputObject(&Input{Data: state})
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err := act.Eval(tc)
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+
+	// verify enveloped value
+	val, err := store.GetState("", "marble1")
+	assert.NoError(t, err, "retrieve state of marble1 should not throw error")
+	assert.True(t, common.IsEnvelope(val), "stored value should be enveloped")
+	rec, f, err := common.DecodeValue(val)
+	assert.NoError(t, err, "decode stored value should not throw error")
+	assert.Equal(t, 2, f.Version, "stored value should have schema version 2")
+	assert.Equal(t, "blue", rec.(map[string]interface{})["color"], "stored record should have color = 'blue'")
+
+	// composite keys are not enveloped
+	iter, _, err := store.GetStateByPartialCompositeKey("", "owner~name", []string{"marble", "tom"}, 0, "")
+	assert.NoError(t, err, "composite key query for owner should not throw error")
+	assert.True(t, iter.HasNext(), "composite key query should return a key")
+	v, _ := iter.Next()
+	assert.Equal(t, []byte{0x00}, v.Value, "composite key value should not be enveloped")
+}
+
+func TestPutMigrate(t *testing.T) {
+	logger.Info("TestPutMigrate")
+	act.keysOnly = false
+	act.createOnly = false
+	act.format = &common.ValueFormat{Schema: "marble", Version: 2, Encoding: common.EncodingJSON}
+	act.migrate = true
+	defer func() { act.format, act.migrate = nil, false }()
+
+	err := common.RegisterMigrationRules([]*common.MigrationRule{{
+		Schema:    "marble",
+		Version:   1,
+		Transform: []*common.TransformOp{{Op: "move", From: "colour", Path: "color"}},
+	}})
+	assert.NoError(t, err, "register migration rules should not throw error")
+
+	store := common.NewMemoryStore()
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	// write back a record of version 1 returned by the get activity
+	state := map[string]interface{}{
+		"key":    "marble9",
+		"value":  map[string]interface{}{"docType": "marble", "name": "marble9", "colour": "red", "owner": "jerry"},
+		"schema": map[string]interface{}{"name": "marble", "version": 1, "encoding": "json"},
+	}
+	err = tc.SetInputObject(&Input{Data: state})
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err := act.Eval(tc)
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+
+	val, err := store.GetState("", "marble9")
+	assert.NoError(t, err, "retrieve state of marble9 should not throw error")
+	rec, f, err := common.DecodeValue(val)
+	assert.NoError(t, err, "decode stored value should not throw error")
+	assert.Equal(t, 2, f.Version, "stored value should have schema version 2")
+	assert.Equal(t, "red", rec.(map[string]interface{})["color"], "stored record should be upgraded to version 2")
+
+	// reject record of a newer version
+	state["schema"] = map[string]interface{}{"name": "marble", "version": 3, "encoding": "json"}
+	err = tc.SetInputObject(&Input{Data: state})
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err = act.Eval(tc)
+	assert.False(t, done, "action eval should fail for newer version")
+	output := &Output{}
+	err = tc.GetOutputObject(output)
+	assert.NoError(t, err, "get action output should not throw error")
+	assert.Equal(t, 400, output.Code, "action output status should be 400")
+}
+
+func TestPutStaleCompositeKeys(t *testing.T) {
+	logger.Info("TestPutStaleCompositeKeys")
+	act.keysOnly = false
+	act.createOnly = false
+
+	store := common.NewMemoryStore()
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	for _, collection := range []string{"", "_implicit_org_Org1MSP"} {
+		for _, owner := range []string{"tom", "jerry"} {
+			state := map[string]interface{}{
+				"key":   "marble1",
+				"value": map[string]interface{}{"docType": "marble", "name": "marble1", "color": "blue", "owner": owner},
+			}
+			err := tc.SetInputObject(&Input{Data: state, PrivateCollection: collection})
+			assert.NoError(t, err, "setting action input should not throw error")
+			done, err := act.Eval(tc)
+			assert.True(t, done, "action eval should be successful")
+			assert.NoError(t, err, "action eval should not throw error")
+		}
+
+		// composite key of the prior owner is deleted, and color key is kept
+		for owner, count := range map[string]int{"tom": 0, "jerry": 1} {
+			iter, _, err := store.GetStateByPartialCompositeKey(collection, "owner~name", []string{"marble", owner}, 0, "")
+			assert.NoError(t, err, "composite key query for owner should not throw error")
+			n := 0
+			for iter.HasNext() {
+				iter.Next()
+				n++
+			}
+			iter.Close()
+			assert.Equal(t, count, n, "composite keys of owner %s @ '%s' should count to %d", owner, collection, count)
+		}
+		iter, _, err := store.GetStateByPartialCompositeKey(collection, "color~name", []string{"marble", "blue"}, 0, "")
+		assert.NoError(t, err, "composite key query for color should not throw error")
+		assert.True(t, iter.HasNext(), "composite key of unchanged color should be kept")
+		iter.Close()
+	}
+}
+
+func TestPutUniqueKey(t *testing.T) {
+	logger.Info("TestPutUniqueKey")
+	act.keysOnly = false
+	act.createOnly = false
+	compositeKeys := act.compositeKeys
+	act.compositeKeys = map[string][]string{"email~id": {"$.docType", "$.email"}}
+	act.uniqueKeys = map[string]bool{"email~id": true}
+	defer func() { act.compositeKeys, act.uniqueKeys = compositeKeys, nil }()
+
+	store := common.NewMemoryStore()
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	putUser := func(id, email string) int {
+		state := map[string]interface{}{
+			"key":   id,
+			"value": map[string]interface{}{"docType": "user", "id": id, "email": email},
+		}
+		err := tc.SetInputObject(&Input{Data: state})
+		assert.NoError(t, err, "setting action input should not throw error")
+		act.Eval(tc)
+		output := &Output{}
+		err = tc.GetOutputObject(output)
+		assert.NoError(t, err, "get action output should not throw error")
+		return output.Code
+	}
+	assert.Equal(t, 200, putUser("user1", "tom@example.com"), "first user should be created")
+	assert.Equal(t, 409, putUser("user2", "tom@example.com"), "second user of the same email should be rejected")
+	v, _ := store.GetState("", "user2")
+	assert.Nil(t, v, "rejected user should not be stored")
+	assert.Equal(t, 200, putUser("user1", "tom@example.com"), "update of the same user should succeed")
+	assert.Equal(t, 200, putUser("user1", "thomas@example.com"), "change of email should succeed")
+	assert.Equal(t, 200, putUser("user2", "tom@example.com"), "released email should be reused")
+}
+
+func TestPutEncrypted(t *testing.T) {
+	logger.Info("TestPutEncrypted")
+	act.keysOnly = false
+	act.createOnly = false
+	act.encrypted = "$.owner.ssn"
+	defer func() { act.encrypted = "" }()
+
+	store := common.NewMemoryStore()
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+
+	state := map[string]interface{}{
+		"key":   "user1",
+		"value": map[string]interface{}{"name": "tom", "owner": map[string]interface{}{"ssn": "123-45-6789"}},
+	}
+
+	// reject request without key
+	err := tc.SetInputObject(&Input{Data: state})
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, _ := act.Eval(tc)
+	assert.False(t, done, "action eval should fail without encryption key")
+	output := &Output{}
+	err = tc.GetOutputObject(output)
+	assert.NoError(t, err, "get action output should not throw error")
+	assert.Equal(t, 400, output.Code, "action output status should be 400")
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	err = tc.SetInputObject(&Input{Data: state, EncryptionKey: key})
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err = act.Eval(tc)
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+
+	val, _ := store.GetState("", "user1")
+	assert.False(t, strings.Contains(string(val), "123-45-6789"), "ssn should not be stored in clear text")
+	assert.True(t, strings.Contains(string(val), common.EncryptedPrefix), "ssn should be encrypted")
+	assert.True(t, strings.Contains(string(val), "tom"), "name should not be encrypted")
+
+	// endorsing peers write the same ciphertext for a transaction
+	tc.ActivityHost().Scope().SetValue(common.FabricTxID, "tx1")
+	defer tc.ActivityHost().Scope().SetValue(common.FabricTxID, nil)
+	var vals []string
+	for i := 0; i < 2; i++ {
+		store.DelState("", "user1")
+		done, err = act.Eval(tc)
+		assert.True(t, done, "action eval should be successful")
+		assert.NoError(t, err, "action eval should not throw error")
+		val, _ = store.GetState("", "user1")
+		vals = append(vals, string(val))
+	}
+	assert.Equal(t, vals[0], vals[1], "same data should be encrypted to the same bytes in a transaction")
+
+	// reject composite keys of encrypted fields
+	settings := map[string]interface{}{
+		"compositeKeys":   `{"owner~name": ["$.owner.ssn", "$.name"]}`,
+		"encryptedFields": "$.owner.ssn",
+	}
+	_, err = New(test.NewActivityInitContext(settings, mapper.NewFactory(resolve.GetBasicResolver())))
+	assert.Error(t, err, "composite key of encrypted field should be rejected")
+}
+
+func TestPutCollectionAlias(t *testing.T) {
+	logger.Info("TestPutCollectionAlias")
+	act.keysOnly = false
+	act.createOnly = false
+
+	store := common.NewMemoryStore()
+	tc.ActivityHost().Scope().SetValue(common.FabricStateStore, store)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStateStore, nil)
+	stub := shimtest.NewMockStub("mock", nil)
+	tc.ActivityHost().Scope().SetValue(common.FabricStub, stub)
+	defer tc.ActivityHost().Scope().SetValue(common.FabricStub, nil)
+
+	manager := property.DefaultManager()
+	defer property.SetDefaultManager(manager)
+	property.SetDefaultManager(property.NewManager(map[string]interface{}{
+		common.CollectionAliasPrefix + "marblePrivate": `{"mychannel": "_implicit_org_self", "*": "marbles"}`,
+		common.CollectionAliasPrefix + "marbleDetail":  map[string]interface{}{"otherchannel": "details"},
+	}))
+	os.Setenv("CORE_PEER_LOCALMSPID", "Org1MSP")
+	defer os.Unsetenv("CORE_PEER_LOCALMSPID")
+
+	state := map[string]interface{}{
+		"key":   "marble1",
+		"value": map[string]interface{}{"docType": "marble", "name": "marble1", "color": "blue", "owner": "tom"},
+	}
+	for channel, collection := range map[string]string{"mychannel": "_implicit_org_Org1MSP", "yourchannel": "marbles"} {
+		stub.ChannelID = channel
+		err := tc.SetInputObject(&Input{Data: state, PrivateCollection: "marblePrivate"})
+		assert.NoError(t, err, "setting action input should not throw error")
+		done, err := act.Eval(tc)
+		assert.True(t, done, "action eval should be successful")
+		assert.NoError(t, err, "action eval should not throw error")
+		val, err := store.GetState(collection, "marble1")
+		assert.NoError(t, err, "get state should not throw error")
+		assert.NotNil(t, val, "alias on channel %s should be resolved to collection %s", channel, collection)
+	}
+
+	// implicit collection of a named org is used as is
+	err := tc.SetInputObject(&Input{Data: state, PrivateCollection: "_implicit_org_Org2MSP"})
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err := act.Eval(tc)
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+	val, err := store.GetState("_implicit_org_Org2MSP", "marble1")
+	assert.NoError(t, err, "get state should not throw error")
+	assert.NotNil(t, val, "implicit collection of Org2MSP should be updated")
+
+	// alias is not defined for the channel
+	err = tc.SetInputObject(&Input{Data: state, PrivateCollection: "marbleDetail"})
+	assert.NoError(t, err, "setting action input should not throw error")
+	done, err = act.Eval(tc)
+	assert.False(t, done, "action eval should fail for undefined alias")
+	output := &Output{}
+	err = tc.GetOutputObject(output)
+	assert.NoError(t, err, "action output should not be error")
+	assert.Equal(t, 400, output.Code, "status code should be 400")
+}
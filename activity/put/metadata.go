@@ -12,16 +12,34 @@ import (
 )
 
 // Settings of the activity
+// sensitive is comma-delimited JSON paths of state values that are redacted in logs
+// encoding, schemaName and schemaVersion specify the envelope of stored values, which are stored as plain JSON if not specified
+// protoDescriptor is base64-encoded protobuf FileDescriptorSet that defines protoMessage for protobuf encoding
+// migrate upgrades input records of old schema versions to schemaVersion by using the rules of migrations
+// uniqueKeys are names of composite keys whose attributes, other than the state key, must not be shared by different states
+// encryptedFields is comma-delimited JSON paths of state values that are encrypted by the input encryptionKey
 type Settings struct {
-	CompositeKeys map[string][]string `md:"compositeKeys"`
-	KeysOnly      bool                `md:"keysOnly"`
-	CreateOnly    bool                `md:"createOnly"`
+	CompositeKeys   map[string][]string `md:"compositeKeys"`
+	KeysOnly        bool                `md:"keysOnly"`
+	CreateOnly      bool                `md:"createOnly"`
+	Sensitive       string              `md:"sensitive"`
+	Encoding        string              `md:"encoding,allowed(json,cbor,protobuf)"`
+	SchemaName      string              `md:"schemaName"`
+	SchemaVersion   int                 `md:"schemaVersion"`
+	ProtoDescriptor string              `md:"protoDescriptor"`
+	ProtoMessage    string              `md:"protoMessage"`
+	Migrate         bool                `md:"migrate"`
+	Migrations      interface{}         `md:"migrations"`
+	UniqueKeys      []string            `md:"uniqueKeys"`
+	EncryptedFields string              `md:"encryptedFields"`
 }
 
 // Input of the activity
+// encryptionKey is base64-encoded 256-bit AES key, e.g., mapped from transient data of the transaction
 type Input struct {
 	Data              interface{} `md:"data,required"`
 	PrivateCollection string      `md:"privateCollection"`
+	EncryptionKey     string      `md:"encryptionKey"`
 }
 
 // Output of the activity
@@ -41,6 +59,42 @@ func (h *Settings) FromMap(values map[string]interface{}) error {
 	if h.CreateOnly, err = coerce.ToBool(values["createOnly"]); err != nil {
 		return err
 	}
+	if h.Sensitive, err = coerce.ToString(values["sensitive"]); err != nil {
+		return err
+	}
+	if h.Encoding, err = coerce.ToString(values["encoding"]); err != nil {
+		return err
+	}
+	if h.SchemaName, err = coerce.ToString(values["schemaName"]); err != nil {
+		return err
+	}
+	if h.SchemaVersion, err = coerce.ToInt(values["schemaVersion"]); err != nil {
+		return err
+	}
+	if h.ProtoDescriptor, err = coerce.ToString(values["protoDescriptor"]); err != nil {
+		return err
+	}
+	if h.ProtoMessage, err = coerce.ToString(values["protoMessage"]); err != nil {
+		return err
+	}
+	if h.EncryptedFields, err = coerce.ToString(values["encryptedFields"]); err != nil {
+		return err
+	}
+	if h.Migrate, err = coerce.ToBool(values["migrate"]); err != nil {
+		return err
+	}
+	if h.Migrations, err = coerce.ToAny(values["migrations"]); err != nil {
+		return err
+	}
+	unique, err := coerce.ToArray(values["uniqueKeys"])
+	if err != nil {
+		return err
+	}
+	for _, u := range unique {
+		if name, ok := u.(string); ok && len(name) > 0 {
+			h.UniqueKeys = append(h.UniqueKeys, name)
+		}
+	}
 
 	keys, err := common.MapToObject(values["compositeKeys"])
 	if err != nil || len(keys) == 0 {
@@ -78,6 +132,7 @@ func (i *Input) ToMap() map[string]interface{} {
 	return map[string]interface{}{
 		"data":              i.Data,
 		"privateCollection": i.PrivateCollection,
+		"encryptionKey":     i.EncryptionKey,
 	}
 }
 
@@ -91,6 +146,9 @@ func (i *Input) FromMap(values map[string]interface{}) error {
 	if i.PrivateCollection, err = coerce.ToString(values["privateCollection"]); err != nil {
 		return err
 	}
+	if i.EncryptionKey, err = coerce.ToString(values["encryptionKey"]); err != nil {
+		return err
+	}
 
 	return nil
 }
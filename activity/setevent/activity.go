@@ -25,11 +25,17 @@ func init() {
 
 // Activity is a stub for executing Hyperledger Fabric setevent operations
 type Activity struct {
+	sensitive string
 }
 
 // New creates a new Activity
 func New(ctx activity.InitContext) (activity.Activity, error) {
-	return &Activity{}, nil
+	s := &Settings{}
+	if err := s.FromMap(ctx.Settings()); err != nil {
+		logger.Errorf("failed to configure SetEvent activity %v", err)
+		return nil, err
+	}
+	return &Activity{sensitive: s.Sensitive}, nil
 }
 
 // Metadata implements activity.Activity.Metadata
@@ -54,12 +60,14 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 	}
 	logger.Debugf("event name: %s", input.Name)
 
+	// redact sensitive data, so they are not visible to event listeners
+	payload := common.GetRedactor(ctx, a.sensitive).Redact(input.Payload)
 	var jsonBytes []byte
-	if input.Payload != nil {
-		jsonBytes, err = json.Marshal(input.Payload)
+	if payload != nil {
+		jsonBytes, err = json.Marshal(payload)
 		if err != nil {
-			logger.Warnf("failed to marshal payload '%+v', error: %+v\n", input.Payload, err)
-			pl := fmt.Sprintf("%v", input.Payload)
+			logger.Warnf("failed to marshal payload of type %T, error: %+v\n", payload, err)
+			pl := fmt.Sprintf("%v", payload)
 			jsonBytes = []byte(pl)
 		}
 	}
@@ -86,7 +94,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 
 	result := map[string]interface{}{
 		"name":    input.Name,
-		"payload": input.Payload,
+		"payload": payload,
 	}
 	msgbytes, _ := json.Marshal(result)
 	logger.Debugf("set activity output result: %v", result)
@@ -56,3 +56,37 @@ func TestSetEvent(t *testing.T) {
 	json.Unmarshal(event.GetPayload(), &evtData)
 	assert.Equal(t, "test event", evtData["data"].(string), "event payload data should be 'test event'")
 }
+
+func TestSensitiveEvent(t *testing.T) {
+
+	mf := mapper.NewFactory(resolve.GetBasicResolver())
+	ctx := test.NewActivityInitContext(Settings{Sensitive: "owner.ssn"}, mf)
+	act, err := New(ctx)
+	assert.NoError(t, err, "create action instance should not throw error")
+
+	tc := test.NewActivityContext(act.Metadata())
+	stub := shimtest.NewMockStub("mock", nil)
+	tc.ActivityHost().Scope().SetValue(common.FabricStub, stub)
+	tc.ActivityHost().Scope().SetValue(common.FabricSensitive, []string{"secret"})
+
+	input := &Input{
+		Name: "test",
+		Payload: map[string]interface{}{
+			"name":   "marble1",
+			"secret": "abc",
+			"owner":  map[string]interface{}{"name": "tom", "ssn": "123-45-6789"},
+		},
+	}
+	err = tc.SetInputObject(input)
+	assert.NoError(t, err, "setting action input should not throw error")
+
+	stub.MockTransactionStart("1")
+	done, err := act.Eval(tc)
+	stub.MockTransactionEnd("1")
+	assert.True(t, done, "action eval should be successful")
+	assert.NoError(t, err, "action eval should not throw error")
+
+	// verify event data
+	event := <-stub.ChaincodeEventsChannel
+	assert.Equal(t, `{"name":"marble1","owner":{"name":"tom","ssn":"***"},"secret":"***"}`, string(event.GetPayload()), "sensitive data should be redacted in event payload")
+}
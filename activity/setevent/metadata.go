@@ -9,7 +9,9 @@ import (
 )
 
 // Settings of the activity
+// sensitive is comma-delimited JSON paths of event payload that are redacted in logs and events
 type Settings struct {
+	Sensitive string `md:"sensitive"`
 }
 
 // Input of the activity
@@ -25,6 +27,15 @@ type Output struct {
 	Result  map[string]interface{} `md:"result"`
 }
 
+// FromMap sets settings from a map
+func (h *Settings) FromMap(values map[string]interface{}) error {
+	var err error
+	if h.Sensitive, err = coerce.ToString(values["sensitive"]); err != nil {
+		return err
+	}
+	return nil
+}
+
 // ToMap converts activity input to a map
 func (i *Input) ToMap() map[string]interface{} {
 	return map[string]interface{}{
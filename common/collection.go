@@ -0,0 +1,134 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/pkg/errors"
+	"github.com/project-flogo/core/activity"
+	"github.com/project-flogo/core/data/property"
+)
+
+const (
+	// ImplicitCollectionPrefix is the prefix of the implicit private data collection of an org, e.g., _implicit_org_Org1MSP
+	ImplicitCollectionPrefix = "_implicit_org_"
+	// ImplicitSelfCollection is the implicit private data collection of the peer's own org
+	ImplicitSelfCollection = "_implicit_org_self"
+	// CollectionAliasPrefix is the prefix of app properties that define logical names of private data collections,
+	// e.g., the property 'collection.marblePrivate' defines the alias 'marblePrivate'
+	CollectionAliasPrefix = "collection."
+	// AnyChannel is the key of an alias definition for channels that are not listed explicitly
+	AnyChannel = "*"
+
+	implicitPrefix = "_implicit"
+)
+
+// ResolveCollection returns the name of the private data collection that an activity should access:
+//   - a logical alias defined by an app property 'collection.<alias>' is replaced by its collection for the channel of the transaction;
+//   - _implicit_org_self is replaced by the implicit collection of the peer's org;
+//   - _implicit_org_<MSPID> is used as is;
+//   - any other name starting with _implicit is replaced by the implicit collection of the client's org.
+//
+// The value of an alias property is either a collection name, or a JSON object that maps channel IDs to collection names,
+// where the key '*' specifies the collection of other channels.
+func ResolveCollection(ctx activity.Context, collection string) (string, error) {
+	if len(collection) == 0 {
+		return collection, nil
+	}
+	if def, ok := collectionAlias(collection); ok {
+		channel := channelID(ctx)
+		name, err := aliasCollection(def, channel)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid collection alias %s", collection)
+		}
+		if len(name) == 0 {
+			return "", errors.Errorf("collection alias %s is not defined for channel '%s'", collection, channel)
+		}
+		logger.Debugf("resolved collection alias %s to %s on channel '%s'", collection, name, channel)
+		collection = name
+	}
+
+	if !strings.HasPrefix(collection, implicitPrefix) {
+		return collection, nil
+	}
+	if collection == ImplicitSelfCollection {
+		msp, err := shim.GetMSPID()
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to fetch peer mspid for %s", collection)
+		}
+		return ImplicitCollectionPrefix + msp, nil
+	}
+	if len(collection) > len(ImplicitCollectionPrefix) && strings.HasPrefix(collection, ImplicitCollectionPrefix) {
+		// implicit collection of a named org
+		return collection, nil
+	}
+
+	// override implicit collection using client's org
+	mspid, err := ResolveFlowData("$.cid.mspid", ctx)
+	if err != nil {
+		logger.Debugf("failed to fetch client mspid: %v", err)
+		return collection, nil
+	}
+	if msp, ok := mspid.(string); ok && len(msp) > 0 {
+		collection = ImplicitCollectionPrefix + msp
+		logger.Debugf("set implicit PDC to %s", collection)
+	}
+	return collection, nil
+}
+
+// collectionAlias returns the definition of a collection alias in app properties
+func collectionAlias(alias string) (interface{}, bool) {
+	manager := property.DefaultManager()
+	if manager == nil {
+		return nil, false
+	}
+	def, ok := manager.GetProperty(CollectionAliasPrefix + alias)
+	return def, ok && def != nil
+}
+
+// aliasCollection returns the collection of an alias definition for a channel, or empty string if it is not defined for the channel
+func aliasCollection(def interface{}, channel string) (string, error) {
+	var channels map[string]interface{}
+	switch v := def.(type) {
+	case string:
+		if !strings.HasPrefix(strings.TrimSpace(v), "{") {
+			return strings.TrimSpace(v), nil
+		}
+		if err := json.Unmarshal([]byte(v), &channels); err != nil {
+			return "", err
+		}
+	case map[string]interface{}:
+		channels = v
+	default:
+		return "", errors.Errorf("alias definition of type %T is not a string or JSON object", def)
+	}
+
+	name, ok := channels[channel]
+	if !ok || len(channel) == 0 {
+		name = channels[AnyChannel]
+	}
+	if name == nil {
+		return "", nil
+	}
+	s, ok := name.(string)
+	if !ok {
+		return "", errors.Errorf("collection of channel '%s' is not a string", channel)
+	}
+	return s, nil
+}
+
+// channelID returns the channel of the chaincode transaction, or empty string if the flow is not triggered by a transaction
+func channelID(ctx activity.Context) string {
+	scope := masterScope(ctx)
+	if v, exists := scope.GetValue(FabricStub); exists && v != nil {
+		if stub, ok := v.(shim.ChaincodeStubInterface); ok {
+			return stub.GetChannelID()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,268 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// EncryptedPrefix marks an encrypted field value, which is followed by base64 of the nonce and the AES-GCM sealed JSON value
+const EncryptedPrefix = "enc:v1:"
+
+// fieldPath is a JSON path of encrypted fields, where rest contains the field names below the current level of traversal
+type fieldPath struct {
+	name string
+	rest []string
+}
+
+// FieldCipher encrypts and decrypts values of JSON paths by AES-256-GCM.
+// Paths are specified in the same format as the Redactor, e.g., $.owner.ssn, where * matches any field name.
+// The state key and the concrete path of each value are authenticated, so an encrypted value cannot be moved to another record, field or array element.
+// Nonces are derived from the transaction ID, the state key, the path and the value, so all endorsing peers of a transaction
+// write the same ciphertext, while the nonce of a key is not reused for different values.
+// A FieldCipher without key masks the values of the paths when decrypting data.
+type FieldCipher struct {
+	paths    []*fieldPath
+	aead     cipher.AEAD
+	nonceKey []byte
+	txID     string
+}
+
+// NewFieldCipher returns a cipher for comma-delimited JSON paths and a base64-encoded 256-bit key, e.g., supplied in the transient map.
+// It returns nil if no path is specified, and a cipher that only masks values if the key is empty.
+func NewFieldCipher(paths string, key string) (*FieldCipher, error) {
+	var fields []*fieldPath
+	for _, v := range strings.Split(paths, ",") {
+		if f := splitPath(v); len(f) > 0 {
+			fields = append(fields, &fieldPath{name: strings.Join(f, "."), rest: f})
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	c := &FieldCipher{paths: fields}
+	if len(key) == 0 {
+		return c, nil
+	}
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encryption key is not base64 encoded")
+	}
+	if len(k) != 32 {
+		return nil, errors.Errorf("encryption key must be 256 bits, but it is %d bits", len(k)*8)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	if c.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	// separate key for deriving nonces, so the encryption key is not used for both AES and HMAC
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte("dovetail field nonce"))
+	c.nonceKey = mac.Sum(nil)
+	return c, nil
+}
+
+// WithTxID returns a copy of the cipher that derives nonces for the transaction of the specified ID
+func (c *FieldCipher) WithTxID(txID string) *FieldCipher {
+	if c == nil {
+		return nil
+	}
+	tc := *c
+	tc.txID = txID
+	return &tc
+}
+
+// Covers returns true if the value of a JSON path contains an encrypted field, i.e., the path overlaps with an encrypted path
+func (c *FieldCipher) Covers(path string) bool {
+	if c == nil {
+		return false
+	}
+	fields := splitPath(path)
+	if len(fields) == 0 {
+		return false
+	}
+	for _, p := range c.paths {
+		n := len(p.rest)
+		if len(fields) < n {
+			n = len(fields)
+		}
+		matched := true
+		for i := 0; i < n; i++ {
+			if p.rest[i] != fields[i] && p.rest[i] != wildcardField {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// HasKey returns true if the cipher can encrypt and decrypt values
+func (c *FieldCipher) HasKey() bool {
+	return c != nil && c.aead != nil
+}
+
+// Encrypt returns a copy of data of a state key with values of the paths encrypted.
+// The same data is encrypted to the same bytes in the same transaction, which is required for consistent endorsements.
+// Each value is bound to its concrete path, e.g., items[3].ssn, so encrypted values cannot be swapped between array elements.
+// Encrypted and masked values are rejected, so a client cannot copy ciphertext from another record,
+// and a record read without key cannot overwrite the encrypted values.
+func (c *FieldCipher) Encrypt(stateKey string, data interface{}) (interface{}, error) {
+	if c == nil {
+		return data, nil
+	}
+	if c.aead == nil {
+		return nil, errors.New("encryption key is not specified")
+	}
+	return transformFields(data, "", c.paths, func(path string, v interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok {
+			if strings.HasPrefix(s, EncryptedPrefix) {
+				return nil, errors.Errorf("encrypted value of %s cannot be stored", path)
+			}
+			if s == Redacted {
+				return nil, errors.Errorf("masked value of %s cannot be stored", path)
+			}
+		}
+		plain, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		nonce := c.nonce(stateKey, path, plain)
+		sealed := c.aead.Seal(nonce, nonce, plain, additionalData(stateKey, path))
+		return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+	})
+}
+
+// Decrypt returns a copy of data of a state key with encrypted values of the paths decrypted.
+// If the cipher has no key, values of the paths are masked. Values that cannot be decrypted are masked with a warning.
+func (c *FieldCipher) Decrypt(stateKey string, data interface{}) interface{} {
+	if c == nil {
+		return data
+	}
+	result, _ := transformFields(data, "", c.paths, func(path string, v interface{}) (interface{}, error) {
+		if c.aead == nil {
+			return Redacted, nil
+		}
+		s, ok := v.(string)
+		if !ok || !strings.HasPrefix(s, EncryptedPrefix) {
+			// value is not encrypted
+			return v, nil
+		}
+		plain, err := c.open(stateKey, path, s)
+		if err != nil {
+			logger.Warnf("failed to decrypt %s of state %s: %v", path, stateKey, err)
+			return Redacted, nil
+		}
+		return plain, nil
+	})
+	return result
+}
+
+func (c *FieldCipher) open(stateKey, path, value string) (interface{}, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("encrypted value is too short")
+	}
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], additionalData(stateKey, path))
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(plain, &result)
+	return result, err
+}
+
+// nonce returns the first NonceSize bytes of HMAC-SHA256 of the transaction ID, state key, path and plain text
+func (c *FieldCipher) nonce(stateKey, path string, plain []byte) []byte {
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write([]byte(c.txID + "\x00" + stateKey + "\x00" + path + "\x00"))
+	mac.Write(plain)
+	return mac.Sum(nil)[:c.aead.NonceSize()]
+}
+
+func additionalData(stateKey, path string) []byte {
+	return []byte(stateKey + "\x00" + path)
+}
+
+// transformFields returns a copy of data with values of the paths replaced by the result of fn, similar to redact.
+// fn is called with the concrete path of each value, e.g., items[3].ssn, where at is the concrete path of data.
+func transformFields(data interface{}, at string, paths []*fieldPath, fn func(path string, v interface{}) (interface{}, error)) (interface{}, error) {
+	if len(paths) == 0 {
+		return data, nil
+	}
+	switch v := data.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, fv := range v {
+			var sub []*fieldPath
+			matched := false
+			for _, p := range paths {
+				if p.rest[0] != k && p.rest[0] != wildcardField {
+					continue
+				}
+				if len(p.rest) == 1 {
+					matched = true
+					break
+				}
+				sub = append(sub, &fieldPath{name: p.name, rest: p.rest[1:]})
+			}
+			var err error
+			if matched {
+				if fv == nil {
+					result[k] = nil
+					continue
+				}
+				result[k], err = fn(fieldAt(at, k), fv)
+			} else {
+				result[k], err = transformFields(fv, fieldAt(at, k), sub, fn)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if result[i], err = transformFields(item, at+"["+strconv.Itoa(i)+"]", paths, fn); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	default:
+		return data, nil
+	}
+}
+
+// fieldAt returns the concrete path of a field of an object at a path, where a field name of special characters is quoted
+func fieldAt(at, field string) string {
+	if strings.ContainsAny(field, `.[]"`) {
+		return at + "[" + strconv.Quote(field) + "]"
+	}
+	if len(at) == 0 {
+		return field
+	}
+	return at + "." + field
+}
@@ -0,0 +1,285 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	// EncodingJSON stores enveloped value as JSON
+	EncodingJSON = "json"
+	// EncodingCBOR stores enveloped value as CBOR
+	EncodingCBOR = "cbor"
+	// EncodingProtobuf stores enveloped value as a protobuf message of a registered descriptor
+	EncodingProtobuf = "protobuf"
+	// SchemaField attribute used in query response for the format of an enveloped value
+	SchemaField = "schema"
+
+	// envelopeMagic is the prefix of enveloped values, which cannot be the start of a JSON document
+	envelopeMagic = "\x01DVE"
+)
+
+// ValueFormat describes the schema and encoding of a value stored in an envelope
+type ValueFormat struct {
+	Schema   string `json:"name,omitempty"`
+	Version  int    `json:"version,omitempty"`
+	Encoding string `json:"encoding"`
+	Message  string `json:"message,omitempty"`
+}
+
+var (
+	protoTypes  = make(map[string]protoreflect.MessageDescriptor)
+	protoLock   sync.RWMutex
+	cborDecMode cbor.DecMode
+	cborEncMode cbor.EncMode
+)
+
+func init() {
+	// decode CBOR maps as JSON objects
+	cborDecMode, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}{})}.DecMode()
+	// encode CBOR with sorted map keys, so all endorsing peers write the same bytes for the same value
+	cborEncMode, _ = cbor.CanonicalEncOptions().EncMode()
+}
+
+// NewValueFormat returns the format of enveloped values of a schema, or nil if values are stored as plain JSON without envelope.
+// Protobuf encoding requires the full name of a message registered by RegisterProtoDescriptor.
+func NewValueFormat(encoding, schema string, version int, message string) (*ValueFormat, error) {
+	if len(encoding) == 0 {
+		if len(schema) == 0 {
+			return nil, nil
+		}
+		encoding = EncodingJSON
+	}
+	switch encoding {
+	case EncodingJSON, EncodingCBOR:
+	case EncodingProtobuf:
+		if _, err := protoDescriptor(message); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("value encoding %s is not supported", encoding)
+	}
+	f := &ValueFormat{Schema: schema, Version: version, Encoding: encoding}
+	if encoding == EncodingProtobuf {
+		f.Message = message
+	}
+	return f, nil
+}
+
+// ToMap converts the format to the schema attribute of query response
+func (f *ValueFormat) ToMap() map[string]interface{} {
+	result := map[string]interface{}{"encoding": f.Encoding}
+	if len(f.Schema) > 0 {
+		result["name"] = f.Schema
+	}
+	if f.Version > 0 {
+		result["version"] = f.Version
+	}
+	if len(f.Message) > 0 {
+		result["message"] = f.Message
+	}
+	return result
+}
+
+// RegisterProtoDescriptor registers protobuf messages of a serialized FileDescriptorSet,
+// which must include all imported files, e.g., generated by 'protoc --include_imports --descriptor_set_out'
+func RegisterProtoDescriptor(descriptorSet []byte) error {
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(descriptorSet, fds); err != nil {
+		return errors.Wrapf(err, "invalid protobuf descriptor set")
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return errors.Wrapf(err, "invalid protobuf descriptor set")
+	}
+	protoLock.Lock()
+	defer protoLock.Unlock()
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		registerMessages(fd.Messages())
+		return true
+	})
+	return nil
+}
+
+// LoadProtoDescriptor registers protobuf messages of a base64-encoded FileDescriptorSet, e.g., specified by an activity setting
+func LoadProtoDescriptor(descriptor string) error {
+	if len(descriptor) == 0 {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(descriptor)
+	if err != nil {
+		return errors.Wrapf(err, "protobuf descriptor set is not base64 encoded")
+	}
+	return RegisterProtoDescriptor(data)
+}
+
+func registerMessages(msgs protoreflect.MessageDescriptors) {
+	for i := 0; i < msgs.Len(); i++ {
+		md := msgs.Get(i)
+		protoTypes[string(md.FullName())] = md
+		registerMessages(md.Messages())
+	}
+}
+
+func protoDescriptor(message string) (protoreflect.MessageDescriptor, error) {
+	protoLock.RLock()
+	defer protoLock.RUnlock()
+	md, ok := protoTypes[message]
+	if !ok {
+		return nil, errors.Errorf("protobuf message %s is not registered", message)
+	}
+	return md, nil
+}
+
+// IsEnvelope returns true if a stored value is enveloped with its schema and encoding
+func IsEnvelope(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(envelopeMagic))
+}
+
+// EncodeValue serializes data for storage, i.e., as plain JSON if format is nil, or else as an envelope of the format,
+// which contains the magic prefix, 2-byte length of the JSON header of the format, and the encoded data.
+// The encoding is deterministic, so the same data is always serialized to the same bytes.
+func EncodeValue(data interface{}, format *ValueFormat) ([]byte, error) {
+	if format == nil {
+		return json.Marshal(data)
+	}
+	var payload []byte
+	var err error
+	switch format.Encoding {
+	case EncodingJSON:
+		payload, err = json.Marshal(data)
+	case EncodingCBOR:
+		payload, err = cborEncMode.Marshal(data)
+	case EncodingProtobuf:
+		payload, err = encodeProto(data, format.Message)
+	default:
+		err = errors.Errorf("value encoding %s is not supported", format.Encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(format)
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0xFFFF {
+		return nil, errors.New("value format header is too long")
+	}
+	var buf bytes.Buffer
+	buf.WriteString(envelopeMagic)
+	binary.Write(&buf, binary.BigEndian, uint16(len(header)))
+	buf.Write(header)
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+// DecodeValue deserializes a stored value as a JSON-compatible object, and returns the format if the value is enveloped
+func DecodeValue(value []byte) (interface{}, *ValueFormat, error) {
+	var result interface{}
+	if !IsEnvelope(value) {
+		err := json.Unmarshal(value, &result)
+		return result, nil, err
+	}
+
+	// parse envelope header
+	start := len(envelopeMagic) + 2
+	if len(value) < start {
+		return nil, nil, errors.New("invalid value envelope")
+	}
+	end := start + int(binary.BigEndian.Uint16(value[len(envelopeMagic):start]))
+	if len(value) < end {
+		return nil, nil, errors.New("invalid value envelope")
+	}
+	format := &ValueFormat{}
+	if err := json.Unmarshal(value[start:end], format); err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid value envelope")
+	}
+
+	payload := value[end:]
+	var err error
+	switch format.Encoding {
+	case EncodingJSON:
+		err = json.Unmarshal(payload, &result)
+	case EncodingCBOR:
+		result, err = decodeCBOR(payload)
+	case EncodingProtobuf:
+		result, err = decodeProto(payload, format.Message)
+	default:
+		err = errors.Errorf("value encoding %s is not supported", format.Encoding)
+	}
+	if err != nil {
+		return nil, format, err
+	}
+	return result, format, nil
+}
+
+// decodeCBOR decodes CBOR data and normalizes it as JSON data, e.g., integers are converted to float64
+func decodeCBOR(payload []byte) (interface{}, error) {
+	var data interface{}
+	if err := cborDecMode.Unmarshal(payload, &data); err != nil {
+		return nil, errors.Wrapf(err, "invalid CBOR value")
+	}
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(jsonBytes, &result)
+	return result, err
+}
+
+// encodeProto converts JSON data to a protobuf message, where field names may be the proto names or lowerCamelCase JSON names
+func encodeProto(data interface{}, message string) ([]byte, error) {
+	md, err := protoDescriptor(message)
+	if err != nil {
+		return nil, err
+	}
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := protojson.Unmarshal(jsonBytes, msg); err != nil {
+		return nil, errors.Wrapf(err, "data does not match protobuf message %s", message)
+	}
+	// deterministic encoding sorts map entries, so all endorsing peers write the same bytes for the same value
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+// decodeProto converts a protobuf message to JSON data with proto field names.
+// Note that 64-bit integers are decoded as strings by the protobuf JSON mapping.
+func decodeProto(payload []byte, message string) (interface{}, error) {
+	md, err := protoDescriptor(message)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, errors.Wrapf(err, "invalid protobuf message %s", message)
+	}
+	jsonBytes, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(jsonBytes, &result)
+	return result, err
+}
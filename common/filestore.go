@@ -0,0 +1,147 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FileStore is a MemoryStore that persists all states to a JSON file.
+// It is a development-only store, e.g., for testing flows and small demo apps: every save rewrites the whole file,
+// including the unbounded history of all keys, so the cost of a save grows with the size of the store.
+// Updates are saved immediately, unless they are batched by Begin and Commit, e.g., for all updates of a transaction.
+// It does not support concurrent processes sharing the same file.
+type FileStore struct {
+	*MemoryStore
+	path      string
+	saveLock  sync.Mutex
+	batchLock sync.Mutex
+	batches   int
+	dirty     bool
+}
+
+// NewFileStore returns a FileStore that loads states from a file if the file exists
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read state file %s", path)
+	}
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, errors.Wrapf(err, "invalid state file %s", path)
+	}
+	if s.data.Collections == nil {
+		s.data.Collections = make(map[string]map[string][]byte)
+	}
+	if s.data.Policies == nil {
+		s.data.Policies = make(map[string]map[string][]byte)
+	}
+	if s.data.History == nil {
+		s.data.History = make(map[string][]*historyRecord)
+	}
+	return s, nil
+}
+
+// PutState implements StateStore.PutState
+func (s *FileStore) PutState(collection, key string, value []byte) error {
+	if err := s.MemoryStore.PutState(collection, key, value); err != nil {
+		return err
+	}
+	return s.update()
+}
+
+// DelState implements StateStore.DelState
+func (s *FileStore) DelState(collection, key string) error {
+	if err := s.MemoryStore.DelState(collection, key); err != nil {
+		return err
+	}
+	return s.update()
+}
+
+// SetValidationParameter implements StateStore.SetValidationParameter
+func (s *FileStore) SetValidationParameter(collection, key string, ep []byte) error {
+	if err := s.MemoryStore.SetValidationParameter(collection, key, ep); err != nil {
+		return err
+	}
+	return s.update()
+}
+
+// Begin starts a batch of updates, which are saved to the file once when the batch is committed.
+// Batches may be nested, and updates are saved when the outermost batch is committed.
+func (s *FileStore) Begin() {
+	s.batchLock.Lock()
+	defer s.batchLock.Unlock()
+	s.batches++
+}
+
+// Commit ends a batch of updates, and saves all states to the file if no other batch is open
+func (s *FileStore) Commit() error {
+	s.batchLock.Lock()
+	if s.batches > 0 {
+		s.batches--
+	}
+	pending := s.batches == 0 && s.dirty
+	s.dirty = false
+	s.batchLock.Unlock()
+	if !pending {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		// keep the updates pending, so they are saved by the next update or commit
+		s.batchLock.Lock()
+		s.dirty = true
+		s.batchLock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// update saves all states after an update unless the update is in a batch
+func (s *FileStore) update() error {
+	s.batchLock.Lock()
+	if s.batches > 0 {
+		s.dirty = true
+		s.batchLock.Unlock()
+		return nil
+	}
+	s.batchLock.Unlock()
+	return s.save()
+}
+
+// save writes all states to a temporary file, and then renames it, so the state file is not corrupted by a failed write
+func (s *FileStore) save() error {
+	// serialize saves, so the file always contains the latest states
+	s.saveLock.Lock()
+	defer s.saveLock.Unlock()
+	s.lock.RLock()
+	data, err := json.Marshal(&s.data)
+	s.lock.RUnlock()
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize states")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary state file")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to write state file")
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "failed to write state file")
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -9,6 +9,8 @@ replace github.com/project-flogo/core => github.com/yxuco/core v1.2.1
 replace go.uber.org/multierr => go.uber.org/multierr v1.6.0
 
 require (
+	github.com/fxamacker/cbor/v2 v2.4.0
+	github.com/golang/protobuf v1.5.2
 	github.com/hyperledger/fabric-chaincode-go v0.0.0-20200728190242-9b3ae92d8664
 	github.com/hyperledger/fabric-protos-go v0.0.0-20190919234611-2a87503ac7c9
 	github.com/oliveagle/jsonpath v0.0.0-20180606110733-2e52cf6e6852
@@ -16,4 +18,5 @@ require (
 	github.com/project-flogo/core v1.2.0
 	github.com/project-flogo/flow v1.2.0
 	github.com/stretchr/testify v1.6.1
+	google.golang.org/protobuf v1.27.1
 )
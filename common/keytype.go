@@ -0,0 +1,340 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/project-flogo/core/data/coerce"
+)
+
+const (
+	// AttrString is the default type of composite key attributes, which are formatted by %v
+	AttrString = "string"
+	// AttrInt is the type of integer attributes, which are encoded as 20-digit offset numbers
+	AttrInt = "int"
+	// AttrDecimal is the type of numeric attributes, which are encoded as 16-digit hex of ordered IEEE 754 bits
+	AttrDecimal = "decimal"
+	// AttrDate is the type of date-time attributes, which are encoded as UTC time of fixed nanosecond precision
+	AttrDate = "date"
+
+	// keyDateFormat is the fixed-width format of date attributes in composite keys
+	keyDateFormat = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+var (
+	compositeKeyTypes = make(map[string][]string)
+	uniqueKeyDefs     = make(map[string][]string)
+	schemaKeyDefs     = make(map[string]*schemaKeys)
+	keyTypeLock       sync.RWMutex
+)
+
+// schemaKeys are the composite keys of states of a document schema, and the names of unique keys among them
+type schemaKeys struct {
+	defs   map[string][]string
+	unique map[string]bool
+}
+
+// UniqueKeyError reports a unique composite key that is already used by another state
+type UniqueKeyError struct {
+	Name  string
+	State string
+}
+
+func (e *UniqueKeyError) Error() string {
+	return fmt.Sprintf("unique key %s is already used by another state", e.Name)
+}
+
+// ParseKeyAttribute splits a composite key attribute definition of format 'path:type', e.g., '$.size:int',
+// and returns the JSON path and type of the attribute, which is AttrString if the type is not specified
+func ParseKeyAttribute(attr string) (string, string) {
+	if i := strings.LastIndex(attr, ":"); i > 0 {
+		switch t := attr[i+1:]; t {
+		case AttrString, AttrInt, AttrDecimal, AttrDate:
+			return attr[:i], t
+		}
+	}
+	return attr, AttrString
+}
+
+// RegisterCompositeKeys registers attribute types of composite key definitions, which are used by SplitCompositeKey to decode typed attributes.
+// It returns error if a key is already registered with different attribute types, e.g., by another activity.
+func RegisterCompositeKeys(compositeKeyDefs map[string][]string) error {
+	keyTypeLock.Lock()
+	defer keyTypeLock.Unlock()
+	keyTypes := make(map[string][]string, len(compositeKeyDefs))
+	for name, attrs := range compositeKeyDefs {
+		types := make([]string, len(attrs))
+		for i, a := range attrs {
+			_, types[i] = ParseKeyAttribute(a)
+		}
+		if prev, ok := compositeKeyTypes[name]; ok {
+			// a definition may specify leading attributes of a registered key, e.g., for partial key queries
+			n := len(prev)
+			if len(types) < n {
+				n = len(types)
+			}
+			if strings.Join(prev[:n], ",") != strings.Join(types[:n], ",") {
+				return errors.Errorf("attribute types %v of composite key %s conflict with registered types %v", types, name, prev)
+			}
+			if len(prev) > len(types) {
+				types = prev
+			}
+		}
+		keyTypes[name] = types
+	}
+	for name, types := range keyTypes {
+		compositeKeyTypes[name] = types
+	}
+	return nil
+}
+
+// RegisterUniqueKeys registers definitions of unique composite keys,
+// so that activities delete the unique keys of deleted states even if the keys are not defined in their settings.
+// It returns error if a unique key is already registered with different attributes.
+func RegisterUniqueKeys(compositeKeyDefs map[string][]string) error {
+	keyTypeLock.Lock()
+	defer keyTypeLock.Unlock()
+	for name, attrs := range compositeKeyDefs {
+		if prev, ok := uniqueKeyDefs[name]; ok && !sameAttributes(prev, attrs) {
+			return errors.Errorf("attributes %v of unique key %s conflict with registered attributes %v", attrs, name, prev)
+		}
+	}
+	for name, attrs := range compositeKeyDefs {
+		uniqueKeyDefs[name] = attrs
+	}
+	return nil
+}
+
+// RegisterSchemaKeys registers composite keys and unique keys of states of a schema, e.g., defined by a put activity that writes the schema,
+// so that other writers of the schema, e.g., the migration system transaction, maintain the same composite keys.
+// It returns error if a key of the schema is already registered with different attributes.
+func RegisterSchemaKeys(schema string, compositeKeyDefs map[string][]string, uniqueKeys []string) error {
+	if len(schema) == 0 || len(compositeKeyDefs) == 0 {
+		return nil
+	}
+	keyTypeLock.Lock()
+	defer keyTypeLock.Unlock()
+	keys, ok := schemaKeyDefs[schema]
+	if !ok {
+		keys = &schemaKeys{defs: make(map[string][]string), unique: make(map[string]bool)}
+		schemaKeyDefs[schema] = keys
+	}
+	for name, attrs := range compositeKeyDefs {
+		if prev, ok := keys.defs[name]; ok && !sameAttributes(prev, attrs) {
+			return errors.Errorf("attributes %v of composite key %s of schema %s conflict with registered attributes %v", attrs, name, schema, prev)
+		}
+	}
+	for name, attrs := range compositeKeyDefs {
+		keys.defs[name] = attrs
+	}
+	for _, name := range uniqueKeys {
+		keys.unique[name] = true
+	}
+	return nil
+}
+
+func sameAttributes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SchemaKeys returns the composite key definitions and the names of unique keys registered for a schema
+func SchemaKeys(schema string) (map[string][]string, map[string]bool) {
+	keyTypeLock.RLock()
+	defer keyTypeLock.RUnlock()
+	keys, ok := schemaKeyDefs[schema]
+	if !ok {
+		return nil, nil
+	}
+	return keys.defs, keys.unique
+}
+
+// WithUniqueKeys returns composite key definitions merged with registered unique keys that are not already defined
+func WithUniqueKeys(compositeKeyDefs map[string][]string) map[string][]string {
+	keyTypeLock.RLock()
+	defer keyTypeLock.RUnlock()
+	if len(uniqueKeyDefs) == 0 {
+		return compositeKeyDefs
+	}
+	result := make(map[string][]string, len(compositeKeyDefs)+len(uniqueKeyDefs))
+	for name, attrs := range uniqueKeyDefs {
+		result[name] = attrs
+	}
+	for name, attrs := range compositeKeyDefs {
+		result[name] = attrs
+	}
+	return result
+}
+
+// UniqueKeyConflict checks if the unique prefix of a composite key, i.e., the attributes before the state key,
+// is already used by a composite key of a different state, and returns the conflicting state key or empty string
+func UniqueKeyConflict(ss StateStore, collection, compositeKey string) (string, error) {
+	name, attrs, err := ss.SplitCompositeKey(compositeKey)
+	if err != nil {
+		return "", err
+	}
+	if len(attrs) < 2 {
+		return "", errors.Errorf("unique key %s does not contain attributes besides the state key", name)
+	}
+	stateKey := attrs[len(attrs)-1]
+	iter, _, err := ss.GetStateByPartialCompositeKey(collection, name, attrs[:len(attrs)-1], 0, "")
+	if err != nil {
+		return "", err
+	}
+	defer iter.Close()
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return "", err
+		}
+		_, fields, err := ss.SplitCompositeKey(kv.Key)
+		if err != nil || len(fields) != len(attrs) {
+			continue
+		}
+		if k := fields[len(fields)-1]; k != stateKey {
+			return k, nil
+		}
+	}
+	return "", nil
+}
+
+// CheckUniqueKeys verifies that the unique keys among composite keys of a state are not used by other states,
+// and returns a UniqueKeyError if a unique key conflicts with another state
+func CheckUniqueKeys(ss StateStore, collection string, keys []string, uniqueKeys map[string]bool) error {
+	if len(uniqueKeys) == 0 {
+		return nil
+	}
+	for _, k := range keys {
+		name, _, err := ss.SplitCompositeKey(k)
+		if err != nil || !uniqueKeys[name] {
+			continue
+		}
+		other, err := UniqueKeyConflict(ss, collection, k)
+		if err != nil {
+			return errors.Wrapf(err, "failed to verify unique key %s @ %s", name, collection)
+		}
+		if len(other) > 0 {
+			logger.Debugf("unique key %s @ %s is used by state %s", name, collection, other)
+			return &UniqueKeyError{Name: name, State: other}
+		}
+	}
+	return nil
+}
+
+// WriteCompositeKeys deletes stale composite keys of the prior value of a state, and stores added composite keys of the new value.
+// Failures are logged, so the state update is not rolled back by a failed composite key.
+func WriteCompositeKeys(ss StateStore, collection string, stale, added []string) {
+	for _, k := range stale {
+		if err := DeleteData(ss, collection, k); err != nil {
+			logger.Warnf("failed to delete stale composite key %s @ %s: %+v", k, collection, err)
+		} else {
+			logger.Debugf("deleted stale composite key %s @ %s", k, collection)
+		}
+	}
+	for _, k := range added {
+		if err := PutData(ss, collection, k, nil); err != nil {
+			logger.Warnf("failed to store composite key %s @ %s: %+v", k, collection, err)
+		} else {
+			logger.Debugf("stored composite key %s @ %s", k, collection)
+		}
+	}
+}
+
+func keyAttributeTypes(name string) []string {
+	keyTypeLock.RLock()
+	defer keyTypeLock.RUnlock()
+	return compositeKeyTypes[name]
+}
+
+// EncodeKeyAttribute formats a value of a composite key attribute, so typed values sort in their natural order
+func EncodeKeyAttribute(value interface{}, attrType string) (string, error) {
+	switch attrType {
+	case AttrInt:
+		var n int64
+		if f, ok := value.(float64); ok {
+			if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return "", errors.Errorf("value %v is not an int", value)
+			}
+			n = int64(f)
+		} else {
+			var err error
+			if n, err = coerce.ToInt64(value); err != nil {
+				return "", errors.Wrapf(err, "value %v is not an int", value)
+			}
+		}
+		// flip the sign bit, so negative numbers sort before positive numbers
+		return fmt.Sprintf("%020d", uint64(n)^(1<<63)), nil
+	case AttrDecimal:
+		f, err := coerce.ToFloat64(value)
+		if err != nil || math.IsNaN(f) {
+			return "", errors.Errorf("value %v is not a decimal", value)
+		}
+		// flip the sign bit of positive numbers and all bits of negative numbers
+		bits := math.Float64bits(f)
+		if bits&(1<<63) == 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return fmt.Sprintf("%016x", bits), nil
+	case AttrDate:
+		t, err := coerce.ToDateTime(value)
+		if err != nil {
+			return "", errors.Wrapf(err, "value %v is not a date", value)
+		}
+		t = t.UTC()
+		if t.Year() < 0 || t.Year() > 9999 {
+			return "", errors.Errorf("date %v is out of range", value)
+		}
+		return t.Format(keyDateFormat), nil
+	default:
+		return fmt.Sprintf("%v", value), nil
+	}
+}
+
+// DecodeKeyAttribute converts an encoded composite key attribute to its readable form
+func DecodeKeyAttribute(value string, attrType string) (string, error) {
+	switch attrType {
+	case AttrInt:
+		u, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid int attribute %s", value)
+		}
+		return strconv.FormatInt(int64(u^(1<<63)), 10), nil
+	case AttrDecimal:
+		bits, err := strconv.ParseUint(value, 16, 64)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid decimal attribute %s", value)
+		}
+		if bits&(1<<63) != 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return strconv.FormatFloat(math.Float64frombits(bits), 'f', -1, 64), nil
+	case AttrDate:
+		t, err := time.Parse(keyDateFormat, value)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid date attribute %s", value)
+		}
+		return t.Format(time.RFC3339Nano), nil
+	default:
+		return value, nil
+	}
+}
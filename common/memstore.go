@@ -0,0 +1,449 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/pkg/errors"
+)
+
+// emptyKeySubstitute replaces empty start key of range query, so composite keys are excluded from the range as in Fabric
+const emptyKeySubstitute = "\x01"
+
+// historyRecord is a write of a key in the world state
+type historyRecord struct {
+	TxID     string    `json:"txID"`
+	Value    []byte    `json:"value,omitempty"`
+	Time     time.Time `json:"time"`
+	IsDelete bool      `json:"isDeleted,omitempty"`
+}
+
+// storeSnapshot contains all data of a MemoryStore
+type storeSnapshot struct {
+	Collections map[string]map[string][]byte `json:"collections"`
+	Policies    map[string]map[string][]byte `json:"policies,omitempty"`
+	History     map[string][]*historyRecord  `json:"history,omitempty"`
+	Sequence    int64                        `json:"sequence"`
+}
+
+// MemoryStore is a StateStore that keeps the world state and private data collections in memory.
+// It supports unit tests of flows without a mock chaincode stub, and non-Fabric apps that do not persist states.
+// Rich queries support a subset of CouchDB selectors, i.e., field values and operators $eq, $ne, $gt, $gte, $lt, $lte, $in, $exists, $and and $or.
+type MemoryStore struct {
+	lock sync.RWMutex
+	data storeSnapshot
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: storeSnapshot{
+		Collections: make(map[string]map[string][]byte),
+		Policies:    make(map[string]map[string][]byte),
+		History:     make(map[string][]*historyRecord),
+	}}
+}
+
+// CreateCompositeKey implements CompositeKeyCodec.CreateCompositeKey
+func (s *MemoryStore) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+// SplitCompositeKey implements CompositeKeyCodec.SplitCompositeKey
+func (s *MemoryStore) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if len(compositeKey) == 0 || !IsCompositeKey(compositeKey) {
+		return "", nil, errors.Errorf("invalid composite key %s", compositeKey)
+	}
+	components := strings.Split(compositeKey[1:], "\x00")
+	if len(components) < 2 {
+		return "", nil, errors.Errorf("invalid composite key %s", compositeKey)
+	}
+	// the last component is empty because of the trailing delimiter
+	return components[0], components[1 : len(components)-1], nil
+}
+
+// GetState implements StateStore.GetState
+func (s *MemoryStore) GetState(collection, key string) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return copyBytes(s.data.Collections[collection][key]), nil
+}
+
+// GetStateHash implements StateStore.GetStateHash
+func (s *MemoryStore) GetStateHash(collection, key string) ([]byte, error) {
+	if len(collection) == 0 {
+		return nil, errors.New("state hash is available for private data collection only")
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, ok := s.data.Collections[collection][key]
+	if !ok {
+		return nil, nil
+	}
+	h := sha256.Sum256(v)
+	return h[:], nil
+}
+
+// PutState implements StateStore.PutState
+func (s *MemoryStore) PutState(collection, key string, value []byte) error {
+	if len(key) == 0 {
+		return errors.New("key must not be an empty string")
+	}
+	if len(value) == 0 {
+		return errors.Errorf("value of key %s must not be empty", key)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, ok := s.data.Collections[collection]
+	if !ok {
+		c = make(map[string][]byte)
+		s.data.Collections[collection] = c
+	}
+	c[key] = copyBytes(value)
+	s.addHistory(collection, key, value, false)
+	return nil
+}
+
+// DelState implements StateStore.DelState
+func (s *MemoryStore) DelState(collection, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if c, ok := s.data.Collections[collection]; ok {
+		if _, ok := c[key]; ok {
+			delete(c, key)
+			s.addHistory(collection, key, nil, true)
+		}
+	}
+	return nil
+}
+
+// addHistory records a write of a world state key, which is identified by a sequence number as the transaction ID
+func (s *MemoryStore) addHistory(collection, key string, value []byte, isDelete bool) {
+	if len(collection) > 0 || IsCompositeKey(key) {
+		return
+	}
+	s.data.Sequence++
+	s.data.History[key] = append(s.data.History[key], &historyRecord{
+		TxID:     strconv.FormatInt(s.data.Sequence, 10),
+		Value:    copyBytes(value),
+		Time:     time.Now().UTC(),
+		IsDelete: isDelete,
+	})
+}
+
+// GetStateByRange implements StateStore.GetStateByRange
+func (s *MemoryStore) GetStateByRange(collection, startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if len(startKey) == 0 {
+		startKey = emptyKeySubstitute
+	}
+	if IsCompositeKey(startKey) || (len(endKey) > 0 && IsCompositeKey(endKey)) {
+		return nil, nil, errors.New("range query does not support composite keys")
+	}
+	return s.scan(collection, startKey, endKey, pageSize, bookmark, nil)
+}
+
+// GetStateByPartialCompositeKey implements StateStore.GetStateByPartialCompositeKey
+func (s *MemoryStore) GetStateByPartialCompositeKey(collection, objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	prefix, err := shim.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.scan(collection, prefix, prefix+string(utf8.MaxRune), pageSize, bookmark, nil)
+}
+
+// GetQueryResult implements StateStore.GetQueryResult
+func (s *MemoryStore) GetQueryResult(collection, query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	q := struct {
+		Selector map[string]interface{} `json:"selector"`
+	}{}
+	if err := json.Unmarshal([]byte(query), &q); err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid rich query")
+	}
+	filter := func(value []byte) (bool, error) {
+		var doc interface{}
+		if err := json.Unmarshal(value, &doc); err != nil {
+			// ignore values that are not JSON documents
+			return false, nil
+		}
+		return matchSelector(doc, q.Selector)
+	}
+	return s.scan(collection, emptyKeySubstitute, "", pageSize, bookmark, filter)
+}
+
+// scan returns sorted states of keys in the range [startKey, endKey) that pass the filter.
+// The bookmark of a page is the first key of the next page.
+func (s *MemoryStore) scan(collection, startKey, endKey string, pageSize int32, bookmark string, filter func([]byte) (bool, error)) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if pageSize > 0 && bookmark > startKey {
+		startKey = bookmark
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	c := s.data.Collections[collection]
+	var keys []string
+	for k := range c {
+		if k >= startKey && (len(endKey) == 0 || k < endKey) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	iter := &stateIterator{}
+	var md *pb.QueryResponseMetadata
+	if pageSize > 0 {
+		md = &pb.QueryResponseMetadata{}
+	}
+	for _, k := range keys {
+		if filter != nil {
+			ok, err := filter(c[k])
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		if md != nil && int32(len(iter.results)) >= pageSize {
+			md.Bookmark = k
+			break
+		}
+		iter.results = append(iter.results, &queryresult.KV{Key: k, Value: copyBytes(c[k])})
+	}
+	if md != nil {
+		md.FetchedRecordsCount = int32(len(iter.results))
+	}
+	return iter, md, nil
+}
+
+// GetHistoryForKey implements StateStore.GetHistoryForKey
+func (s *MemoryStore) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	iter := &historyIterator{}
+	for _, h := range s.data.History[key] {
+		iter.results = append(iter.results, &queryresult.KeyModification{
+			TxId:      h.TxID,
+			Value:     copyBytes(h.Value),
+			Timestamp: &timestamp.Timestamp{Seconds: h.Time.Unix(), Nanos: int32(h.Time.Nanosecond())},
+			IsDelete:  h.IsDelete,
+		})
+	}
+	return iter, nil
+}
+
+// GetValidationParameter implements StateStore.GetValidationParameter
+func (s *MemoryStore) GetValidationParameter(collection, key string) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return copyBytes(s.data.Policies[collection][key]), nil
+}
+
+// SetValidationParameter implements StateStore.SetValidationParameter
+func (s *MemoryStore) SetValidationParameter(collection, key string, ep []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p, ok := s.data.Policies[collection]
+	if !ok {
+		p = make(map[string][]byte)
+		s.data.Policies[collection] = p
+	}
+	p[key] = copyBytes(ep)
+	return nil
+}
+
+func copyBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	result := make([]byte, len(data))
+	copy(result, data)
+	return result
+}
+
+// stateIterator iterates over results of a range, partial key or rich query
+type stateIterator struct {
+	results []*queryresult.KV
+	next    int
+}
+
+// HasNext implements shim.StateQueryIteratorInterface.HasNext
+func (i *stateIterator) HasNext() bool {
+	return i.next < len(i.results)
+}
+
+// Next implements shim.StateQueryIteratorInterface.Next
+func (i *stateIterator) Next() (*queryresult.KV, error) {
+	if !i.HasNext() {
+		return nil, errors.New("no more query result")
+	}
+	i.next++
+	return i.results[i.next-1], nil
+}
+
+// Close implements shim.StateQueryIteratorInterface.Close
+func (i *stateIterator) Close() error {
+	return nil
+}
+
+// historyIterator iterates over history of a key
+type historyIterator struct {
+	results []*queryresult.KeyModification
+	next    int
+}
+
+// HasNext implements shim.HistoryQueryIteratorInterface.HasNext
+func (i *historyIterator) HasNext() bool {
+	return i.next < len(i.results)
+}
+
+// Next implements shim.HistoryQueryIteratorInterface.Next
+func (i *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !i.HasNext() {
+		return nil, errors.New("no more history record")
+	}
+	i.next++
+	return i.results[i.next-1], nil
+}
+
+// Close implements shim.HistoryQueryIteratorInterface.Close
+func (i *historyIterator) Close() error {
+	return nil
+}
+
+// matchSelector returns true if a JSON document matches all conditions of a CouchDB selector
+func matchSelector(doc interface{}, selector map[string]interface{}) (bool, error) {
+	for field, cond := range selector {
+		switch field {
+		case "$and", "$or":
+			subs, ok := cond.([]interface{})
+			if !ok {
+				return false, errors.Errorf("%s requires an array of selectors", field)
+			}
+			matched := field == "$and"
+			for _, sub := range subs {
+				m, ok := sub.(map[string]interface{})
+				if !ok {
+					return false, errors.Errorf("%s requires an array of selectors", field)
+				}
+				ok, err := matchSelector(doc, m)
+				if err != nil {
+					return false, err
+				}
+				if field == "$and" && !ok {
+					matched = false
+					break
+				}
+				if field == "$or" && ok {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false, nil
+			}
+		default:
+			value, found := lookupField(doc, field)
+			ok, err := matchCondition(value, found, cond)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// lookupField returns value of a dot-delimited field name in a JSON document
+func lookupField(doc interface{}, field string) (interface{}, bool) {
+	value := doc
+	for _, f := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[f]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// matchCondition returns true if a field value matches an operator expression, a nested selector, or an implicit $eq value
+func matchCondition(value interface{}, found bool, cond interface{}) (bool, error) {
+	expr, ok := cond.(map[string]interface{})
+	if !ok {
+		return found && reflect.DeepEqual(value, cond), nil
+	}
+	for op, arg := range expr {
+		if !strings.HasPrefix(op, "$") {
+			// nested selector
+			return matchSelector(value, expr)
+		}
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = found && reflect.DeepEqual(value, arg)
+		case "$ne":
+			ok = !found || !reflect.DeepEqual(value, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			c, comparable := compareValues(value, arg)
+			ok = found && comparable && ((op == "$gt" && c > 0) || (op == "$gte" && c >= 0) || (op == "$lt" && c < 0) || (op == "$lte" && c <= 0))
+		case "$in":
+			items, isArray := arg.([]interface{})
+			if !isArray {
+				return false, errors.New("$in requires an array of values")
+			}
+			for _, item := range items {
+				if found && reflect.DeepEqual(value, item) {
+					ok = true
+					break
+				}
+			}
+		case "$exists":
+			exists, isBool := arg.(bool)
+			if !isBool {
+				return false, errors.New("$exists requires a boolean value")
+			}
+			ok = found == exists
+		default:
+			return false, errors.Errorf("selector operator %s is not supported", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compareValues compares 2 numbers or 2 strings, and returns false if they are not comparable
+func compareValues(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,328 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/project-flogo/core/action"
+	"github.com/project-flogo/core/engine/runner"
+	"github.com/project-flogo/core/trigger"
+)
+
+const (
+	// MigratedFromField attribute of the schema in query response for the original version of an upgraded value
+	MigratedFromField = "migratedFrom"
+
+	// flowActionRef is the action type of migration subflows
+	flowActionRef = "github.com/project-flogo/flow"
+)
+
+// TransformOp is a step of a JSON transform of a migration rule, similar to a JSON patch operation.
+// Op is one of add, default, replace, remove, move, or copy, where default adds a value only if the path does not exist.
+// Path and From are dot-separated field names, optionally with the prefix '$.'.
+type TransformOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// MigrationRule upgrades values of a document schema from a version to a later version,
+// either by a JSON transform, or by a Flogo subflow that accepts input 'data' and returns output 'data'.
+// Target defaults to the next version, and Message is the protobuf message of the target version if it is changed.
+type MigrationRule struct {
+	Schema    string         `json:"schema"`
+	Version   int            `json:"version"`
+	Target    int            `json:"target,omitempty"`
+	Message   string         `json:"message,omitempty"`
+	Transform []*TransformOp `json:"transform,omitempty"`
+	FlowURI   string         `json:"flowURI,omitempty"`
+}
+
+var (
+	migrationRules = make(map[string]map[int]*MigrationRule)
+	migrationFlows = make(map[string]action.Action)
+	migrationLock  sync.RWMutex
+)
+
+// RegisterMigrationRules registers migration rules, which replace existing rules of the same schema and version
+func RegisterMigrationRules(rules []*MigrationRule) error {
+	for _, r := range rules {
+		if len(r.Schema) == 0 {
+			return errors.New("schema of migration rule is not specified")
+		}
+		if r.Target == 0 {
+			r.Target = r.Version + 1
+		}
+		if r.Target <= r.Version {
+			return errors.Errorf("migration rule of %s must upgrade version %d to a later version", r.Schema, r.Version)
+		}
+		if len(r.Transform) == 0 && len(r.FlowURI) == 0 {
+			return errors.Errorf("migration rule of %s version %d does not specify transform or flowURI", r.Schema, r.Version)
+		}
+		for _, op := range r.Transform {
+			if err := op.validate(); err != nil {
+				return errors.Wrapf(err, "invalid transform of %s version %d", r.Schema, r.Version)
+			}
+		}
+	}
+
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	for _, r := range rules {
+		versions, ok := migrationRules[r.Schema]
+		if !ok {
+			versions = make(map[int]*MigrationRule)
+			migrationRules[r.Schema] = versions
+		}
+		versions[r.Version] = r
+	}
+	return nil
+}
+
+// LoadMigrationRules registers migration rules specified by an activity or trigger setting,
+// which is either a JSON array of rules or a JSON string of the array
+func LoadMigrationRules(setting interface{}) error {
+	if setting == nil {
+		return nil
+	}
+	var jsonBytes []byte
+	if s, ok := setting.(string); ok {
+		if len(strings.TrimSpace(s)) == 0 {
+			return nil
+		}
+		jsonBytes = []byte(s)
+	} else {
+		var err error
+		if jsonBytes, err = json.Marshal(setting); err != nil {
+			return errors.Wrapf(err, "invalid migration rules")
+		}
+	}
+	var rules []*MigrationRule
+	if err := json.Unmarshal(jsonBytes, &rules); err != nil {
+		return errors.Wrapf(err, "invalid migration rules")
+	}
+	return RegisterMigrationRules(rules)
+}
+
+// LatestSchemaVersion returns the latest version of a schema that values can be upgraded to by registered rules,
+// or 0 if no rule is registered for the schema
+func LatestSchemaVersion(schema string) int {
+	migrationLock.RLock()
+	defer migrationLock.RUnlock()
+	latest := 0
+	for _, r := range migrationRules[schema] {
+		if r.Target > latest {
+			latest = r.Target
+		}
+	}
+	return latest
+}
+
+func migrationRule(schema string, version int) *MigrationRule {
+	migrationLock.RLock()
+	defer migrationLock.RUnlock()
+	return migrationRules[schema][version]
+}
+
+// MigrateValue upgrades data of an enveloped value to the target version of its schema by applying registered rules in sequence.
+// The latest version is used if target is 0. It returns the original data and format if no upgrade is required,
+// or else the upgraded data and the format of the new version. Plain JSON values without envelope are not upgraded.
+// The context carries the values of the transaction, e.g., returned by TransactionContext, which are passed to migration subflows.
+func MigrateValue(ctx context.Context, data interface{}, format *ValueFormat, target int) (interface{}, *ValueFormat, error) {
+	if format == nil || len(format.Schema) == 0 {
+		return data, format, nil
+	}
+	result := data
+	current := *format
+	for target == 0 || current.Version < target {
+		rule := migrationRule(current.Schema, current.Version)
+		if rule == nil {
+			break
+		}
+		var err error
+		if result, err = rule.apply(ctx, result); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to migrate %s from version %d", current.Schema, current.Version)
+		}
+		current.Version = rule.Target
+		if len(rule.Message) > 0 {
+			current.Message = rule.Message
+		}
+	}
+	if target > 0 && current.Version != target {
+		return nil, nil, errors.Errorf("no migration rule of %s from version %d to %d", current.Schema, current.Version, target)
+	}
+	if current.Version == format.Version {
+		return data, format, nil
+	}
+	return result, &current, nil
+}
+
+// apply upgrades a value by the subflow or JSON transform of the rule
+func (r *MigrationRule) apply(ctx context.Context, data interface{}) (interface{}, error) {
+	if len(r.FlowURI) > 0 {
+		return r.runFlow(ctx, data)
+	}
+
+	// transform a copy of the data, so the original value is not changed on failure
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(jsonBytes, &doc); err != nil {
+		return nil, errors.Wrapf(err, "value of %s is not a JSON object", r.Schema)
+	}
+	for _, op := range r.Transform {
+		op.apply(doc)
+	}
+	return doc, nil
+}
+
+// runFlow executes the migration subflow with input 'data', 'schema', and 'version', and returns the output 'data'.
+// The transaction values of the context, e.g., the chaincode stub and write buffer, are passed to the subflow
+// in the same way as the transaction trigger passes them to a flow, so activities of the subflow can access the states.
+func (r *MigrationRule) runFlow(ctx context.Context, data interface{}) (interface{}, error) {
+	act, err := migrationFlow(r.FlowURI)
+	if err != nil {
+		return nil, err
+	}
+	inputs := map[string]interface{}{}
+	if values, ok := trigger.ValuesFromContext(ctx); ok {
+		for k, v := range values {
+			inputs[k] = v
+		}
+	}
+	inputs["data"] = data
+	inputs["schema"] = r.Schema
+	inputs["version"] = r.Version
+	results, err := runner.NewDirect().RunAction(ctx, act, inputs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "migration flow %s failed", r.FlowURI)
+	}
+	result, ok := results["data"]
+	if !ok || result == nil {
+		return nil, errors.Errorf("migration flow %s did not return data", r.FlowURI)
+	}
+	return result, nil
+}
+
+// migrationFlow returns the cached flow action of a subflow URI, e.g., res://flow:migrate_marble_v1
+func migrationFlow(flowURI string) (action.Action, error) {
+	migrationLock.RLock()
+	act, ok := migrationFlows[flowURI]
+	migrationLock.RUnlock()
+	if ok {
+		return act, nil
+	}
+
+	factory := action.GetFactory(flowActionRef)
+	if factory == nil {
+		return nil, errors.Errorf("flow action is not registered for migration flow %s", flowURI)
+	}
+	act, err := factory.New(&action.Config{Ref: flowActionRef, Settings: map[string]interface{}{"flowURI": flowURI}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create migration flow %s", flowURI)
+	}
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	migrationFlows[flowURI] = act
+	return act, nil
+}
+
+func (op *TransformOp) validate() error {
+	switch op.Op {
+	case "add", "default", "replace", "remove":
+	case "move", "copy":
+		if len(transformPath(op.From)) == 0 {
+			return errors.Errorf("from path of %s is not specified", op.Op)
+		}
+	default:
+		return errors.Errorf("transform operation %s is not supported", op.Op)
+	}
+	if len(transformPath(op.Path)) == 0 {
+		return errors.Errorf("path of %s is not specified", op.Op)
+	}
+	return nil
+}
+
+// apply executes the operation on a JSON object. Operations on missing fields are ignored.
+func (op *TransformOp) apply(doc map[string]interface{}) {
+	path := transformPath(op.Path)
+	switch op.Op {
+	case "add":
+		setField(doc, path, op.Value)
+	case "default":
+		if _, ok := getField(doc, path); !ok {
+			setField(doc, path, op.Value)
+		}
+	case "replace":
+		if _, ok := getField(doc, path); ok {
+			setField(doc, path, op.Value)
+		}
+	case "remove":
+		removeField(doc, path)
+	case "move", "copy":
+		from := transformPath(op.From)
+		if v, ok := getField(doc, from); ok {
+			if op.Op == "move" {
+				removeField(doc, from)
+			}
+			setField(doc, path, v)
+		}
+	}
+}
+
+// transformPath splits a dot-separated path, e.g., $.owner.name
+func transformPath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$.")
+	if len(path) == 0 {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+func getField(doc map[string]interface{}, path []string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, p := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[p]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// setField sets value of a path, and creates missing parent objects
+func setField(doc map[string]interface{}, path []string, value interface{}) {
+	m := doc
+	for _, p := range path[:len(path)-1] {
+		child, ok := m[p].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[p] = child
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = value
+}
+
+func removeField(doc map[string]interface{}, path []string) {
+	parent, ok := getField(doc, path[:len(path)-1])
+	if !ok {
+		return
+	}
+	if m, ok := parent.(map[string]interface{}); ok {
+		delete(m, path[len(path)-1])
+	}
+}
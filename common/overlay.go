@@ -0,0 +1,190 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"crypto/sha256"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// FabricWriteSet is the name of flow property for passing the write buffer of a transaction to activities
+const FabricWriteSet = "_write_set"
+
+// WriteBuffer holds states written by activities of a transaction.
+// Fabric does not return values written earlier in the same transaction, so activities read pending writes from the buffer first.
+type WriteBuffer struct {
+	lock   sync.RWMutex
+	writes map[string]map[string][]byte
+}
+
+// NewWriteBuffer returns an empty write buffer for a transaction
+func NewWriteBuffer() *WriteBuffer {
+	return &WriteBuffer{writes: make(map[string]map[string][]byte)}
+}
+
+// set records a pending write of a key, where nil value marks a deleted key
+func (b *WriteBuffer) set(collection, key string, value []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c, ok := b.writes[collection]
+	if !ok {
+		c = make(map[string][]byte)
+		b.writes[collection] = c
+	}
+	c[key] = copyBytes(value)
+}
+
+// get returns the pending value of a key, and false if the key is not written by the transaction
+func (b *WriteBuffer) get(collection, key string) ([]byte, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	v, ok := b.writes[collection][key]
+	return copyBytes(v), ok
+}
+
+// pending returns pending writes of keys in the range [startKey, endKey)
+func (b *WriteBuffer) pending(collection, startKey, endKey string) map[string][]byte {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	result := make(map[string][]byte)
+	for k, v := range b.writes[collection] {
+		if k >= startKey && (len(endKey) == 0 || k < endKey) {
+			result[k] = copyBytes(v)
+		}
+	}
+	return result
+}
+
+// bufferedStore is a StateStore that reads pending writes of the transaction before the states of the underlying store
+type bufferedStore struct {
+	StateStore
+	buffer *WriteBuffer
+}
+
+// NewBufferedStore returns a StateStore that writes to both a store and a write buffer, and reads the pending writes first
+func NewBufferedStore(store StateStore, buffer *WriteBuffer) StateStore {
+	if buffer == nil {
+		return store
+	}
+	return &bufferedStore{StateStore: store, buffer: buffer}
+}
+
+// GetState implements StateStore.GetState
+func (s *bufferedStore) GetState(collection, key string) ([]byte, error) {
+	if v, ok := s.buffer.get(collection, key); ok {
+		return v, nil
+	}
+	return s.StateStore.GetState(collection, key)
+}
+
+// GetStateHash implements StateStore.GetStateHash
+func (s *bufferedStore) GetStateHash(collection, key string) ([]byte, error) {
+	if v, ok := s.buffer.get(collection, key); ok && len(collection) > 0 {
+		if v == nil {
+			return nil, nil
+		}
+		h := sha256.Sum256(v)
+		return h[:], nil
+	}
+	return s.StateStore.GetStateHash(collection, key)
+}
+
+// PutState implements StateStore.PutState
+func (s *bufferedStore) PutState(collection, key string, value []byte) error {
+	if err := s.StateStore.PutState(collection, key, value); err != nil {
+		return err
+	}
+	s.buffer.set(collection, key, value)
+	return nil
+}
+
+// DelState implements StateStore.DelState
+func (s *bufferedStore) DelState(collection, key string) error {
+	if err := s.StateStore.DelState(collection, key); err != nil {
+		return err
+	}
+	s.buffer.set(collection, key, nil)
+	return nil
+}
+
+// GetStateByRange implements StateStore.GetStateByRange, and merges pending writes in the range of the returned page
+func (s *bufferedStore) GetStateByRange(collection, startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iter, md, err := s.StateStore.GetStateByRange(collection, startKey, endKey, pageSize, bookmark)
+	if err != nil {
+		return iter, md, err
+	}
+	if len(startKey) == 0 {
+		// exclude composite keys from range
+		startKey = emptyKeySubstitute
+	}
+	return s.merge(iter, md, collection, startKey, endKey, pageSize, bookmark)
+}
+
+// GetStateByPartialCompositeKey implements StateStore.GetStateByPartialCompositeKey, and merges pending writes of matching keys in the returned page
+func (s *bufferedStore) GetStateByPartialCompositeKey(collection, objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iter, md, err := s.StateStore.GetStateByPartialCompositeKey(collection, objectType, attributes, pageSize, bookmark)
+	if err != nil {
+		return iter, md, err
+	}
+	prefix, err := shim.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		iter.Close()
+		return nil, nil, err
+	}
+	return s.merge(iter, md, collection, prefix, prefix+string(utf8.MaxRune), pageSize, bookmark)
+}
+
+// merge returns sorted results of a query iterator merged with pending writes in the key range of the page,
+// i.e., from the start key or bookmark of the request to the bookmark of the next page or the end key.
+// Deleted keys are removed, and updated keys return pending values.
+func (s *bufferedStore) merge(iter shim.StateQueryIteratorInterface, md *pb.QueryResponseMetadata, collection, startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if pageSize > 0 && bookmark > startKey {
+		startKey = bookmark
+	}
+	if md != nil && len(md.Bookmark) > 0 && (len(endKey) == 0 || md.Bookmark < endKey) {
+		endKey = md.Bookmark
+	}
+	pending := s.buffer.pending(collection, startKey, endKey)
+	if len(pending) == 0 {
+		return iter, md, nil
+	}
+	defer iter.Close()
+
+	states := make(map[string][]byte)
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		states[kv.Key] = kv.Value
+	}
+	for k, v := range pending {
+		if v == nil {
+			delete(states, k)
+		} else {
+			states[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(states))
+	for k := range states {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := &stateIterator{}
+	for _, k := range keys {
+		result.results = append(result.results, &queryresult.KV{Key: k, Value: states[k]})
+	}
+	if md != nil {
+		md.FetchedRecordsCount = int32(len(result.results))
+	}
+	return result, md, nil
+}
@@ -0,0 +1,142 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/project-flogo/core/activity"
+)
+
+const (
+	// FabricSensitive is the name of flow property for passing sensitive JSON paths of a transaction to activities
+	FabricSensitive = "_sensitive_paths"
+	// Redacted replaces sensitive values in logs and events
+	Redacted = "***"
+
+	wildcardField = "*"
+)
+
+// Redactor masks values of sensitive JSON paths, so sensitive data are not written to logs or events.
+// A path is a dot-delimited list of field names, e.g., $.owner.ssn or owner.ssn, where * matches any field name.
+// Arrays are traversed transparently, so owner.accounts.number masks the number of every account in the array.
+// A nil Redactor returns data unchanged.
+type Redactor struct {
+	paths [][]string
+}
+
+// NewRedactor returns a redactor for a list of JSON paths, or nil if no path is specified.
+// Each item of the list may contain comma-delimited paths.
+func NewRedactor(paths ...string) *Redactor {
+	var result [][]string
+	for _, p := range paths {
+		for _, v := range strings.Split(p, ",") {
+			if fields := splitPath(v); len(fields) > 0 {
+				result = append(result, fields)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return &Redactor{paths: result}
+}
+
+// GetRedactor returns the redactor for the sensitive paths of an activity setting and the transaction of the activity context
+func GetRedactor(ctx activity.Context, paths ...string) *Redactor {
+	scope := masterScope(ctx)
+	if v, ok := scope.GetValue(FabricSensitive); ok && v != nil {
+		if txPaths, ok := v.([]string); ok {
+			paths = append(paths, txPaths...)
+		}
+	}
+	return NewRedactor(paths...)
+}
+
+// splitPath converts a JSON path to a list of field names
+func splitPath(path string) []string {
+	p := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	var fields []string
+	for _, f := range strings.Split(p, ".") {
+		// arrays are traversed transparently
+		f = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(f, "[*]"), "[]"))
+		if len(f) > 0 {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Redact returns a copy of data with values of sensitive paths replaced by Redacted
+func (r *Redactor) Redact(data interface{}) interface{} {
+	if r == nil {
+		return data
+	}
+	return redact(data, r.paths)
+}
+
+// RedactJSON returns serialized JSON data with values of sensitive paths replaced by Redacted.
+// An enveloped value is decoded first, and data that is not a JSON document is returned unchanged.
+func (r *Redactor) RedactJSON(data []byte) string {
+	if r == nil && !IsEnvelope(data) {
+		return string(data)
+	}
+	value, _, err := DecodeValue(data)
+	if err != nil {
+		if IsEnvelope(data) {
+			return Redacted
+		}
+		return string(data)
+	}
+	return r.String(value)
+}
+
+// String returns data serialized as JSON with values of sensitive paths replaced by Redacted
+func (r *Redactor) String(data interface{}) string {
+	jsonBytes, err := json.Marshal(r.Redact(data))
+	if err != nil {
+		return Redacted
+	}
+	return string(jsonBytes)
+}
+
+func redact(data interface{}, paths [][]string) interface{} {
+	if len(paths) == 0 {
+		return data
+	}
+	switch v := data.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, fv := range v {
+			var sub [][]string
+			masked := false
+			for _, p := range paths {
+				if p[0] != k && p[0] != wildcardField {
+					continue
+				}
+				if len(p) == 1 {
+					masked = true
+					break
+				}
+				sub = append(sub, p[1:])
+			}
+			if masked {
+				result[k] = Redacted
+			} else {
+				result[k] = redact(fv, sub)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = redact(item, paths)
+		}
+		return result
+	default:
+		return data
+	}
+}
@@ -0,0 +1,216 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package common
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/pkg/errors"
+	"github.com/project-flogo/core/activity"
+)
+
+// FabricStateStore is the name of flow property for passing a state store to activities, which overrides the chaincode stub
+const FabricStateStore = "_state_store"
+
+// CompositeKeyCodec creates and splits composite keys. It is implemented by both the chaincode stub and a StateStore.
+type CompositeKeyCodec interface {
+	// CreateCompositeKey combines an object type and attributes to form a composite key
+	CreateCompositeKey(objectType string, attributes []string) (string, error)
+	// SplitCompositeKey splits a composite key into the object type and attributes
+	SplitCompositeKey(compositeKey string) (string, []string, error)
+}
+
+// StateStore is the backend of ledger states read and written by activities.
+// An empty collection name refers to the world state, and other names refer to private data collections.
+// It is implemented for Fabric chaincode by ChaincodeStore, and for unit tests and non-Fabric apps by MemoryStore and FileStore.
+type StateStore interface {
+	CompositeKeyCodec
+	// GetState returns value of a key, or nil if the key does not exist
+	GetState(collection, key string) ([]byte, error)
+	// GetStateHash returns hash of the value of a key in a private data collection, or nil if the key does not exist
+	GetStateHash(collection, key string) ([]byte, error)
+	// PutState writes value of a key
+	PutState(collection, key string, value []byte) error
+	// DelState deletes a key
+	DelState(collection, key string) error
+	// GetStateByRange returns iterator of keys in the range [startKey, endKey), and query metadata if pageSize > 0
+	GetStateByRange(collection, startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error)
+	// GetStateByPartialCompositeKey returns iterator of composite keys matching the leading attributes, and query metadata if pageSize > 0
+	GetStateByPartialCompositeKey(collection, objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error)
+	// GetQueryResult returns iterator of states matching a rich query, and query metadata if pageSize > 0
+	GetQueryResult(collection, query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error)
+	// GetHistoryForKey returns iterator of the history of a key in the world state
+	GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error)
+	// GetValidationParameter returns the key-level endorsement policy of a key
+	GetValidationParameter(collection, key string) ([]byte, error)
+	// SetValidationParameter sets the key-level endorsement policy of a key
+	SetValidationParameter(collection, key string, ep []byte) error
+}
+
+var (
+	defaultStore StateStore
+	storeLock    sync.RWMutex
+)
+
+// SetDefaultStateStore sets the store used by activities of a flow that is not triggered by a chaincode transaction,
+// e.g., a MemoryStore or FileStore in a non-Fabric app
+func SetDefaultStateStore(store StateStore) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	defaultStore = store
+}
+
+// GetStateStore returns the state store of the activity context.
+// It is the store in the flow scope if specified, or else the chaincode stub of the transaction, or else the default store.
+// If the flow scope contains a write buffer, the store reads values written earlier by the same transaction.
+func GetStateStore(ctx activity.Context) (StateStore, error) {
+	scope := masterScope(ctx)
+	var buffer *WriteBuffer
+	if v, exists := scope.GetValue(FabricWriteSet); exists && v != nil {
+		buffer, _ = v.(*WriteBuffer)
+	}
+
+	if v, exists := scope.GetValue(FabricStateStore); exists && v != nil {
+		store, ok := v.(StateStore)
+		if !ok {
+			return nil, errors.Errorf("store type %T is not a StateStore", v)
+		}
+		return NewBufferedStore(store, buffer), nil
+	}
+	if v, exists := scope.GetValue(FabricStub); exists && v != nil {
+		stub, err := GetChaincodeStub(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return NewBufferedStore(NewChaincodeStore(stub), buffer), nil
+	}
+
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+	if defaultStore != nil {
+		return NewBufferedStore(defaultStore, buffer), nil
+	}
+	logger.Error("no state store found in flow scope")
+	return nil, errors.New("no state store found in flow scope")
+}
+
+// ChaincodeStore is the StateStore of Fabric ledger and private data collections accessed by a chaincode stub
+type ChaincodeStore struct {
+	stub shim.ChaincodeStubInterface
+}
+
+// NewChaincodeStore returns the state store of a chaincode stub
+func NewChaincodeStore(stub shim.ChaincodeStubInterface) *ChaincodeStore {
+	return &ChaincodeStore{stub: stub}
+}
+
+// Stub returns the chaincode stub of the store
+func (s *ChaincodeStore) Stub() shim.ChaincodeStubInterface {
+	return s.stub
+}
+
+// CreateCompositeKey implements CompositeKeyCodec.CreateCompositeKey
+func (s *ChaincodeStore) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return s.stub.CreateCompositeKey(objectType, attributes)
+}
+
+// SplitCompositeKey implements CompositeKeyCodec.SplitCompositeKey
+func (s *ChaincodeStore) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	return s.stub.SplitCompositeKey(compositeKey)
+}
+
+// GetState implements StateStore.GetState
+func (s *ChaincodeStore) GetState(collection, key string) ([]byte, error) {
+	if len(collection) == 0 {
+		return s.stub.GetState(key)
+	}
+	return s.stub.GetPrivateData(collection, key)
+}
+
+// GetStateHash implements StateStore.GetStateHash
+func (s *ChaincodeStore) GetStateHash(collection, key string) ([]byte, error) {
+	if len(collection) == 0 {
+		return nil, errors.New("state hash is available for private data collection only")
+	}
+	return s.stub.GetPrivateDataHash(collection, key)
+}
+
+// PutState implements StateStore.PutState
+func (s *ChaincodeStore) PutState(collection, key string, value []byte) error {
+	if len(collection) == 0 {
+		return s.stub.PutState(key, value)
+	}
+	return s.stub.PutPrivateData(collection, key, value)
+}
+
+// DelState implements StateStore.DelState
+func (s *ChaincodeStore) DelState(collection, key string) error {
+	if len(collection) == 0 {
+		return s.stub.DelState(key)
+	}
+	return s.stub.DelPrivateData(collection, key)
+}
+
+// GetStateByRange implements StateStore.GetStateByRange. Page size is ignored for private data collection.
+func (s *ChaincodeStore) GetStateByRange(collection, startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if len(collection) == 0 {
+		if pageSize > 0 {
+			return s.stub.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+		}
+		iter, err := s.stub.GetStateByRange(startKey, endKey)
+		return iter, nil, err
+	}
+	iter, err := s.stub.GetPrivateDataByRange(collection, startKey, endKey)
+	return iter, nil, err
+}
+
+// GetStateByPartialCompositeKey implements StateStore.GetStateByPartialCompositeKey. Page size is ignored for private data collection.
+func (s *ChaincodeStore) GetStateByPartialCompositeKey(collection, objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if len(collection) == 0 {
+		if pageSize > 0 {
+			return s.stub.GetStateByPartialCompositeKeyWithPagination(objectType, attributes, pageSize, bookmark)
+		}
+		iter, err := s.stub.GetStateByPartialCompositeKey(objectType, attributes)
+		return iter, nil, err
+	}
+	iter, err := s.stub.GetPrivateDataByPartialCompositeKey(collection, objectType, attributes)
+	return iter, nil, err
+}
+
+// GetQueryResult implements StateStore.GetQueryResult. Page size is ignored for private data collection.
+func (s *ChaincodeStore) GetQueryResult(collection, query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if len(collection) == 0 {
+		if pageSize > 0 {
+			return s.stub.GetQueryResultWithPagination(query, pageSize, bookmark)
+		}
+		iter, err := s.stub.GetQueryResult(query)
+		return iter, nil, err
+	}
+	iter, err := s.stub.GetPrivateDataQueryResult(collection, query)
+	return iter, nil, err
+}
+
+// GetHistoryForKey implements StateStore.GetHistoryForKey
+func (s *ChaincodeStore) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return s.stub.GetHistoryForKey(key)
+}
+
+// GetValidationParameter implements StateStore.GetValidationParameter
+func (s *ChaincodeStore) GetValidationParameter(collection, key string) ([]byte, error) {
+	if len(collection) == 0 {
+		return s.stub.GetStateValidationParameter(key)
+	}
+	return s.stub.GetPrivateDataValidationParameter(collection, key)
+}
+
+// SetValidationParameter implements StateStore.SetValidationParameter
+func (s *ChaincodeStore) SetValidationParameter(collection, key string, ep []byte) error {
+	if len(collection) == 0 {
+		return s.stub.SetStateValidationParameter(key, ep)
+	}
+	return s.stub.SetPrivateDataValidationParameter(collection, key, ep)
+}
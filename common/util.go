@@ -5,8 +5,9 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package common
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"sort"
 
 	"github.com/pkg/errors"
 
@@ -14,11 +15,13 @@ import (
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/oliveagle/jsonpath"
 	"github.com/project-flogo/core/activity"
+	"github.com/project-flogo/core/data"
 	"github.com/project-flogo/core/data/coerce"
 	"github.com/project-flogo/core/data/expression"
 	"github.com/project-flogo/core/data/resolve"
 	"github.com/project-flogo/core/data/schema"
 	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/core/trigger"
 	"github.com/project-flogo/flow/instance"
 )
 
@@ -77,14 +80,28 @@ func MapToObject(data interface{}) (map[string]interface{}, error) {
 	return val, nil
 }
 
-// GetChaincodeStub returns Fabric chaincode stub from the activity context
-func GetChaincodeStub(ctx activity.Context) (shim.ChaincodeStubInterface, error) {
-	// get chaincode stub - protect call to GetMasterScope to support unit test with mock
+// ActivityStub is implemented by a chaincode stub that attributes ledger operations to the calling activity,
+// e.g., for tracing and metrics of the transaction trigger
+type ActivityStub interface {
+	shim.ChaincodeStubInterface
+	// WithActivity returns a stub that attributes ledger operations to the named activity
+	WithActivity(name string) shim.ChaincodeStubInterface
+}
+
+// masterScope returns the flow scope of an activity, which contains the transaction values set by the trigger.
+// The type check of the flow instance supports unit tests with mock activity hosts.
+func masterScope(ctx activity.Context) data.Scope {
 	scope := ctx.ActivityHost().Scope()
 	if inst, ok := scope.(*instance.Instance); ok {
 		scope = inst.GetMasterScope()
 	}
-	logger.Debugf("flow scope: %+v", scope)
+	return scope
+}
+
+// GetChaincodeStub returns Fabric chaincode stub from the activity context
+func GetChaincodeStub(ctx activity.Context) (shim.ChaincodeStubInterface, error) {
+	scope := masterScope(ctx)
+	logger.Debugf("flow scope: %T", scope)
 
 	if stub, exists := scope.GetValue(FabricStub); exists && stub != nil {
 		ccshim, found := stub.(shim.ChaincodeStubInterface)
@@ -92,12 +109,44 @@ func GetChaincodeStub(ctx activity.Context) (shim.ChaincodeStubInterface, error)
 			logger.Errorf("stub type %T is not a ChaincodeStubInterface\n", stub)
 			return nil, errors.Errorf("stub type %T is not a ChaincodeStubInterface", stub)
 		}
+		if as, ok := ccshim.(ActivityStub); ok {
+			return as.WithActivity(ctx.Name()), nil
+		}
 		return ccshim, nil
 	}
 	logger.Error("no stub found in flow scope")
 	return nil, errors.New("no stub found in flow scope")
 }
 
+// TransactionID returns the ID of the chaincode transaction in the flow scope of an activity, or empty string if it is not available
+func TransactionID(ctx activity.Context) string {
+	scope := masterScope(ctx)
+	if v, exists := scope.GetValue(FabricTxID); exists && v != nil {
+		if txID, ok := v.(string); ok {
+			return txID
+		}
+	}
+	if v, exists := scope.GetValue(FabricStub); exists && v != nil {
+		if stub, ok := v.(shim.ChaincodeStubInterface); ok {
+			return stub.GetTxID()
+		}
+	}
+	return ""
+}
+
+// TransactionContext returns a context that carries the transaction values of the flow scope of an activity,
+// i.e., the chaincode stub, txID, txTime, client ID, write buffer and state store, e.g., for executing subflows of the same transaction
+func TransactionContext(ctx activity.Context) context.Context {
+	scope := masterScope(ctx)
+	values := make(map[string]interface{})
+	for _, name := range []string{FabricStub, FabricTxID, FabricTxTime, FabricCID, FabricWriteSet, FabricStateStore} {
+		if v, exists := scope.GetValue(name); exists && v != nil {
+			values[name] = v
+		}
+	}
+	return trigger.NewContextWithValues(context.Background(), values)
+}
+
 // GetActivityInputSchema returns schema of an activity input attribute
 func GetActivityInputSchema(ctx activity.Context, name string) (string, error) {
 	if sIO, ok := ctx.(schema.HasSchemaIO); ok {
@@ -127,7 +176,8 @@ func GetActivityOutputSchema(ctx activity.Context, name string) (string, error)
 // which is shown in normal flogo mapper as, e.g., "$.content"
 func ResolveFlowData(toResolve string, context activity.Context) (value interface{}, err error) {
 	actionCtx := context.ActivityHost()
-	logger.Debugf("Resolving flow data %s; context data: %+v", toResolve, actionCtx.Scope())
+	// do not log values of flow data, which may be sensitive
+	logger.Debugf("Resolving flow data %s", toResolve)
 	factory := expression.NewFactory(resolve.GetBasicResolver())
 	expr, err := factory.NewExpr(toResolve)
 	if err != nil {
@@ -137,77 +187,116 @@ func ResolveFlowData(toResolve string, context activity.Context) (value interfac
 	if err != nil {
 		logger.Errorf("failed to resolve expression %+v", err)
 	}
-	logger.Debugf("Resolved value for %s: %T - %+v", toResolve, actValue, actValue)
+	logger.Debugf("Resolved value for %s: %T", toResolve, actValue)
 	return actValue, err
 }
 
 // ExtractCompositeKeys collects all valid composite-keys matching composite-key definitions using fields of a value object
-func ExtractCompositeKeys(stub shim.ChaincodeStubInterface, compositeKeyDefs map[string][]string, keyValue string, value interface{}) []string {
+func ExtractCompositeKeys(stub CompositeKeyCodec, compositeKeyDefs map[string][]string, keyValue string, value interface{}) []string {
 	// check arguments
 	if len(compositeKeyDefs) == 0 || value == nil {
 		logger.Debugf("No composite keys because state value is not a non-zero map\n")
 		return nil
 	}
 
-	// construct composite keys
+	// construct composite keys in the order of key names
+	keyNames := make([]string, 0, len(compositeKeyDefs))
+	for keyName := range compositeKeyDefs {
+		keyNames = append(keyNames, keyName)
+	}
+	sort.Strings(keyNames)
 	var compositeKeys []string
-	for keyName, attributes := range compositeKeyDefs {
-		if ck, _ := MakeCompositeKey(stub, keyName, attributes, keyValue, value); len(ck) > 0 {
+	for _, keyName := range keyNames {
+		if ck, _ := MakeCompositeKey(stub, keyName, compositeKeyDefs[keyName], keyValue, value); len(ck) > 0 {
 			compositeKeys = append(compositeKeys, ck)
 		}
 	}
 	return compositeKeys
 }
 
+// DiffCompositeKeys compares composite keys of the prior and new values of a state,
+// and returns the stale keys of the prior value, and the keys that do not exist for the prior value
+func DiffCompositeKeys(oldKeys, newKeys []string) ([]string, []string) {
+	oldSet := make(map[string]bool, len(oldKeys))
+	for _, k := range oldKeys {
+		oldSet[k] = true
+	}
+	newSet := make(map[string]bool, len(newKeys))
+	var added []string
+	for _, k := range newKeys {
+		newSet[k] = true
+		if !oldSet[k] {
+			added = append(added, k)
+		}
+	}
+	var stale []string
+	for _, k := range oldKeys {
+		if !newSet[k] {
+			stale = append(stale, k)
+		}
+	}
+	return stale, added
+}
+
 // MakeCompositeKey constructs composite key if all specified attributes exist in the value object
 //   attributes contain JsonPath for fields in value objects
 // returns key, false if key does not include all fields defined in the attributes
-func MakeCompositeKey(stub shim.ChaincodeStubInterface, keyName string, attributes []string, keyValue string, value interface{}) (string, bool) {
+func MakeCompositeKey(stub CompositeKeyCodec, keyName string, attributes []string, keyValue string, value interface{}) (string, bool) {
 	if len(keyName) == 0 || len(attributes) == 0 {
 		logger.Debugf("invalid composite key definition: name %s attributes %+v\n", keyName, attributes)
 		return "", false
 	}
 	attrValues := ExtractDataAttributes(attributes, value)
 	if len(attrValues) == 0 {
-		logger.Infof("no field specified for composite key %s in data %+v\n", keyName, value)
+		logger.Infof("no field specified for composite key %s in data\n", keyName)
 		return "", false
 	}
 
 	if len(attrValues) >= len(attributes)-1 {
 		//  append the key value if at most 1 attribute missing and key value is not included
-		if len(keyValue) > 0 && keyValue != attrValues[len(attrValues)-1] {
+		//  the last attribute may be type-encoded, so it is compared with the key value in its decoded form
+		_, attrType := ParseKeyAttribute(attributes[len(attrValues)-1])
+		last, err := DecodeKeyAttribute(attrValues[len(attrValues)-1], attrType)
+		if len(keyValue) > 0 && (err != nil || keyValue != last) {
 			attrValues = append(attrValues, keyValue)
 		}
 	}
 
 	compositeKey, err := stub.CreateCompositeKey(keyName, attrValues)
 	if err != nil {
-		logger.Warnf("failed to create composite key %s with values %+v\n", keyName, attrValues)
+		logger.Warnf("failed to create composite key %s with attributes %+v: %v\n", keyName, attributes, err)
 		return "", false
 	}
 	return compositeKey, len(attrValues) >= len(attributes)
 }
 
-// ExtractDataAttributes collects values of specified attributes from a data object for constructing a partial composite key
+// ExtractDataAttributes collects values of specified attributes from a data object for constructing a partial composite key.
+// Attributes of format 'path:type' are encoded by EncodeKeyAttribute, so int, decimal, and date values sort in their natural order.
 func ExtractDataAttributes(attrs []string, data interface{}) []string {
 	if len(attrs) == 0 || data == nil {
 		return nil
 	}
 
 	var values []string
-	for _, f := range attrs {
+	for _, a := range attrs {
+		f, attrType := ParseKeyAttribute(a)
 		v, err := jsonpath.JsonPathLookup(data, f)
 		if err != nil {
 			logger.Debugf("composite key attribute %s is not found in input data\n", f)
 			break
 		}
-		values = append(values, fmt.Sprintf("%v", v))
+		ev, err := EncodeKeyAttribute(v, attrType)
+		if err != nil {
+			logger.Warnf("composite key attribute %s is ignored: %v\n", f, err)
+			break
+		}
+		values = append(values, ev)
 	}
 	return values
 }
 
 // PutData writes key and value to the ledger if 'store' is not specified, or a private data collection specified by 'store'
-func PutData(stub shim.ChaincodeStubInterface, store string, key string, value []byte) error {
+func PutData(ss StateStore, store string, key string, value []byte) error {
 	if len(key) == 0 {
 		return errors.New("key is not specified for Put")
 	}
@@ -223,14 +312,11 @@ func PutData(stub shim.ChaincodeStubInterface, store string, key string, value [
 	}
 
 	// write data to ledger or private data collection
-	if len(store) == 0 {
-		return stub.PutState(key, v)
-	}
-	return stub.PutPrivateData(store, key, v)
+	return ss.PutState(store, key, v)
 }
 
 // GetData retrieves data by state key from the ledger if 'store' is not specified, or a private data collection specified by 'store'
-func GetData(stub shim.ChaincodeStubInterface, store string, key string, privateHash bool) (string, []byte, error) {
+func GetData(ss StateStore, store string, key string, privateHash bool) (string, []byte, error) {
 	if len(key) == 0 {
 		return key, nil, errors.New("key is not specified for Get")
 	}
@@ -238,63 +324,47 @@ func GetData(stub shim.ChaincodeStubInterface, store string, key string, private
 	k := key
 	if IsCompositeKey(key) {
 		// this is a composite key, so extract state key from it
-		if ck, err := SplitCompositeKey(stub, key); err == nil {
+		if ck, err := SplitCompositeKey(ss, key); err == nil {
 			k = ck.Key
 		}
 	}
 
 	// retrieve data from ledger or private data collection
-	if len(store) == 0 {
-		data, err := stub.GetState(k)
-		return k, data, err
-	}
-	if privateHash {
-		data, err := stub.GetPrivateDataHash(store, k)
+	if len(store) > 0 && privateHash {
+		data, err := ss.GetStateHash(store, k)
 		return k, data, err
 	}
-	data, err := stub.GetPrivateData(store, k)
+	data, err := ss.GetState(store, k)
 	return k, data, err
 }
 
 // DeleteData deletes a state or a composite key from the ledger if 'store' is not specified, or a private data collection specified by 'store'
-func DeleteData(stub shim.ChaincodeStubInterface, store string, key string) error {
+func DeleteData(ss StateStore, store string, key string) error {
 	if len(key) == 0 {
 		return errors.New("key is not specified for Delete")
 	}
 
 	// delete data from ledger or private data collection
-	if len(store) == 0 {
-		return stub.DelState(key)
-	}
-	return stub.DelPrivateData(store, key)
+	return ss.DelState(store, key)
 }
 
 // GetCompositeKeys retrieves iterator for composite keys from from the ledger if 'store' is not specified, or a private data collection specified by 'store'
-func GetCompositeKeys(stub shim.ChaincodeStubInterface, store string, name string, values []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+func GetCompositeKeys(ss StateStore, store string, name string, values []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
 	if len(name) == 0 || len(values) == 0 {
 		return nil, nil, errors.New("name and attributes are not specified for composite key")
 	}
 
 	// retrieve iterator of composite keys from ledger
-	if len(store) == 0 {
-		if pageSize > 0 {
-			return stub.GetStateByPartialCompositeKeyWithPagination(name, values, pageSize, bookmark)
-		}
-		iter, err := stub.GetStateByPartialCompositeKey(name, values)
-		return iter, nil, err
-	}
-	// ignore pagesize for private data collection
-	iter, err := stub.GetPrivateDataByPartialCompositeKey(store, name, values)
-	return iter, nil, err
+	return ss.GetStateByPartialCompositeKey(store, name, values, pageSize, bookmark)
 }
 
 // IsCompositeKey returns true if a key belongs to composite key namespace
 func IsCompositeKey(key string) bool {
-	return key[0] == compositeKeyNamespace[0]
+	return len(key) > 0 && key[0] == compositeKeyNamespace[0]
 }
 
-// SplitCompositeKey returns components of a composite key
-func SplitCompositeKey(stub shim.ChaincodeStubInterface, key string) (*CompositeKey, error) {
+// SplitCompositeKey returns components of a composite key, and decodes typed attributes registered by RegisterCompositeKeys
+func SplitCompositeKey(stub CompositeKeyCodec, key string) (*CompositeKey, error) {
 	if !IsCompositeKey(key) {
 		return nil, errors.New("key value is not a composite key")
 	}
@@ -307,7 +377,27 @@ func SplitCompositeKey(stub shim.ChaincodeStubInterface, key string) (*Composite
 		return nil, errors.New("key value contains no attributes")
 	}
 
-	// the last composite attribute must be the state key
+	// decode typed attributes of registered composite key definition
+	types := keyAttributeTypes(name)
+	for i := 0; i < len(types) && i < len(attrs); i++ {
+		v, err := DecodeKeyAttribute(attrs[i], types[i])
+		if err != nil {
+			if i < len(attrs)-1 {
+				logger.Warnf("failed to decode attribute %d of composite key %s: %v", i, name, err)
+			}
+			continue
+		}
+		if i == len(attrs)-1 {
+			// the state key is appended as is if the value has no such attribute,
+			// so the last attribute is decoded only if it is in the encoded form of its type
+			if ev, err := EncodeKeyAttribute(v, types[i]); err != nil || ev != attrs[i] {
+				continue
+			}
+		}
+		attrs[i] = v
+	}
+
+	// the last composite attribute must be the state key, which is decoded if it is a typed attribute
 	stateKey := attrs[len(attrs)-1]
 	return &CompositeKey{
 		Name:   name,
@@ -343,33 +433,11 @@ func (b *CompositeKeyBag) ToMap() (map[string]interface{}, error) {
 }
 
 // GetDataByRange retrieves iterator for range of state keys from from the ledger if 'store' is not specified, or a private data collection specified by 'store'
-func GetDataByRange(stub shim.ChaincodeStubInterface, store, startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
-
-	// retrieve iterator of state range from ledger
-	if len(store) == 0 {
-		if pageSize > 0 {
-			return stub.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
-		}
-		iter, err := stub.GetStateByRange(startKey, endKey)
-		return iter, nil, err
-	}
-	// ignore pagesize for private data collection
-	iter, err := stub.GetPrivateDataByRange(store, startKey, endKey)
-	return iter, nil, err
+func GetDataByRange(ss StateStore, store, startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return ss.GetStateByRange(store, startKey, endKey, pageSize, bookmark)
 }
 
 // GetDataByQuery retrieves iterator for rich query from from the ledger if 'store' is not specified, or a private data collection specified by 'store'
-func GetDataByQuery(stub shim.ChaincodeStubInterface, store, query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
-
-	// retrieve iterator of state range from ledger
-	if len(store) == 0 {
-		if pageSize > 0 {
-			return stub.GetQueryResultWithPagination(query, pageSize, bookmark)
-		}
-		iter, err := stub.GetQueryResult(query)
-		return iter, nil, err
-	}
-	// ignore pagesize for private data collection
-	iter, err := stub.GetPrivateDataQueryResult(store, query)
-	return iter, nil, err
+func GetDataByQuery(ss StateStore, store, query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return ss.GetQueryResult(store, query, pageSize, bookmark)
 }
@@ -6,11 +6,21 @@ package common
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-chaincode-go/shimtest"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 func TestMockLedger(t *testing.T) {
@@ -57,6 +67,12 @@ func TestMockLedger(t *testing.T) {
 	assert.Equal(t, 2, len(keys), "it should extract 2 composite keys")
 	assert.Equal(t, ck, keys[1], "first key should be identical to marble1 with owner tom")
 
+	// test diff of composite keys when owner is changed
+	updated := map[string]interface{}{"docType": "marble", "color": "blue", "owner": "jerry"}
+	stale, added := DiffCompositeKeys(keys, ExtractCompositeKeys(stub, def, "marble1", updated))
+	assert.Equal(t, []string{ck}, stale, "composite key of owner tom should be stale")
+	assert.Equal(t, 1, len(added), "only composite key of owner jerry should be added")
+
 	data = `{
 		"docType": "marble",
 		"name": "marble1",
@@ -66,16 +82,17 @@ func TestMockLedger(t *testing.T) {
 	}`
 
 	// test PutData
+	ss := NewChaincodeStore(stub)
 	stub.MockTransactionStart("1")
-	err = PutData(stub, "", "marble1", []byte(data))
+	err = PutData(ss, "", "marble1", []byte(data))
 	assert.NoError(t, err, "put state data should not throw error")
-	err = PutData(stub, "", keys[1], nil)
+	err = PutData(ss, "", keys[1], nil)
 	assert.NoError(t, err, "put composite key should not throw error")
 	stub.MockTransactionEnd("1")
 
 	// test GetData
 	stub.MockTransactionStart("2")
-	k, v, err := GetData(stub, "", "marble1", false)
+	k, v, err := GetData(ss, "", "marble1", false)
 	assert.NoError(t, err, "get state data should not throw error")
 	assert.Equal(t, "marble1", k, "state key should be 'marble1'")
 	err = json.Unmarshal([]byte(v), &state)
@@ -83,7 +100,7 @@ func TestMockLedger(t *testing.T) {
 	assert.Equal(t, "marble1", state["name"], "name of the record should be 'marble1'")
 
 	// test GetData using a composite key
-	k2, v2, err := GetData(stub, "", keys[1], false)
+	k2, v2, err := GetData(ss, "", keys[1], false)
 	assert.NoError(t, err, "get state using composite key should not throw error")
 	assert.Equal(t, k, k2, "composite key should return the same state key")
 	assert.Equal(t, 0, bytes.Compare(v2, v), "composite key should return the same state value")
@@ -91,18 +108,18 @@ func TestMockLedger(t *testing.T) {
 
 	// test DeleteData
 	stub.MockTransactionStart("3")
-	err = DeleteData(stub, "", "marble1")
+	err = DeleteData(ss, "", "marble1")
 	assert.NoError(t, err, "delete state data should not throw error")
-	err = DeleteData(stub, "", keys[1])
+	err = DeleteData(ss, "", keys[1])
 	assert.NoError(t, err, "delete composite key should not throw error")
 	stub.MockTransactionEnd("3")
 
 	// verify result of deletion
 	stub.MockTransactionStart("4")
-	_, v, err = GetData(stub, "", "marble1", false)
+	_, v, err = GetData(ss, "", "marble1", false)
 	assert.NoError(t, err, "retrieve non-existing state data should not throw error")
 	assert.Nil(t, v, "retrieve non-existing state data should return nil")
-	_, v, err = GetData(stub, "", keys[1], false)
+	_, v, err = GetData(ss, "", keys[1], false)
 	assert.NoError(t, err, "retrieve non-existing composite key should not throw error")
 	assert.Nil(t, v, "retrieve non-existing composite key should return nil")
 	stub.MockTransactionEnd("4")
@@ -120,3 +137,570 @@ func TestExtractDataAttributes(t *testing.T) {
 	assert.Equal(t, 2, len(result), "it should extract 2 attribute fields")
 	assert.Equal(t, "blue", result[1], "second attribute should be 'blue'")
 }
+
+func TestRedactor(t *testing.T) {
+	assert.Nil(t, NewRedactor("", " , "), "redactor of no path should be nil")
+	var nilRedactor *Redactor
+	assert.Equal(t, `{"ssn":"123"}`, nilRedactor.RedactJSON([]byte(`{"ssn":"123"}`)), "nil redactor should not change data")
+
+	sample := `{
+		"name": "marble1",
+		"ssn": "123-45-6789",
+		"owner": {"name": "tom", "pin": 1234},
+		"accounts": [{"number": "111", "bank": "abc"}, {"number": "222", "bank": "xyz"}],
+		"secrets": {"a": 1, "b": 2}
+	}`
+	r := NewRedactor("ssn, $.owner.pin", "accounts[*].number", "secrets.*")
+	var data interface{}
+	err := json.Unmarshal([]byte(sample), &data)
+	assert.NoError(t, err, "sample should be valid JSON")
+	result := r.Redact(data).(map[string]interface{})
+	assert.Equal(t, Redacted, result["ssn"], "top-level field should be redacted")
+	assert.Equal(t, "marble1", result["name"], "other field should not be redacted")
+	assert.Equal(t, map[string]interface{}{"name": "tom", "pin": Redacted}, result["owner"], "nested field should be redacted")
+	accounts := result["accounts"].([]interface{})
+	assert.Equal(t, Redacted, accounts[1].(map[string]interface{})["number"], "field of array items should be redacted")
+	assert.Equal(t, "xyz", accounts[1].(map[string]interface{})["bank"], "other field of array items should not be redacted")
+	assert.Equal(t, map[string]interface{}{"a": Redacted, "b": Redacted}, result["secrets"], "wildcard should redact all fields")
+	assert.Equal(t, "123-45-6789", data.(map[string]interface{})["ssn"], "original data should not be changed")
+
+	assert.Equal(t, "not json", r.RedactJSON([]byte("not json")), "non-JSON data should not be changed")
+	assert.Equal(t, `{"pin":1,"ssn":"***"}`, r.RedactJSON([]byte(`{"ssn":"123","pin":1}`)))
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	marbles := map[string]string{
+		"marble1": `{"docType":"marble","name":"marble1","color":"blue","size":50,"owner":"tom"}`,
+		"marble2": `{"docType":"marble","name":"marble2","color":"red","size":60,"owner":"tom"}`,
+		"marble3": `{"docType":"marble","name":"marble3","color":"blue","size":70,"owner":"jerry"}`,
+	}
+	def := map[string][]string{"owner~name": {"$.docType", "$.owner", "$.name"}}
+	for k, v := range marbles {
+		assert.NoError(t, PutData(store, "", k, []byte(v)), "put state data should not throw error")
+		var value interface{}
+		json.Unmarshal([]byte(v), &value)
+		for _, ck := range ExtractCompositeKeys(store, def, k, value) {
+			assert.NoError(t, PutData(store, "", ck, nil), "put composite key should not throw error")
+		}
+	}
+
+	// composite key is compatible with the chaincode stub
+	ck, err := store.CreateCompositeKey("owner~name", []string{"marble", "tom", "marble1"})
+	assert.NoError(t, err, "create composite key should not throw error")
+	kv, err := SplitCompositeKey(store, ck)
+	assert.NoError(t, err, "split composite key should succeed")
+	assert.Equal(t, "marble1", kv.Key, "state key should be 'marble1'")
+	k, v, err := GetData(store, "", ck, false)
+	assert.NoError(t, err, "get state using composite key should not throw error")
+	assert.Equal(t, "marble1", k, "composite key should return state key 'marble1'")
+	assert.Equal(t, marbles["marble1"], string(v), "composite key should return state of 'marble1'")
+
+	// range query excludes composite keys, and returns bookmark of the next page
+	iter, md, err := GetDataByRange(store, "", "", "", 2, "")
+	assert.NoError(t, err, "range query should not throw error")
+	assert.Equal(t, 2, countStates(iter), "range query should return 1 page of 2 states")
+	assert.Equal(t, "marble3", md.Bookmark, "bookmark should be the key of the next page")
+	iter, md, err = GetDataByRange(store, "", "", "", 2, md.Bookmark)
+	assert.NoError(t, err, "range query should not throw error")
+	assert.Equal(t, 1, countStates(iter), "range query should return last page of 1 state")
+	assert.Equal(t, "", md.Bookmark, "bookmark of the last page should be empty")
+
+	// partial key query
+	iter, _, err = GetCompositeKeys(store, "", "owner~name", []string{"marble", "tom"}, 0, "")
+	assert.NoError(t, err, "partial key query should not throw error")
+	assert.Equal(t, 2, countStates(iter), "partial key query should return 2 keys of owner tom")
+
+	// rich query
+	iter, _, err = GetDataByQuery(store, "", `{"selector":{"docType":"marble","size":{"$gte":60},"$or":[{"color":"red"},{"owner":"jerry"}]}}`, 0, "")
+	assert.NoError(t, err, "rich query should not throw error")
+	assert.Equal(t, 2, countStates(iter), "rich query should return 2 states")
+	_, _, err = GetDataByQuery(store, "", `{"selector":{"name":{"$regex":"marble"}}}`, 0, "")
+	assert.Error(t, err, "rich query should not support $regex")
+
+	// delete and history
+	assert.NoError(t, DeleteData(store, "", "marble1"), "delete state data should not throw error")
+	_, v, err = GetData(store, "", "marble1", false)
+	assert.NoError(t, err, "retrieve non-existing state data should not throw error")
+	assert.Nil(t, v, "retrieve non-existing state data should return nil")
+	hist, err := store.GetHistoryForKey("marble1")
+	assert.NoError(t, err, "history query should not throw error")
+	count := 0
+	for hist.HasNext() {
+		h, _ := hist.Next()
+		count++
+		assert.Equal(t, count == 2, h.IsDelete, "only the second history record should be a delete")
+	}
+	assert.Equal(t, 2, count, "history should contain 2 records")
+
+	// private data hash
+	assert.NoError(t, PutData(store, "pdc", "marble1", []byte(marbles["marble1"])), "put private data should not throw error")
+	_, v, err = GetData(store, "pdc", "marble1", true)
+	assert.NoError(t, err, "get private data hash should not throw error")
+	assert.Equal(t, 32, len(v), "private data hash should be sha256")
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err, "create temp dir should not throw error")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	store, err := NewFileStore(path)
+	assert.NoError(t, err, "create file store should not throw error")
+	assert.NoError(t, store.PutState("", "k1", []byte(`{"a":1}`)), "put state should not throw error")
+	assert.NoError(t, store.PutState("pdc", "k2", []byte(`{"b":2}`)), "put private data should not throw error")
+	assert.NoError(t, store.SetValidationParameter("", "k1", []byte("ep")), "set validation parameter should not throw error")
+
+	// reload states from the file
+	store, err = NewFileStore(path)
+	assert.NoError(t, err, "reload file store should not throw error")
+	v, _ := store.GetState("", "k1")
+	assert.Equal(t, `{"a":1}`, string(v), "state should be reloaded")
+	v, _ = store.GetState("pdc", "k2")
+	assert.Equal(t, `{"b":2}`, string(v), "private data should be reloaded")
+	v, _ = store.GetValidationParameter("", "k1")
+	assert.Equal(t, "ep", string(v), "validation parameter should be reloaded")
+
+	assert.NoError(t, store.DelState("", "k1"), "delete state should not throw error")
+	store, err = NewFileStore(path)
+	assert.NoError(t, err, "reload file store should not throw error")
+	v, _ = store.GetState("", "k1")
+	assert.Nil(t, v, "deleted state should not be reloaded")
+
+	// updates of a batch are saved on commit
+	store.Begin()
+	assert.NoError(t, store.PutState("", "k3", []byte(`{"c":3}`)), "put state in batch should not throw error")
+	assert.NoError(t, store.PutState("", "k4", []byte(`{"d":4}`)), "put state in batch should not throw error")
+	reloaded, err := NewFileStore(path)
+	assert.NoError(t, err, "reload file store should not throw error")
+	v, _ = reloaded.GetState("", "k3")
+	assert.Nil(t, v, "state of open batch should not be saved")
+	assert.NoError(t, store.Commit(), "commit batch should not throw error")
+	reloaded, err = NewFileStore(path)
+	assert.NoError(t, err, "reload file store should not throw error")
+	v, _ = reloaded.GetState("", "k4")
+	assert.Equal(t, `{"d":4}`, string(v), "state of committed batch should be saved")
+}
+
+func countStates(iter shim.StateQueryIteratorInterface) int {
+	defer iter.Close()
+	count := 0
+	for iter.HasNext() {
+		if _, err := iter.Next(); err == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// staleStore ignores writes of a transaction, like the Fabric ledger before commit
+type staleStore struct {
+	*MemoryStore
+}
+
+func (s *staleStore) PutState(collection, key string, value []byte) error {
+	return nil
+}
+
+func (s *staleStore) DelState(collection, key string) error {
+	return nil
+}
+
+func TestWriteBuffer(t *testing.T) {
+	base := &staleStore{MemoryStore: NewMemoryStore()}
+	for _, k := range []string{"a1", "a3", "a5"} {
+		base.MemoryStore.PutState("", k, []byte(`"`+k+`"`))
+	}
+	ck, _ := base.CreateCompositeKey("idx", []string{"x", "a1"})
+	base.MemoryStore.PutState("", ck, []byte{0x00})
+	store := NewBufferedStore(base, NewWriteBuffer())
+
+	// read own writes
+	assert.NoError(t, PutData(store, "", "a2", []byte(`"a2"`)), "put state should not throw error")
+	assert.NoError(t, PutData(store, "", "a3", []byte(`"new"`)), "put state should not throw error")
+	assert.NoError(t, DeleteData(store, "", "a5"), "delete state should not throw error")
+	v, _ := base.GetState("", "a2")
+	assert.Nil(t, v, "base store should not see pending write")
+	_, v, _ = GetData(store, "", "a2", false)
+	assert.Equal(t, `"a2"`, string(v), "buffered store should see pending write")
+	_, v, _ = GetData(store, "", "a5", false)
+	assert.Nil(t, v, "buffered store should not see pending delete")
+
+	// range query merges pending writes
+	iter, _, err := GetDataByRange(store, "", "", "", 0, "")
+	assert.NoError(t, err, "range query should not throw error")
+	var keys []string
+	for iter.HasNext() {
+		kv, _ := iter.Next()
+		keys = append(keys, kv.Key)
+		if kv.Key == "a3" {
+			assert.Equal(t, `"new"`, string(kv.Value), "range query should return pending value")
+		}
+	}
+	assert.Equal(t, []string{"a1", "a2", "a3"}, keys, "range query should merge pending writes")
+
+	// paged range query merges pending writes before the bookmark of the next page
+	iter, md, err := GetDataByRange(store, "", "", "", 1, "")
+	assert.NoError(t, err, "range query should not throw error")
+	assert.Equal(t, 2, countStates(iter), "first page should include pending write before the bookmark")
+	assert.Equal(t, "a3", md.Bookmark, "bookmark should be the next key of the base store")
+
+	// partial key query merges pending composite keys
+	ck2, _ := store.CreateCompositeKey("idx", []string{"x", "a2"})
+	assert.NoError(t, PutData(store, "", ck2, nil), "put composite key should not throw error")
+	assert.NoError(t, DeleteData(store, "", ck), "delete composite key should not throw error")
+	iter, _, err = GetCompositeKeys(store, "", "idx", []string{"x"}, 0, "")
+	assert.NoError(t, err, "partial key query should not throw error")
+	assert.True(t, iter.HasNext(), "partial key query should return pending key")
+	kv, _ := iter.Next()
+	assert.Equal(t, ck2, kv.Key, "partial key query should return pending key")
+	assert.False(t, iter.HasNext(), "partial key query should exclude pending delete")
+}
+
+func TestEnvelope(t *testing.T) {
+	data := map[string]interface{}{"name": "marble1", "size": float64(50), "owner": "tom"}
+
+	// plain JSON without envelope
+	f, err := NewValueFormat("", "", 0, "")
+	assert.NoError(t, err, "plain JSON format should not throw error")
+	assert.Nil(t, f, "plain JSON should not have envelope format")
+	v, err := EncodeValue(data, f)
+	assert.NoError(t, err, "encode plain JSON should not throw error")
+	assert.False(t, IsEnvelope(v), "plain JSON should not be enveloped")
+	d, f, err := DecodeValue(v)
+	assert.NoError(t, err, "decode plain JSON should not throw error")
+	assert.Nil(t, f, "plain JSON should not return format")
+	assert.Equal(t, data, d, "decoded plain JSON should match data")
+
+	// CBOR envelope
+	f, err = NewValueFormat(EncodingCBOR, "marble", 2, "")
+	assert.NoError(t, err, "CBOR format should not throw error")
+	v, err = EncodeValue(data, f)
+	assert.NoError(t, err, "encode CBOR should not throw error")
+	assert.True(t, IsEnvelope(v), "CBOR value should be enveloped")
+	d, f, err = DecodeValue(v)
+	assert.NoError(t, err, "decode CBOR should not throw error")
+	assert.Equal(t, "marble", f.Schema, "decoded schema name should be 'marble'")
+	assert.Equal(t, 2, f.Version, "decoded schema version should be 2")
+	assert.Equal(t, data, d, "decoded CBOR should match data")
+
+	// protobuf envelope
+	_, err = NewValueFormat(EncodingProtobuf, "marble", 1, "sample.Unknown")
+	assert.Error(t, err, "protobuf format should require registered message")
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	fds := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("marble.proto"),
+		Package: proto.String("sample"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Marble"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("name"), Number: proto.Int32(1), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+				{Name: proto.String("size"), Number: proto.Int32(2), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()},
+				{Name: proto.String("owner"), Number: proto.Int32(3), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+			},
+		}},
+	}}}
+	fdsBytes, err := proto.Marshal(fds)
+	assert.NoError(t, err, "marshal descriptor set should not throw error")
+	assert.NoError(t, LoadProtoDescriptor(base64.StdEncoding.EncodeToString(fdsBytes)), "register descriptor should not throw error")
+	f, err = NewValueFormat(EncodingProtobuf, "marble", 1, "sample.Marble")
+	assert.NoError(t, err, "protobuf format should not throw error")
+	v, err = EncodeValue(data, f)
+	assert.NoError(t, err, "encode protobuf should not throw error")
+	d, f, err = DecodeValue(v)
+	assert.NoError(t, err, "decode protobuf should not throw error")
+	assert.Equal(t, "sample.Marble", f.Message, "decoded message should be 'sample.Marble'")
+	assert.Equal(t, data, d, "decoded protobuf should match data")
+	_, err = EncodeValue(map[string]interface{}{"color": "red"}, f)
+	assert.Error(t, err, "encode protobuf should reject unknown field")
+
+	// redact enveloped value
+	r := NewRedactor("owner")
+	assert.Equal(t, `{"name":"marble1","owner":"***","size":50}`, r.RedactJSON(v), "enveloped value should be decoded and redacted")
+}
+
+func TestEnvelopeDeterminism(t *testing.T) {
+	data := map[string]interface{}{}
+	counts := map[string]interface{}{}
+	for i := 0; i < 20; i++ {
+		data[fmt.Sprintf("field%d", i)] = float64(i)
+		counts[fmt.Sprintf("color%d", i)] = float64(i)
+	}
+
+	// CBOR envelope of a map of many keys
+	f, err := NewValueFormat(EncodingCBOR, "marble", 1, "")
+	assert.NoError(t, err, "CBOR format should not throw error")
+	expected, err := EncodeValue(data, f)
+	assert.NoError(t, err, "encode CBOR should not throw error")
+	for i := 0; i < 50; i++ {
+		v, _ := EncodeValue(data, f)
+		assert.True(t, bytes.Equal(expected, v), "CBOR encoding should be deterministic")
+	}
+
+	// protobuf envelope of a message with map field
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	fds := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("inventory.proto"),
+		Package: proto.String("sample"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Inventory"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name: proto.String("counts"), Number: proto.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+				Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".sample.Inventory.CountsEntry"),
+			}},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name:    proto.String("CountsEntry"),
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("key"), Number: proto.Int32(1), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+					{Name: proto.String("value"), Number: proto.Int32(2), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()},
+				},
+			}},
+		}},
+	}}}
+	fdsBytes, err := proto.Marshal(fds)
+	assert.NoError(t, err, "marshal descriptor set should not throw error")
+	assert.NoError(t, RegisterProtoDescriptor(fdsBytes), "register descriptor should not throw error")
+	f, err = NewValueFormat(EncodingProtobuf, "inventory", 1, "sample.Inventory")
+	assert.NoError(t, err, "protobuf format should not throw error")
+	inventory := map[string]interface{}{"counts": counts}
+	expected, err = EncodeValue(inventory, f)
+	assert.NoError(t, err, "encode protobuf should not throw error")
+	for i := 0; i < 50; i++ {
+		v, _ := EncodeValue(inventory, f)
+		assert.True(t, bytes.Equal(expected, v), "protobuf encoding should be deterministic")
+	}
+	d, _, err := DecodeValue(expected)
+	assert.NoError(t, err, "decode protobuf should not throw error")
+	assert.Equal(t, inventory, d, "decoded protobuf map should match data")
+}
+
+func TestMigration(t *testing.T) {
+	err := LoadMigrationRules(`[
+		{"schema": "widget", "version": 1, "transform": [
+			{"op": "move", "from": "colour", "path": "color"},
+			{"op": "default", "path": "size", "value": 10}
+		]},
+		{"schema": "widget", "version": 2, "transform": [
+			{"op": "move", "from": "$.owner", "path": "owner.name"},
+			{"op": "remove", "path": "legacy"}
+		]}
+	]`)
+	assert.NoError(t, err, "load migration rules should not throw error")
+	assert.Equal(t, 3, LatestSchemaVersion("widget"), "latest version of widget should be 3")
+	assert.Error(t, RegisterMigrationRules([]*MigrationRule{{Schema: "widget", Version: 3, Transform: []*TransformOp{{Op: "rename", Path: "a"}}}}), "unsupported transform should throw error")
+
+	data := map[string]interface{}{"name": "w1", "colour": "red", "owner": "tom", "legacy": true}
+	d, f, err := MigrateValue(context.Background(), data, &ValueFormat{Schema: "widget", Version: 1, Encoding: EncodingJSON}, 0)
+	assert.NoError(t, err, "migrate widget should not throw error")
+	assert.Equal(t, 3, f.Version, "migrated widget should be version 3")
+	expected := map[string]interface{}{"name": "w1", "color": "red", "size": float64(10), "owner": map[string]interface{}{"name": "tom"}}
+	assert.Equal(t, expected, d, "migrated widget should match version 3")
+	assert.Equal(t, "red", data["colour"], "original data should not be changed")
+
+	// migrate to a specified version
+	d, f, err = MigrateValue(context.Background(), data, &ValueFormat{Schema: "widget", Version: 1, Encoding: EncodingJSON}, 2)
+	assert.NoError(t, err, "migrate widget to version 2 should not throw error")
+	assert.Equal(t, 2, f.Version, "migrated widget should be version 2")
+	assert.Equal(t, "tom", d.(map[string]interface{})["owner"], "version 2 should not change owner")
+
+	// no upgrade of latest version or plain JSON
+	current := &ValueFormat{Schema: "widget", Version: 3, Encoding: EncodingJSON}
+	_, f, err = MigrateValue(context.Background(), expected, current, 0)
+	assert.NoError(t, err, "latest version should not throw error")
+	assert.True(t, f == current, "latest version should return original format")
+	_, f, err = MigrateValue(context.Background(), data, nil, 0)
+	assert.NoError(t, err, "plain JSON should not throw error")
+	assert.Nil(t, f, "plain JSON should not be upgraded")
+	_, _, err = MigrateValue(context.Background(), expected, current, 4)
+	assert.Error(t, err, "missing migration rule should throw error")
+}
+
+func TestTypedCompositeKeys(t *testing.T) {
+	path, attrType := ParseKeyAttribute("$.size:int")
+	assert.Equal(t, "$.size", path, "path of typed attribute should be '$.size'")
+	assert.Equal(t, AttrInt, attrType, "type of attribute should be int")
+	_, attrType = ParseKeyAttribute("$.name")
+	assert.Equal(t, AttrString, attrType, "default attribute type should be string")
+
+	// encoded values sort in natural order
+	for attrType, values := range map[string][]interface{}{
+		AttrInt:     {float64(-20), -3, "0", float64(9), 10, "1000000"},
+		AttrDecimal: {-1e10, -2.5, float64(0), 0.001, 9.75, 10, 1e21},
+		AttrDate:    {"1999-12-31", "2021-03-01T08:00:00+08:00", "2021-03-01T01:00:00Z", "2021-03-01T01:00:00.5Z"},
+	} {
+		prev := ""
+		for _, v := range values {
+			ev, err := EncodeKeyAttribute(v, attrType)
+			assert.NoError(t, err, "encode %s value %v should not throw error", attrType, v)
+			assert.True(t, ev > prev, "encoded %s value %v should sort after the previous value", attrType, v)
+			prev = ev
+		}
+	}
+	_, err := EncodeKeyAttribute(2.5, AttrInt)
+	assert.Error(t, err, "fraction should not be encoded as int")
+
+	// decode typed attributes of split composite key
+	assert.NoError(t, RegisterCompositeKeys(map[string][]string{"size~date": {"$.docType", "$.size:int", "$.created:date"}}), "register composite keys should not throw error")
+	ss := NewMemoryStore()
+	data := map[string]interface{}{"docType": "marble", "size": float64(-42), "created": "2021-03-01T08:00:00+08:00"}
+	key, complete := MakeCompositeKey(ss, "size~date", []string{"$.docType", "$.size:int", "$.created:date"}, "marble1", data)
+	assert.True(t, complete, "composite key should be complete")
+	ck, err := SplitCompositeKey(ss, key)
+	assert.NoError(t, err, "split composite key should not throw error")
+	assert.Equal(t, []string{"marble", "-42", "2021-03-01T00:00:00Z", "marble1"}, ck.Fields, "typed attributes should be decoded")
+	assert.Equal(t, "marble1", ck.Key, "state key should be 'marble1'")
+
+	// typed attribute of the state key is not appended again
+	assert.NoError(t, RegisterCompositeKeys(map[string][]string{"type~id": {"$.docType", "$.id:int"}}), "register composite keys should not throw error")
+	data = map[string]interface{}{"docType": "marble", "id": float64(7)}
+	key, complete = MakeCompositeKey(ss, "type~id", []string{"$.docType", "$.id:int"}, "7", data)
+	assert.True(t, complete, "composite key of typed state key should be complete")
+	_, fields, err := ss.SplitCompositeKey(key)
+	assert.NoError(t, err, "split composite key should not throw error")
+	assert.Equal(t, 2, len(fields), "typed state key should not be appended again")
+	ck, err = SplitCompositeKey(ss, key)
+	assert.NoError(t, err, "split composite key should not throw error")
+	assert.Equal(t, "7", ck.Key, "typed state key should be decoded")
+
+	// state key that differs from the decoded attribute is appended
+	key, _ = MakeCompositeKey(ss, "type~id", []string{"$.docType", "$.id:int"}, "007", data)
+	ck, err = SplitCompositeKey(ss, key)
+	assert.NoError(t, err, "split composite key should not throw error")
+	assert.Equal(t, []string{"marble", "7", "007"}, ck.Fields, "state key should be appended after the typed attribute")
+	assert.Equal(t, "007", ck.Key, "state key should be '007'")
+
+	// state key is appended as is in the slot of a missing typed attribute, and it is not decoded by the type
+	for _, k := range []string{"10", "007", "abc"} {
+		key, complete = MakeCompositeKey(ss, "type~id", []string{"$.docType", "$.id:int"}, k, map[string]interface{}{"docType": "marble"})
+		assert.True(t, complete, "composite key with state key should be complete")
+		ck, err = SplitCompositeKey(ss, key)
+		assert.NoError(t, err, "split composite key should not throw error")
+		assert.Equal(t, []string{"marble", k}, ck.Fields, "state key should not be decoded")
+		assert.Equal(t, k, ck.Key, "state key should round-trip")
+	}
+	key, _ = MakeCompositeKey(ss, "type~id", []string{"$.docType", "$.id:int"}, "10", map[string]interface{}{"docType": "marble", "id": float64(10)})
+	ck, err = SplitCompositeKey(ss, key)
+	assert.NoError(t, err, "split composite key should not throw error")
+	assert.Equal(t, "10", ck.Key, "state key should be the same with or without the typed attribute")
+
+	// typed state key of date and decimal types
+	assert.NoError(t, RegisterCompositeKeys(map[string][]string{"type~created": {"$.docType", "$.created:date"}, "type~price": {"$.docType", "$.price:decimal"}}), "register composite keys should not throw error")
+	data = map[string]interface{}{"docType": "marble", "created": "2021-03-01T01:00:00.5Z", "price": 9.75}
+	key, _ = MakeCompositeKey(ss, "type~created", []string{"$.docType", "$.created:date"}, "2021-03-01T01:00:00.5Z", data)
+	ck, err = SplitCompositeKey(ss, key)
+	assert.NoError(t, err, "split composite key should not throw error")
+	assert.Equal(t, "2021-03-01T01:00:00.5Z", ck.Key, "typed date state key should be decoded")
+	key, _ = MakeCompositeKey(ss, "type~price", []string{"$.docType", "$.price:decimal"}, "9.75", data)
+	ck, err = SplitCompositeKey(ss, key)
+	assert.NoError(t, err, "split composite key should not throw error")
+	assert.Equal(t, "9.75", ck.Key, "typed decimal state key should be decoded")
+
+	// conflicting definitions of the same key are rejected
+	assert.NoError(t, RegisterCompositeKeys(map[string][]string{"type~id": {"$.docType"}}), "leading attributes of a key should be accepted")
+	assert.Error(t, RegisterCompositeKeys(map[string][]string{"type~id": {"$.docType", "$.id:date"}}), "different attribute types should throw error")
+	assert.Equal(t, []string{AttrString, AttrInt}, keyAttributeTypes("type~id"), "registered types should not be changed")
+	assert.NoError(t, RegisterUniqueKeys(map[string][]string{"type~id": {"$.docType", "$.id:int"}}), "register unique key should not throw error")
+	assert.Error(t, RegisterUniqueKeys(map[string][]string{"type~id": {"$.docType", "$.uid:int"}}), "different unique key attributes should throw error")
+	assert.NoError(t, RegisterSchemaKeys("marble", map[string][]string{"type~id": {"$.docType", "$.id:int"}}, nil), "register schema keys should not throw error")
+	assert.Error(t, RegisterSchemaKeys("marble", map[string][]string{"type~id": {"$.docType", "$.uid:int"}}, nil), "different schema key attributes should throw error")
+
+	assert.False(t, IsCompositeKey(""), "empty key should not be a composite key")
+	_, err = SplitCompositeKey(ss, "")
+	assert.Error(t, err, "empty key should not be split")
+}
+
+func TestFieldCipher(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	c, err := NewFieldCipher("$.owner.ssn, accounts.number", key)
+	assert.NoError(t, err, "create field cipher should not throw error")
+	assert.True(t, c.HasKey(), "field cipher should have key")
+	_, err = NewFieldCipher("ssn", base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err, "short key should throw error")
+
+	data := map[string]interface{}{
+		"name":     "tom",
+		"owner":    map[string]interface{}{"ssn": "123-45-6789", "age": float64(40)},
+		"accounts": []interface{}{map[string]interface{}{"number": float64(1001)}},
+	}
+	enc, err := c.Encrypt("user1", data)
+	assert.NoError(t, err, "encrypt fields should not throw error")
+	ssn := enc.(map[string]interface{})["owner"].(map[string]interface{})["ssn"].(string)
+	assert.True(t, strings.HasPrefix(ssn, EncryptedPrefix), "ssn should be encrypted")
+	assert.Equal(t, "123-45-6789", data["owner"].(map[string]interface{})["ssn"], "original data should not be changed")
+
+	// decrypt with key, or mask without key
+	assert.Equal(t, data, c.Decrypt("user1", enc), "decrypted data should match original data")
+	masked := c.Decrypt("user2", enc).(map[string]interface{})
+	assert.Equal(t, Redacted, masked["owner"].(map[string]interface{})["ssn"], "value of another state key should not be decrypted")
+	noKey, err := NewFieldCipher("$.owner.ssn, accounts.number", "")
+	assert.NoError(t, err, "create field cipher without key should not throw error")
+	masked = noKey.Decrypt("user1", enc).(map[string]interface{})
+	assert.Equal(t, Redacted, masked["owner"].(map[string]interface{})["ssn"], "ssn should be masked without key")
+	assert.Equal(t, float64(40), masked["owner"].(map[string]interface{})["age"], "age should not be masked")
+	_, err = noKey.Encrypt("user1", data)
+	assert.Error(t, err, "encrypt without key should throw error")
+	_, err = c.Encrypt("user1", masked)
+	assert.Error(t, err, "masked value should not be encrypted")
+
+	// endorsing peers must encrypt the same data of a transaction to the same bytes
+	tc := c.WithTxID("tx1")
+	enc1, err := tc.Encrypt("user1", data)
+	assert.NoError(t, err, "encrypt fields should not throw error")
+	enc2, err := tc.Encrypt("user1", data)
+	assert.NoError(t, err, "encrypt fields should not throw error")
+	b1, _ := json.Marshal(enc1)
+	b2, _ := json.Marshal(enc2)
+	assert.Equal(t, string(b1), string(b2), "same data should be encrypted to the same bytes in a transaction")
+	enc2, err = c.WithTxID("tx2").Encrypt("user1", data)
+	assert.NoError(t, err, "encrypt fields should not throw error")
+	b2, _ = json.Marshal(enc2)
+	assert.NotEqual(t, string(b1), string(b2), "nonce should differ in another transaction")
+	assert.Equal(t, data, c.Decrypt("user1", enc2), "decrypted data should match original data")
+
+	// encrypted values are bound to array elements, and cannot be stored again
+	items := map[string]interface{}{"accounts": []interface{}{
+		map[string]interface{}{"number": float64(1001)},
+		map[string]interface{}{"number": float64(1002)},
+	}}
+	enc, err = c.Encrypt("user1", items)
+	assert.NoError(t, err, "encrypt array fields should not throw error")
+	accounts := enc.(map[string]interface{})["accounts"].([]interface{})
+	assert.Equal(t, items, c.Decrypt("user1", enc), "decrypted array should match original data")
+	accounts[0], accounts[1] = accounts[1], accounts[0]
+	swapped := c.Decrypt("user1", enc).(map[string]interface{})["accounts"].([]interface{})
+	assert.Equal(t, Redacted, swapped[0].(map[string]interface{})["number"], "swapped array element should not be decrypted")
+	_, err = c.Encrypt("user1", enc)
+	assert.Error(t, err, "encrypted value should not be stored again")
+
+	// composite keys should not contain encrypted fields
+	assert.True(t, c.Covers("$.owner.ssn"), "ssn should be encrypted")
+	assert.True(t, c.Covers("owner"), "owner contains encrypted ssn")
+	assert.True(t, c.Covers("$.accounts[*].number"), "account number should be encrypted")
+	assert.False(t, c.Covers("$.owner.age"), "age should not be encrypted")
+	assert.False(t, c.Covers("name"), "name should not be encrypted")
+	wc, _ := NewFieldCipher("$.*.ssn", "")
+	assert.True(t, wc.Covers("$.owner.ssn"), "wildcard should match any field")
+}
+
+func TestCollectionAlias(t *testing.T) {
+	def := `{"mychannel": "_implicit_org_self", "*": "marbles"}`
+	name, err := aliasCollection(def, "mychannel")
+	assert.NoError(t, err, "alias of mychannel should be valid")
+	assert.Equal(t, "_implicit_org_self", name, "alias of mychannel should be the peer's implicit collection")
+	name, err = aliasCollection(def, "otherchannel")
+	assert.NoError(t, err, "alias of otherchannel should be valid")
+	assert.Equal(t, "marbles", name, "alias of other channels should be the default collection")
+	name, err = aliasCollection(" marbles ", "mychannel")
+	assert.NoError(t, err, "alias of a collection name should be valid")
+	assert.Equal(t, "marbles", name, "alias should be the same collection for all channels")
+
+	name, err = aliasCollection(map[string]interface{}{"mychannel": "marbles"}, "otherchannel")
+	assert.NoError(t, err, "alias without default should be valid")
+	assert.Equal(t, "", name, "alias should not be defined for otherchannel")
+	_, err = aliasCollection(map[string]interface{}{"*": 1}, "mychannel")
+	assert.Error(t, err, "collection of alias must be a string")
+}
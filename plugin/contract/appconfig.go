@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -159,19 +160,22 @@ func addActivityFEMetadata(activity map[string]interface{}, key, feMetadata stri
 	}
 }
 
-// ToAppConfig converts the first contract in a contract spec to a Flogo App Config
+// ToAppConfig converts contracts in a contract spec to a Flogo App Config,
+// which contains one trigger per contract. Triggers and flows are namespaced by contract names
+// if the spec contains more than one contract.
 func (s *Spec) ToAppConfig(fe bool) (*app.Config, error) {
 	if len(s.Contracts) == 0 {
 		return nil, errors.New("No contract is defined in the spec")
 	}
 
-	var name string
-	var con *Contract
-	for k, v := range s.Contracts {
-		name = k
-		con = v
-		break
+	var names []string
+	for k := range s.Contracts {
+		names = append(names, k)
 	}
+	sort.Strings(names)
+	name := s.defaultContract(names)
+	con := s.Contracts[name]
+
 	ac := &app.Config{
 		Name:        name,
 		Type:        "flogo:app",
@@ -180,32 +184,43 @@ func (s *Spec) ToAppConfig(fe bool) (*app.Config, error) {
 		AppModel:    "1.1.1",
 		Imports:     s.Imports,
 	}
-	if fe {
-		// convert and cache app schemas for Flogo Enterprise
-		if err := s.ConvertAppSchemas(); err != nil {
-			fmt.Printf("failed to convert app schema: %v\n", err)
-		}
-	}
-
-	// create one trigger with one handler per transaction
-	trig, err := con.ToTrigger(fe)
-	if err != nil {
-		return nil, err
+	// convert and cache app schemas for Flogo Enterprise and for validation of strict transactions
+	if err := s.ConvertAppSchemas(); err != nil {
+		fmt.Printf("failed to convert app schema: %v\n", err)
 	}
-	ac.Triggers = []*trigger.Config{trig}
 
-	// create a flow resource per transaction
 	resources := make(map[string]*definition.DefinitionRep)
-	for _, tx := range con.Transactions {
-		var schm *trigger.SchemaConfig
-		if fe {
-			schm = handlerSchema(trig, tx.Name)
+	for _, k := range names {
+		con := s.Contracts[k]
+		if len(names) > 1 {
+			// route transactions by contract name
+			con.namespace = k
+			con.Default = k == name
 		}
-		id, res, err := tx.ToResource(schm, con.CID)
+
+		// create one trigger per contract with one handler per transaction
+		trig, err := con.ToTrigger(fe)
 		if err != nil {
 			return nil, err
 		}
-		resources[id] = res
+		if _, ok := trig.Settings["version"]; !ok && s.Info != nil && len(s.Info.Version) > 0 {
+			// use spec version for contract metadata if contract version is not specified
+			trig.Settings["version"] = s.Info.Version
+		}
+		ac.Triggers = append(ac.Triggers, trig)
+
+		// create a flow resource per transaction
+		for _, tx := range con.Transactions {
+			var schm *trigger.SchemaConfig
+			if fe {
+				schm = handlerSchema(trig, tx.Name)
+			}
+			id, res, err := tx.ToResource(schm, con.CID)
+			if err != nil {
+				return nil, err
+			}
+			resources[id] = res
+		}
 	}
 
 	if fe {
@@ -222,6 +237,16 @@ func (s *Spec) ToAppConfig(fe bool) (*app.Config, error) {
 	return ac, nil
 }
 
+// returns name of the contract marked as default, or the first contract name
+func (s *Spec) defaultContract(names []string) string {
+	for _, k := range names {
+		if s.Contracts[k].Default {
+			return k
+		}
+	}
+	return names[0]
+}
+
 // SetAppResources serialize flow resources and add them to app config
 func SetAppResources(config *app.Config, resources map[string]*definition.DefinitionRep) {
 	config.Resources = make([]*resource.Config, 0)
@@ -256,7 +281,32 @@ func (c *Contract) ToTrigger(fe bool) (*trigger.Config, error) {
 	if len(c.CID) > 0 {
 		trig.Settings["cid"] = c.CID
 	}
+	if c.CIDAllAttrs {
+		trig.Settings["cidAllAttrs"] = true
+	}
+	if len(c.IdempotencyTTL) > 0 {
+		trig.Settings["idempotencyTTL"] = c.IdempotencyTTL
+	}
+	if len(c.Name) > 0 {
+		trig.Settings["title"] = c.Name
+	}
+	if c.Info != nil && len(c.Info.Version) > 0 {
+		trig.Settings["version"] = c.Info.Version
+	}
+	if len(c.namespace) > 0 {
+		// the default contract keeps the trigger id used as shim by the build script
+		trig.Settings["contract"] = c.namespace
+		if c.Default {
+			trig.Settings["default"] = true
+		} else {
+			trig.Id = "fabric_transaction_" + ToSnakeCase(c.namespace)
+		}
+	}
+	if c.NamedArgs {
+		trig.Settings["namedArgs"] = true
+	}
 	for _, tx := range c.Transactions {
+		tx.namespace = c.namespace
 		handler, err := tx.ToHandler(fe)
 		if err != nil {
 			return nil, err
@@ -280,14 +330,63 @@ func (tx *Transaction) ToHandler(fe bool) (*trigger.HandlerConfig, error) {
 		"name":       tx.Name,
 		"parameters": param,
 	}
+	if len(tx.Transient) > 0 {
+		handler.Settings["transient"] = tx.TransientDef()
+	}
+	if defaults := tx.ParameterDefaults(); len(defaults) > 0 {
+		handler.Settings["defaults"] = defaults
+	}
+	if tx.Init {
+		handler.Settings["init"] = true
+	}
+	if tx.IsReadOnly() {
+		handler.Settings["readOnly"] = true
+	}
+	if tx.AccessControl != nil {
+		handler.Settings["accessControl"] = tx.AccessControl
+	}
+	if len(tx.IdempotencyKey) > 0 {
+		handler.Settings["idempotencyKey"] = tx.IdempotencyKey
+	}
+	if len(tx.Pattern) > 0 {
+		handler.Settings["pattern"] = tx.Pattern
+	}
+	if sensitive := tx.SensitiveDef(); len(sensitive) > 0 {
+		handler.Settings["sensitive"] = sensitive
+	}
+	// set JSON schemas for contract metadata, and for validating requests of strict transactions
+	params, trans, err := tx.ValidationSchemas()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to convert validation schema for transaction %s", tx.Name)
+	}
+	if len(params) > 0 {
+		handler.Settings["parameterSchema"] = params
+	}
+	if tx.Strict {
+		handler.Settings["strict"] = true
+		if len(trans) > 0 {
+			handler.Settings["transientSchema"] = trans
+		}
+	}
+	returns, err := tx.ReturnSchema()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to convert return schema for transaction %s", tx.Name)
+	}
+	if len(returns) > 0 {
+		handler.Settings["returnSchema"] = returns
+	}
 
 	// generate flow action
-	res := "res://flow:" + ToSnakeCase(tx.Name)
+	res := "res://" + tx.flowID()
 	// map all parameters as a single object
 	input := map[string]interface{}{
 		"parameters": "=$.parameters",
 		"transient":  "=$.transient",
 	}
+	if len(tx.Pattern) > 0 {
+		// pass the matched transaction name to the flow
+		input["transaction"] = "=$.transaction"
+	}
 	output := map[string]interface{}{
 		"message": "=$.message",
 		"returns": "=$.returns",
@@ -312,6 +411,14 @@ func (tx *Transaction) ToHandler(fe bool) (*trigger.HandlerConfig, error) {
 	return handler, nil
 }
 
+// returns flow resource ID of a transaction, which is prefixed by contract namespace if it is set
+func (tx *Transaction) flowID() string {
+	if len(tx.namespace) > 0 {
+		return "flow:" + ToSnakeCase(tx.namespace) + "_" + ToSnakeCase(tx.Name)
+	}
+	return "flow:" + ToSnakeCase(tx.Name)
+}
+
 var matchFirstCap = regexp.MustCompile("([A-Z])([A-Z][a-z])")
 var matchAllCap = regexp.MustCompile("([a-z0-9])([A-Z])")
 
@@ -560,7 +667,7 @@ func nextActivityID(ref string) string {
 
 // ToResource converts a contract transaction to flow resource definition
 func (tx *Transaction) ToResource(schm *trigger.SchemaConfig, cid string) (string, *definition.DefinitionRep, error) {
-	id := "flow:" + ToSnakeCase(tx.Name)
+	id := tx.flowID()
 
 	input := make(map[string]data.TypedValue)
 	if len(tx.Parameters) > 0 {
@@ -569,6 +676,9 @@ func (tx *Transaction) ToResource(schm *trigger.SchemaConfig, cid string) (strin
 	if len(tx.Transient) > 0 {
 		input["transient"] = data.NewAttribute("transient", data.TypeObject, nil)
 	}
+	if len(tx.Pattern) > 0 {
+		input["transaction"] = data.NewAttribute("transaction", data.TypeString, "")
+	}
 	rAttr := data.NewAttribute("returns", data.TypeAny, nil)
 	includeSchema := false
 	if schm != nil {
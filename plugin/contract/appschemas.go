@@ -176,13 +176,92 @@ func (tx *Transaction) ToHandlerSchema() (*trigger.SchemaConfig, error) {
 // ParametersToSchema convert transaction parameters to schema def
 func ParametersToSchema(params []*Parameter) map[string]interface{} {
 	props := make(map[string]interface{})
+	var required []string
 	for _, p := range params {
 		props[p.Name] = p.Schema
+		if p.Required {
+			required = append(required, p.Name)
+		}
 	}
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"type":       jschema.TYPE_OBJECT,
 		"properties": props,
 	}
+	if len(required) > 0 {
+		result["required"] = required
+	}
+	return result
+}
+
+// ValidationSchemas returns JSON schemas of transaction parameters and transient attributes with all refs expanded,
+// which are used by strict transaction handlers to validate transaction requests
+func (tx *Transaction) ValidationSchemas() (string, string, error) {
+	var params, trans string
+	if len(tx.Parameters) > 0 {
+		ps, err := copySchema(ParametersToSchema(tx.Parameters))
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := ExpandRef(ps); err != nil {
+			return "", "", err
+		}
+		pbytes, err := json.Marshal(ps)
+		if err != nil {
+			return "", "", err
+		}
+		params = string(pbytes)
+	}
+	if len(tx.Transient) > 0 {
+		ts, err := copySchema(map[string]interface{}{
+			"type":       jschema.TYPE_OBJECT,
+			"properties": tx.Transient,
+		})
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := ExpandRef(ts); err != nil {
+			return "", "", err
+		}
+		tbytes, err := json.Marshal(ts)
+		if err != nil {
+			return "", "", err
+		}
+		trans = string(tbytes)
+	}
+	return params, trans, nil
+}
+
+// ReturnSchema returns JSON schema of the transaction result with all refs expanded,
+// which is reported by the contract metadata of the chaincode
+func (tx *Transaction) ReturnSchema() (string, error) {
+	if len(tx.Returns) == 0 {
+		return "", nil
+	}
+	rs, err := copySchema(map[string]interface{}{"returns": tx.Returns})
+	if err != nil {
+		return "", err
+	}
+	if _, err := ExpandRef(rs); err != nil {
+		return "", err
+	}
+	rbytes, err := json.Marshal(rs["returns"])
+	if err != nil {
+		return "", err
+	}
+	return string(rbytes), nil
+}
+
+// copySchema returns a deep copy of a schema def, so expanding its refs does not change the contract spec
+func copySchema(def map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 // FlowSchema implements schema.Schema, used for flow metadata
@@ -222,8 +301,9 @@ func (f *FlowSchema) MarshalJSON() ([]byte, error) {
 
 // ExtractFlowSchema create serializable schema for a flow for a given schema def
 // to work around FE import issue, the schema def is changed as follows:
-//   for object, export only properties of the object
-//   for array, create app schema, and export a ref
+//
+//	for object, export only properties of the object
+//	for array, create app schema, and export a ref
 func ExtractFlowSchema(schemadef interface{}) schema.Schema {
 	var def *schema.Def
 	switch d := schemadef.(type) {
@@ -272,9 +352,9 @@ func ExtractFlowSchema(schemadef interface{}) schema.Schema {
 	}
 }
 
-// JSON schema for CID attributes include standard id, mspid, cn, and extra attributes in comma-delimited cid config
+// JSON schema for CID attributes include standard identity fields of client certificate, and extra attributes in comma-delimited cid config
 func cidSchema(cid string) schema.Schema {
-	attrs := []string{"id", "mspid", "cn"}
+	attrs := []string{"id", "mspid", "cn", "ou", "nodeRole", "issuer", "serial", "notBefore", "notAfter"}
 	if len(cid) > 0 {
 		extra := strings.Split(cid, ",")
 		attrs = append(attrs, extra...)
@@ -286,6 +366,10 @@ func cidSchema(cid string) schema.Schema {
 		fmt.Fprintf(&buff, `%s"%s":{"type":"%s"}`, delimiter, v, jschema.TYPE_STRING)
 		delimiter = ","
 	}
+	for _, v := range []string{"ous", "dnsNames", "emails", "ips", "uris"} {
+		fmt.Fprintf(&buff, `,"%s":{"type":"%s","items":{"type":"%s"}}`, v, jschema.TYPE_ARRAY, jschema.TYPE_STRING)
+	}
+	fmt.Fprintf(&buff, `,"attrs":{"type":"%s","additionalProperties":{"type":"%s"}}`, jschema.TYPE_OBJECT, jschema.TYPE_STRING)
 	buff.WriteString("}")
 	return &FlowSchema{
 		SchemaType:  "json",
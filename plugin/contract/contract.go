@@ -8,6 +8,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	jschema "github.com/xeipuuv/gojsonschema"
@@ -30,20 +32,39 @@ type Info struct {
 
 // Contract defines a smart contract
 type Contract struct {
-	Name         string         `json:"name"`
-	CID          string         `json:"cid"`
-	Transactions []*Transaction `json:"transactions"`
-	Info         *Info          `json:"info,omitempty"`
+	Name           string         `json:"name"`
+	CID            string         `json:"cid"`
+	CIDAllAttrs    bool           `json:"cidAllAttrs,omitempty"`
+	NamedArgs      bool           `json:"namedArgs,omitempty"`
+	Default        bool           `json:"default,omitempty"`
+	IdempotencyTTL string         `json:"idempotencyTTL,omitempty"`
+	Transactions   []*Transaction `json:"transactions"`
+	Info           *Info          `json:"info,omitempty"`
+	namespace      string
 }
 
 // Transaction defines a transaction in a contract
 type Transaction struct {
-	Name       string                 `json:"name"`
-	Tag        []string               `json:"tag,omitempty"`
-	Parameters []*Parameter           `json:"parameters"`
-	Transient  map[string]interface{} `json:"transient"`
-	Returns    map[string]interface{} `json:"returns"`
-	Rules      []*Rule                `json:"rules"`
+	Name           string                 `json:"name"`
+	Tag            []string               `json:"tag,omitempty"`
+	Parameters     []*Parameter           `json:"parameters"`
+	Transient      map[string]interface{} `json:"transient"`
+	Returns        map[string]interface{} `json:"returns"`
+	Rules          []*Rule                `json:"rules"`
+	Strict         bool                   `json:"strict,omitempty"`
+	Init           bool                   `json:"init,omitempty"`
+	AccessControl  *AccessControl         `json:"accessControl,omitempty"`
+	IdempotencyKey string                 `json:"idempotencyKey,omitempty"`
+	Pattern        string                 `json:"pattern,omitempty"`
+	Sensitive      []string               `json:"sensitive,omitempty"`
+	namespace      string
+}
+
+// AccessControl defines clients allowed to invoke a transaction
+type AccessControl struct {
+	MSPIDs     []string            `json:"mspids,omitempty"`
+	OUs        []string            `json:"ous,omitempty"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
 }
 
 // Parameter defines a parameter of transaction
@@ -52,6 +73,7 @@ type Parameter struct {
 	Schema      map[string]interface{} `json:"schema"`
 	Description string                 `json:"description,omitempty"`
 	Required    bool                   `json:"required,omitempty"`
+	Sensitive   bool                   `json:"sensitive,omitempty"`
 }
 
 // Rule defines condition and actions for processing a transaction
@@ -127,6 +149,56 @@ func (tx *Transaction) ParameterDef() (string, error) {
 	return args.String(), nil
 }
 
+// ParameterDefaults returns default values of transaction parameters declared by the parameter schema
+func (tx *Transaction) ParameterDefaults() map[string]interface{} {
+	defaults := make(map[string]interface{})
+	for _, p := range tx.Parameters {
+		if v, ok := p.Schema["default"]; ok {
+			defaults[p.Name] = v
+		}
+	}
+	return defaults
+}
+
+// TransientDef returns comma-delimited string of transient attributes and their declared data types
+func (tx *Transaction) TransientDef() string {
+	var names []string
+	for k := range tx.Transient {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var attrs bytes.Buffer
+	delimiter := ""
+	for _, k := range names {
+		attrs.WriteString(delimiter + k + ":" + transientType(tx.Transient[k]))
+		delimiter = ","
+	}
+	return attrs.String()
+}
+
+// SensitiveDef returns comma-delimited names of sensitive parameters, and sensitive transient keys and JSON paths of the transaction
+func (tx *Transaction) SensitiveDef() string {
+	var paths []string
+	for _, p := range tx.Parameters {
+		if p.Sensitive {
+			paths = append(paths, p.Name)
+		}
+	}
+	paths = append(paths, tx.Sensitive...)
+	return strings.Join(paths, ",")
+}
+
+// IsReadOnly returns true if the transaction is tagged as 'evaluate', i.e., a query that does not update the ledger
+func (tx *Transaction) IsReadOnly() bool {
+	for _, t := range tx.Tag {
+		if strings.EqualFold(t, "evaluate") {
+			return true
+		}
+	}
+	return false
+}
+
 // ContainsParameter returns true if a parameter matches the specified name
 func (tx *Transaction) ContainsParameter(name string) bool {
 	for _, p := range tx.Parameters {
@@ -159,3 +231,22 @@ func parameterToAttribute(param *Parameter) (string, error) {
 		return param.Name, nil
 	}
 }
+
+// transient values are passed as raw bytes, so a string schema is declared as a plain string,
+// or as bytes if the string format indicates base64 encoded binary content.
+// all other schema types are declared as JSON documents
+func transientType(schema interface{}) string {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return "json"
+	}
+	if jsontype, ok := s["type"].(string); !ok || jsontype != jschema.TYPE_STRING {
+		return "json"
+	}
+	switch s["format"] {
+	case "byte", "binary", "base64":
+		return "bytes"
+	default:
+		return "string"
+	}
+}
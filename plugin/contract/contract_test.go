@@ -5,6 +5,7 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package contract
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 
@@ -27,4 +28,182 @@ func TestContract(t *testing.T) {
 		}
 	}
 	assert.Equal(t, 1, count, "transferMarble rules should have been tested")
+
+	tx := &Transaction{Name: "getMarble", Tag: []string{"query", "evaluate"}}
+	assert.True(t, tx.IsReadOnly(), "transaction tagged evaluate should be read-only")
+	tx.Tag = []string{"submit"}
+	assert.False(t, tx.IsReadOnly(), "transaction tagged submit should not be read-only")
+}
+
+func TestTransientDef(t *testing.T) {
+	fmt.Println("TestTransientDef")
+	tx := &Transaction{
+		Name: "test",
+		Transient: map[string]interface{}{
+			"marble": map[string]interface{}{"$ref": "#/components/schemas/marble"},
+			"secret": map[string]interface{}{"type": "string"},
+			"doc":    map[string]interface{}{"type": "string", "format": "byte"},
+		},
+	}
+	assert.Equal(t, "doc:bytes,marble:json,secret:string", tx.TransientDef())
+}
+
+func TestValidationSchemas(t *testing.T) {
+	fmt.Println("TestValidationSchemas")
+	tx := &Transaction{
+		Name: "test",
+		Parameters: []*Parameter{
+			{Name: "name", Schema: map[string]interface{}{"type": "string"}, Required: true},
+			{Name: "size", Schema: map[string]interface{}{"type": "integer"}},
+		},
+		Strict: true,
+	}
+	params, trans, err := tx.ValidationSchemas()
+	assert.NoError(t, err, "validation schemas should not throw error")
+	assert.Equal(t, `{"properties":{"name":{"type":"string"},"size":{"type":"integer"}},"required":["name"],"type":"object"}`, params)
+	assert.Equal(t, "", trans, "transient schema should be empty")
+
+	handler, err := tx.ToHandler(false)
+	assert.NoError(t, err, "convert strict transaction should not throw error")
+	assert.Equal(t, true, handler.Settings["strict"])
+	assert.Equal(t, params, handler.Settings["parameterSchema"])
+}
+
+func TestParameterDefaults(t *testing.T) {
+	fmt.Println("TestParameterDefaults")
+	tx := &Transaction{
+		Name: "test",
+		Parameters: []*Parameter{
+			{Name: "name", Schema: map[string]interface{}{"type": "string"}, Required: true},
+			{Name: "color", Schema: map[string]interface{}{"type": "string", "default": "red"}},
+		},
+		Init: true,
+	}
+	defaults := tx.ParameterDefaults()
+	assert.Equal(t, 1, len(defaults), "there should be 1 default value")
+	assert.Equal(t, "red", defaults["color"])
+
+	handler, err := tx.ToHandler(false)
+	assert.NoError(t, err, "convert transaction should not throw error")
+	assert.Equal(t, defaults, handler.Settings["defaults"])
+	assert.Equal(t, true, handler.Settings["init"])
+}
+
+func TestMultipleContracts(t *testing.T) {
+	fmt.Println("TestMultipleContracts")
+	spec, err := ReadContract(testContract)
+	assert.NoError(t, err, "read sample contract should not throw error")
+	single, err := spec.ToAppConfig(false)
+	assert.NoError(t, err, "convert single contract should not throw error")
+
+	spec, err = ReadContract(testContract)
+	assert.NoError(t, err, "read sample contract should not throw error")
+	other, err := ReadContract(testContract)
+	assert.NoError(t, err, "read sample contract should not throw error")
+	spec.Contracts["other"] = other.Contracts["demo-contract"]
+	spec.Contracts["other"].Default = true
+
+	ac, err := spec.ToAppConfig(false)
+	assert.NoError(t, err, "convert multiple contracts should not throw error")
+	assert.Equal(t, "other", ac.Name, "app should be named by the default contract")
+	assert.Equal(t, 2, len(ac.Triggers), "app should contain a trigger per contract")
+	assert.Equal(t, 2*len(single.Resources), len(ac.Resources), "flows of both contracts should be created")
+	for _, trig := range ac.Triggers {
+		name := trig.Settings["contract"]
+		if name == "other" {
+			assert.Equal(t, true, trig.Settings["default"], "other contract should be default")
+			assert.Equal(t, "fabric_transaction", trig.Id, "default trigger should be used as shim")
+		} else {
+			assert.Nil(t, trig.Settings["default"], "only one contract should be default")
+			assert.Equal(t, "fabric_transaction_demo_contract", trig.Id, "trigger id should be namespaced")
+		}
+	}
+}
+
+func TestReturnSchema(t *testing.T) {
+	fmt.Println("TestReturnSchema")
+	spec, err := ReadContract(testContract)
+	assert.NoError(t, err, "read sample contract should not throw error")
+	err = spec.ConvertAppSchemas()
+	assert.NoError(t, err, "convert app schemas should not throw error")
+
+	for _, tx := range spec.Contracts["demo-contract"].Transactions {
+		if tx.Name != "createMarble" {
+			continue
+		}
+		returns, err := tx.ReturnSchema()
+		assert.NoError(t, err, "return schema should not throw error")
+		assert.NotContains(t, returns, "$ref", "refs of return schema should be expanded")
+		assert.Equal(t, "#/components/schemas/marbleKeyValue", tx.Returns["$ref"], "contract spec should not be changed")
+
+		handler, err := tx.ToHandler(false)
+		assert.NoError(t, err, "convert transaction should not throw error")
+		assert.Equal(t, returns, handler.Settings["returnSchema"])
+		assert.NotNil(t, handler.Settings["parameterSchema"], "parameter schema should be set for contract metadata")
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	fmt.Println("TestAccessControl")
+	data := `{"name": "transferMarble", "accessControl": {"mspids": ["Org1MSP"], "attributes": {"role": ["broker"]}}}`
+	tx := &Transaction{}
+	err := json.Unmarshal([]byte(data), tx)
+	assert.NoError(t, err, "unmarshal transaction should not throw error")
+
+	handler, err := tx.ToHandler(false)
+	assert.NoError(t, err, "convert transaction should not throw error")
+	jsonBytes, err := json.Marshal(handler.Settings["accessControl"])
+	assert.NoError(t, err, "serialize access control should not throw error")
+	assert.Equal(t, `{"mspids":["Org1MSP"],"attributes":{"role":["broker"]}}`, string(jsonBytes))
+}
+
+func TestClientIdentity(t *testing.T) {
+	fmt.Println("TestClientIdentity")
+	con := &Contract{Name: "marble", CID: "role", CIDAllAttrs: true}
+	trig, err := con.ToTrigger(false)
+	assert.NoError(t, err, "convert contract should not throw error")
+	assert.Equal(t, "role", trig.Settings["cid"])
+	assert.Equal(t, true, trig.Settings["cidAllAttrs"])
+
+	var props map[string]map[string]interface{}
+	err = json.Unmarshal([]byte(cidSchema(con.CID).Value()), &props)
+	assert.NoError(t, err, "cid schema should be valid JSON")
+	assert.Equal(t, "string", props["role"]["type"], "custom attribute should be string")
+	assert.Equal(t, "string", props["nodeRole"]["type"], "node role should be string")
+	assert.Equal(t, "array", props["ous"]["type"], "OUs should be array")
+	assert.Equal(t, "object", props["attrs"]["type"], "attrs should be object")
+}
+
+func TestIdempotency(t *testing.T) {
+	fmt.Println("TestIdempotency")
+	data := `{"name": "marble", "idempotencyTTL": "1h", "transactions": [{"name": "createMarble", "idempotencyKey": "requestId"}]}`
+	con := &Contract{}
+	err := json.Unmarshal([]byte(data), con)
+	assert.NoError(t, err, "unmarshal contract should not throw error")
+
+	trig, err := con.ToTrigger(false)
+	assert.NoError(t, err, "convert contract should not throw error")
+	assert.Equal(t, "1h", trig.Settings["idempotencyTTL"])
+	assert.Equal(t, "requestId", trig.Handlers[0].Settings["idempotencyKey"])
+}
+
+func TestPattern(t *testing.T) {
+	fmt.Println("TestPattern")
+	tx := &Transaction{Name: "versionedCreate", Pattern: `v[0-9]+\.createMarble`}
+	handler, err := tx.ToHandler(false)
+	assert.NoError(t, err, "convert transaction should not throw error")
+	assert.Equal(t, tx.Pattern, handler.Settings["pattern"])
+	assert.Equal(t, "=$.transaction", handler.Actions[0].Input["transaction"], "matched transaction name should be passed to the flow")
+}
+
+func TestSensitive(t *testing.T) {
+	fmt.Println("TestSensitive")
+	data := `{"name": "createMarble", "parameters": [{"name": "name", "schema": {"type": "string"}}, {"name": "ssn", "schema": {"type": "string"}, "sensitive": true}], "sensitive": ["secret", "owner.ssn"]}`
+	tx := &Transaction{}
+	err := json.Unmarshal([]byte(data), tx)
+	assert.NoError(t, err, "unmarshal transaction should not throw error")
+
+	handler, err := tx.ToHandler(false)
+	assert.NoError(t, err, "convert transaction should not throw error")
+	assert.Equal(t, "ssn,secret,owner.ssn", handler.Settings["sensitive"])
 }
@@ -8,11 +8,15 @@
 # build chaincode package from model.json.  package file will be in the same directory as the model.json
 # usage:
 #   ./build.sh ../samples/marble/marble.json marble_cc
+# to also build a chaincode-as-a-service package, specify the address of the chaincode server, e.g.,
+#   ./build.sh ../samples/marble/marble.json marble_cc 1.0 marble-cc:9999
+# env CCAAS_TYPE sets the builder type of the package (default ccaas),
+# and CCAAS_TLS_ROOT_CERT sets the file of root cert for peers to verify the TLS cert of the chaincode server.
 
 SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")"; echo "$(pwd)")"
 
 if [ "$#" -lt 1 ]; then
-  echo "Usage: ./build.sh model-file [chaincode-name [version]]"
+  echo "Usage: ./build.sh model-file [chaincode-name [version [ccaas-address]]]"
   exit 1
 fi
 
@@ -30,6 +34,32 @@ if [ "$#" -gt 2 ]; then
   VERSION=$3
 fi
 
+CCAAS_ADDRESS=""
+if [ "$#" -gt 3 ]; then
+  CCAAS_ADDRESS=$4
+fi
+
+# construct chaincode-as-a-service package, which connects peers to the chaincode server at CCAAS_ADDRESS.
+# the chaincode executable starts the server when env CHAINCODE_SERVER_ADDRESS and CHAINCODE_ID are set.
+function package_ccaas {
+  local pkgdir=${MODEL_DIR}/${CCNAME}/ccaas
+  local tls_required=false
+  local root_cert=""
+  if [ ! -z "${CCAAS_TLS_ROOT_CERT}" ]; then
+    tls_required=true
+    root_cert=',"root_cert":"'$(awk '{printf "%s\\n", $0}' ${CCAAS_TLS_ROOT_CERT})'"'
+  fi
+  mkdir -p ${pkgdir}
+  echo '{"address":"'${CCAAS_ADDRESS}'","dial_timeout":"10s","tls_required":'${tls_required}${root_cert}'}' > ${pkgdir}/connection.json
+  echo '{"type":"'${CCAAS_TYPE:-ccaas}'","label":"'${CCNAME}_${VERSION}'"}' > ${pkgdir}/metadata.json
+  cd ${pkgdir}
+  tar cfz code.tar.gz connection.json
+  tar cfz ${MODEL_DIR}/${CCNAME}_${VERSION}_ccaas.tar.gz metadata.json code.tar.gz
+  cp ${MODEL_DIR}/${CCNAME}/bin/${CCNAME} ${MODEL_DIR}/${CCNAME}_${VERSION}_server
+  cd ${MODEL_DIR}/${CCNAME}
+  echo "Created chaincode-as-a-service package ${MODEL_DIR}/${CCNAME}_${VERSION}_ccaas.tar.gz and server ${MODEL_DIR}/${CCNAME}_${VERSION}_server"
+}
+
 # create and build source code
 sed "s/{CCNAME}/${CCNAME}/" ${SCRIPT_DIR}/template.mod > ${MODEL_DIR}/go.mod
 cd ${MODEL_DIR}
@@ -55,6 +85,9 @@ if [ -f "bin/${CCNAME}" ]; then
   tar cfz code.tar.gz src
   tar cfz ${MODEL_DIR}/${CCNAME}_${VERSION}.tar.gz metadata.json code.tar.gz
   echo "Created chaincode package ${MODEL_DIR}/${CCNAME}_${VERSION}.tar.gz"
+  if [ ! -z "${CCAAS_ADDRESS}" ]; then
+    package_ccaas
+  fi
 else
   echo "failed to build chaincode source code"
   exit 1
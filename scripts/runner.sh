@@ -0,0 +1,62 @@
+#!/bin/bash
+#
+# Copyright (c) 2020, TIBCO Software Inc.
+# All rights reserved.
+#
+# SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+#
+# build local runner executable from model.json, which executes transactions of the model without a Fabric network.
+# the executable will be in the same directory as the model.json
+# usage:
+#   ./runner.sh ../samples/marble/marble.json marble_runner
+#   ../samples/marble/marble_runner -app ../samples/marble/marble.json -state state.json initMarble marble1 blue 35 tom
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")"; echo "$(pwd)")"
+
+if [ "$#" -lt 1 ]; then
+  echo "Usage: ./runner.sh model-file [runner-name]"
+  exit 1
+fi
+
+MODEL_DIR="$(cd "$(dirname "$1")"; echo "$(pwd)")"
+MODEL=${1##*/}
+
+if [ "$#" -gt 1 ]; then
+  NAME=$2
+else
+  NAME="${MODEL%.*}_runner"
+fi
+
+# create source code that imports all activities of the model
+sed "s/{CCNAME}/${NAME}/" ${SCRIPT_DIR}/template.mod > ${MODEL_DIR}/go.mod
+cd ${MODEL_DIR}
+flogo create --cv v1.2.0 -f ${MODEL} -m go.mod ${NAME}
+
+# replace flogo engine main by the runner
+cd ${NAME}/src
+cat << EOT > main.go
+package main
+
+import (
+	"github.com/open-dovetail/fabric-chaincode/trigger/transaction/runner"
+)
+
+func main() {
+	runner.Main()
+}
+EOT
+go mod tidy
+go build -o ../bin/${NAME}
+
+# move runner executable to the model directory, and cleanup build files
+cd ${MODEL_DIR}
+if [ -f "${NAME}/bin/${NAME}" ]; then
+  mv ${NAME}/bin/${NAME} ${NAME}.tmp
+  rm -R ${NAME}
+  rm go.mod
+  mv ${NAME}.tmp ${NAME}
+  echo "Created runner ${MODEL_DIR}/${NAME}"
+else
+  echo "failed to build runner"
+  exit 1
+fi
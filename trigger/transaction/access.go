@@ -0,0 +1,80 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// defaultSystemAccess allows only admins of any org to invoke system transactions that update the ledger
+var defaultSystemAccess = &AccessControl{OUs: []string{"admin"}}
+
+// AccessControl lists client identities allowed to invoke a transaction.
+// A client must match one of the MSP IDs if mspids is specified, one of the OUs if ous is specified,
+// and one of the values of every attribute in attributes. An attribute with no value must be present in the client identity.
+type AccessControl struct {
+	MSPIDs     []string            `json:"mspids,omitempty"`
+	OUs        []string            `json:"ous,omitempty"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+// toAccessControl converts a handler setting of JSON object or string to access control rules
+func toAccessControl(setting interface{}) (*AccessControl, error) {
+	data, err := schemaToString(setting)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	ac := &AccessControl{}
+	if err := json.Unmarshal([]byte(data), ac); err != nil {
+		return nil, errors.Wrapf(err, "invalid access control %s", data)
+	}
+	if len(ac.MSPIDs) == 0 && len(ac.OUs) == 0 && len(ac.Attributes) == 0 {
+		return nil, nil
+	}
+	return ac, nil
+}
+
+// authorize returns error if the client identity is not allowed by the access control rules
+func (ac *AccessControl) authorize(client *ClientIdentity) error {
+	if ac == nil {
+		return nil
+	}
+	if len(ac.MSPIDs) > 0 && !contains(ac.MSPIDs, client.MSPID) {
+		return errors.Errorf("client of MSP %s is not allowed", client.MSPID)
+	}
+	if len(ac.OUs) > 0 {
+		allowed := false
+		for _, ou := range client.OUs {
+			if contains(ac.OUs, ou) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return errors.Errorf("client of OU %s is not allowed", client.OU)
+		}
+	}
+	for k, values := range ac.Attributes {
+		v, ok := client.Attrs[k]
+		if !ok {
+			return errors.Errorf("client attribute %s is required", k)
+		}
+		if len(values) > 0 && !contains(values, v) {
+			return errors.Errorf("client attribute %s=%s is not allowed", k, v)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
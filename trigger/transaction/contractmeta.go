@@ -0,0 +1,154 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// metadataFn is the system function name that fabric-contract-api clients call to discover contract metadata
+const metadataFn = "org.hyperledger.fabric:GetMetadata"
+
+const metadataSchema = "https://hyperledger.github.io/fabric-chaincode-node/main/api/contract-schema.json"
+
+// ContractChaincodeMetadata describes all contracts of a chaincode in the format of fabric-contract-api
+type ContractChaincodeMetadata struct {
+	Schema     string                       `json:"$schema"`
+	Info       *InfoMetadata                `json:"info,omitempty"`
+	Contracts  map[string]*ContractMetadata `json:"contracts"`
+	Components *ComponentMetadata           `json:"components"`
+}
+
+// InfoMetadata describes title and version of a chaincode or a contract
+type InfoMetadata struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// ContractMetadata describes transactions of a contract
+type ContractMetadata struct {
+	Name         string                 `json:"name"`
+	Info         *InfoMetadata          `json:"info,omitempty"`
+	Transactions []*TransactionMetadata `json:"transactions"`
+	Default      bool                   `json:"default"`
+}
+
+// TransactionMetadata describes parameters and return value of a transaction
+type TransactionMetadata struct {
+	Name       string               `json:"name"`
+	Tag        []string             `json:"tag,omitempty"`
+	Parameters []*ParameterMetadata `json:"parameters,omitempty"`
+	Returns    interface{}          `json:"returns,omitempty"`
+}
+
+// ParameterMetadata describes a transaction parameter by its JSON schema
+type ParameterMetadata struct {
+	Name   string      `json:"name"`
+	Schema interface{} `json:"schema"`
+}
+
+// ComponentMetadata contains reusable schemas, which are not used because handler schemas are fully expanded
+type ComponentMetadata struct {
+	Schemas map[string]interface{} `json:"schemas"`
+}
+
+// contractMetadata returns metadata of all contracts hosted by the chaincode as a JSON document
+func contractMetadata() (int, []byte) {
+	md := &ContractChaincodeMetadata{
+		Schema:     metadataSchema,
+		Contracts:  make(map[string]*ContractMetadata),
+		Components: &ComponentMetadata{Schemas: map[string]interface{}{}},
+	}
+	var names []string
+	for k := range triggers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		t := triggers[k]
+		cm := t.metadata()
+		md.Contracts[cm.Name] = cm
+		if t == defaultTrigger {
+			md.Info = cm.Info
+		}
+	}
+
+	jsonBytes, err := json.Marshal(md)
+	if err != nil {
+		return 500, errorResponse(nil, 500, errors.Wrapf(err, "failed to serialize contract metadata"))
+	}
+	return 200, jsonBytes
+}
+
+// metadata describes the contract and transactions handled by the trigger
+func (t *Trigger) metadata() *ContractMetadata {
+	name := t.contract
+	if len(name) == 0 {
+		name = t.title
+	}
+	if len(name) == 0 {
+		name = t.id
+	}
+	title := t.title
+	if len(title) == 0 {
+		title = name
+	}
+	cm := &ContractMetadata{
+		Name:         name,
+		Info:         &InfoMetadata{Title: title, Version: t.version},
+		Transactions: []*TransactionMetadata{},
+		Default:      t == defaultTrigger,
+	}
+	for _, s := range t.settings {
+		if s.isPattern() {
+			// transactions matched by patterns are not reported
+			continue
+		}
+		cm.Transactions = append(cm.Transactions, transactionMetadata(s))
+	}
+	return cm
+}
+
+// transactionMetadata describes a transaction by the JSON schemas in handler settings.
+// Parameters not described by the parameter schema are reported by their declared JSON types.
+func transactionMetadata(setting *HandlerSettings) *TransactionMetadata {
+	tm := &TransactionMetadata{
+		Name: setting.Name,
+		Tag:  []string{"submit"},
+	}
+	if setting.ReadOnly {
+		tm.Tag = []string{"evaluate"}
+	}
+
+	var props map[string]interface{}
+	if len(setting.ParameterSchema) > 0 {
+		var ps map[string]interface{}
+		if err := json.Unmarshal([]byte(setting.ParameterSchema), &ps); err != nil {
+			logger.Warnf("ignore invalid parameter schema of transaction %s: %+v", setting.Name, err)
+		} else {
+			props, _ = ps["properties"].(map[string]interface{})
+		}
+	}
+	for _, attr := range setting.Arguments {
+		schema, ok := props[attr.Name]
+		if !ok {
+			schema = map[string]interface{}{"type": attr.Type}
+		}
+		tm.Parameters = append(tm.Parameters, &ParameterMetadata{Name: attr.Name, Schema: schema})
+	}
+
+	if len(setting.ReturnSchema) > 0 {
+		var rs interface{}
+		if err := json.Unmarshal([]byte(setting.ReturnSchema), &rs); err != nil {
+			logger.Warnf("ignore invalid return schema of transaction %s: %+v", setting.Name, err)
+		} else {
+			tm.Returns = rs
+		}
+	}
+	return tm
+}
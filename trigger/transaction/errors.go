@@ -0,0 +1,63 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"encoding/json"
+	"runtime/debug"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/pkg/errors"
+)
+
+// ErrorResponse is the JSON payload of a failed transaction, which is also set as the message of the chaincode response
+type ErrorResponse struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	TxID    string      `json:"txID,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ToJSON returns serialized error response
+func (e *ErrorResponse) ToJSON() []byte {
+	jsonBytes, err := json.Marshal(e)
+	if err != nil {
+		return []byte(e.Message)
+	}
+	return jsonBytes
+}
+
+// errorResponse returns JSON payload of a failed transaction.
+// Validation errors are reported as details of the error response.
+func errorResponse(stub shim.ChaincodeStubInterface, code int, err error) []byte {
+	resp := &ErrorResponse{
+		Code:    code,
+		Message: err.Error(),
+	}
+	if stub != nil {
+		resp.TxID = stub.GetTxID()
+	}
+	if verr, ok := err.(*ValidationError); ok {
+		resp.Message = verr.Message
+		if len(verr.Errors) > 0 {
+			resp.Details = verr.Errors
+		}
+	}
+	if uerr, ok := err.(*unsupportedError); ok {
+		resp.Details = map[string]interface{}{"transactions": uerr.supported}
+	}
+	logger.Errorf("transaction %s failed with status %d: %s", resp.TxID, code, err.Error())
+	return resp.ToJSON()
+}
+
+// recoverPanic converts a panic of a flow or activity to an error response of status 500,
+// so the chaincode container does not crash
+func recoverPanic(stub shim.ChaincodeStubInterface, fn string, status *int, payload *[]byte) {
+	if r := recover(); r != nil {
+		logger.Errorf("transaction %s panicked: %v\n%s", fn, r, string(debug.Stack()))
+		*status = 500
+		*payload = errorResponse(stub, 500, errors.Errorf("transaction %s panicked: %v", fn, r))
+	}
+}
@@ -0,0 +1,183 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/open-dovetail/fabric-chaincode/common"
+	"github.com/pkg/errors"
+	"github.com/project-flogo/core/data/coerce"
+)
+
+const (
+	// pruneFn is the system transaction that deletes expired idempotency records
+	pruneFn = "org.open-dovetail:PruneIdempotencyKeys"
+	// idempotencyNamespace is the object type of composite keys of idempotency records on the ledger
+	idempotencyNamespace = "_idempotency"
+	// defaultIdempotencyTTL is the default duration to keep idempotency records
+	defaultIdempotencyTTL = "24h"
+	// defaultPrunePageSize is the default number of idempotency records scanned by a prune transaction
+	defaultPrunePageSize = 1000
+)
+
+// idempotencyRecord is the response of a transaction recorded for a client-supplied idempotency key
+type idempotencyRecord struct {
+	TxID    string `json:"txID"`
+	Request string `json:"request"`
+	Expires string `json:"expires,omitempty"`
+	Status  int    `json:"status"`
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// PruneResult is returned by the system transaction that prunes expired idempotency records.
+// Bookmark is the start of the next page, which is empty when all records are scanned.
+type PruneResult struct {
+	Scanned  int    `json:"scanned"`
+	Pruned   int    `json:"pruned"`
+	Bookmark string `json:"bookmark,omitempty"`
+}
+
+// idempotencyKey returns the ledger key of the idempotency key specified by a transient attribute or parameter of the request,
+// or empty string if the transaction is not idempotent or the client does not supply an idempotency key.
+func (t *Trigger) idempotencyKey(stub shim.ChaincodeStubInterface, fn string, data *Output) (string, error) {
+	name := t.idempotency[fn]
+	if len(name) == 0 {
+		return "", nil
+	}
+	value, ok := data.Transient[name]
+	if !ok {
+		value = data.Parameters[name]
+	}
+	key, err := coerce.ToString(value)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid idempotency key %s", name)
+	}
+	if len(key) == 0 {
+		return "", nil
+	}
+	return stub.CreateCompositeKey(idempotencyNamespace, []string{t.contract, data.Transaction, key})
+}
+
+// requestDigest returns hash of request parameters and transient attributes, which detects the reuse of an idempotency key for a different request.
+// Sensitive transient attributes are redacted, so the digest on the ledger cannot be used to guess their values.
+func requestDigest(data *Output, redactor *common.Redactor) string {
+	params, _ := json.Marshal(data.Parameters)
+	transient, _ := json.Marshal(redactor.Redact(data.Transient))
+	h := sha256.New()
+	h.Write(params)
+	h.Write([]byte{0})
+	h.Write(transient)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordedResponse returns the recorded response of an idempotency key, or nil if the key is not recorded
+func recordedResponse(stub shim.ChaincodeStubInterface, key string) (*idempotencyRecord, error) {
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read idempotency record")
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	rec := &idempotencyRecord{}
+	if err := json.Unmarshal(value, rec); err != nil {
+		return nil, errors.Wrapf(err, "invalid idempotency record %s", string(value))
+	}
+	return rec, nil
+}
+
+// recordResponse records the response of a transaction for an idempotency key and the digest of the request
+func (t *Trigger) recordResponse(stub shim.ChaincodeStubInterface, key, digest string, data *Output, status int, payload []byte) error {
+	rec := &idempotencyRecord{
+		TxID:    data.TxID,
+		Request: digest,
+		Status:  status,
+		Payload: payload,
+	}
+	if txTime, err := time.Parse(time.RFC3339Nano, data.TxTime); err == nil {
+		rec.Expires = txTime.Add(t.idempotencyTTL).UTC().Format(time.RFC3339Nano)
+	}
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, value)
+}
+
+// pruneIdempotencyKeys deletes idempotency records in a page of records that expired before the transaction time.
+// Optional arguments are the page size, which defaults to 1000, and the bookmark of the page.
+func pruneIdempotencyKeys(stub shim.ChaincodeStubInterface, args []string) (int, []byte) {
+	pageSize := defaultPrunePageSize
+	if len(args) > 0 && len(args[0]) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return 400, errorResponse(stub, 400, errors.Errorf("invalid page size %s", args[0]))
+		}
+		pageSize = n
+	}
+	var bookmark string
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 500, errorResponse(stub, 500, errors.Wrapf(err, "failed to get transaction time"))
+	}
+	now := time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()
+
+	result, err := prunePage(common.NewChaincodeStore(stub), now, int32(pageSize), bookmark)
+	if err != nil {
+		return 500, errorResponse(stub, 500, err)
+	}
+	logger.Infof("pruned %d of %d idempotency records", result.Pruned, result.Scanned)
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return 500, errorResponse(stub, 500, err)
+	}
+	return 200, payload
+}
+
+// prunePage deletes idempotency records in a page of the query of all idempotency records that expired before the specified time
+func prunePage(store common.StateStore, now time.Time, pageSize int32, bookmark string) (*PruneResult, error) {
+	iter, md, err := store.GetStateByPartialCompositeKey("", idempotencyNamespace, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query idempotency records")
+	}
+	result := &PruneResult{}
+	if iter == nil {
+		return result, nil
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to iterate idempotency records")
+		}
+		result.Scanned++
+		rec := &idempotencyRecord{}
+		if err := json.Unmarshal(kv.Value, rec); err != nil {
+			logger.Warnf("ignore invalid idempotency record %s: %v", kv.Key, err)
+			continue
+		}
+		if expires, err := time.Parse(time.RFC3339Nano, rec.Expires); err != nil || !expires.Before(now) {
+			continue
+		}
+		if err := common.DeleteData(store, "", kv.Key); err != nil {
+			return nil, errors.Wrapf(err, "failed to delete idempotency record")
+		}
+		result.Pruned++
+	}
+	if md != nil {
+		result.Bookmark = md.Bookmark
+	}
+	return result, nil
+}
@@ -0,0 +1,135 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/attrmgr"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// unknownClient is the value of id, mspid and cn if the client identity cannot be extracted
+const unknownClient = "unknown"
+
+// nodeRoles are OUs that identify the NodeOU role of a client
+var nodeRoles = []string{"client", "peer", "admin", "orderer"}
+
+// ClientIdentity describes the client that submitted a transaction
+type ClientIdentity struct {
+	ID        string            `json:"id"`
+	MSPID     string            `json:"mspid"`
+	CN        string            `json:"cn"`
+	OU        string            `json:"ou,omitempty"`
+	OUs       []string          `json:"ous,omitempty"`
+	NodeRole  string            `json:"nodeRole,omitempty"`
+	Issuer    string            `json:"issuer,omitempty"`
+	Serial    string            `json:"serial,omitempty"`
+	NotBefore string            `json:"notBefore,omitempty"`
+	NotAfter  string            `json:"notAfter,omitempty"`
+	DNSNames  []string          `json:"dnsNames,omitempty"`
+	Emails    []string          `json:"emails,omitempty"`
+	IPs       []string          `json:"ips,omitempty"`
+	URIs      []string          `json:"uris,omitempty"`
+	Attrs     map[string]string `json:"attrs,omitempty"`
+}
+
+// ToMap converts client identity to the trigger output.
+// Custom attributes are also set as top-level properties unless they conflict with the identity fields.
+func (c *ClientIdentity) ToMap() map[string]interface{} {
+	result := make(map[string]interface{})
+	if data, err := json.Marshal(c); err == nil {
+		if err := json.Unmarshal(data, &result); err != nil {
+			logger.Warnf("failed to convert client identity: %v", err)
+		}
+	}
+	for k, v := range c.Attrs {
+		if _, ok := result[k]; !ok {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// extractCID returns identity of the client from its certificate,
+// including the custom attributes configured by the trigger, or all attributes if cidAllAttrs is set.
+func (t *Trigger) extractCID(stub shim.ChaincodeStubInterface) *ClientIdentity {
+	client := &ClientIdentity{}
+	c, err := cid.New(stub)
+	if err != nil {
+		logger.Warnf("extractCID(): %v\n", err)
+		client.ID = unknownClient
+		client.MSPID = unknownClient
+		client.CN = unknownClient
+		return client
+	}
+
+	// retrieve data from client identity
+	if id, err := c.GetID(); err == nil {
+		client.ID = id
+	}
+	if mspid, err := c.GetMSPID(); err == nil {
+		client.MSPID = mspid
+	}
+	cert, err := c.GetX509Certificate()
+	if err == nil && cert != nil {
+		client.setCertificate(cert)
+	}
+
+	// retrieve custom attributes from client identity
+	if t.cidAllAttrs && cert != nil {
+		if attrs, err := attrmgr.New().GetAttributesFromCert(cert); err == nil && attrs != nil {
+			for k, v := range attrs.Attrs {
+				client.setAttr(k, v)
+			}
+		}
+	}
+	for _, k := range t.cidAttrs {
+		if v, ok, err := c.GetAttributeValue(k); err == nil && ok && len(v) > 0 {
+			client.setAttr(k, v)
+		}
+	}
+	return client
+}
+
+func (c *ClientIdentity) setAttr(name, value string) {
+	if c.Attrs == nil {
+		c.Attrs = make(map[string]string)
+	}
+	c.Attrs[name] = value
+}
+
+// setCertificate sets subject, issuer, validity and SANs of the client certificate
+func (c *ClientIdentity) setCertificate(cert *x509.Certificate) {
+	c.CN = cert.Subject.CommonName
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		c.OUs = cert.Subject.OrganizationalUnit
+		c.OU = strings.Join(cert.Subject.OrganizationalUnit, ",")
+		for _, ou := range c.OUs {
+			if contains(nodeRoles, strings.ToLower(ou)) {
+				c.NodeRole = strings.ToLower(ou)
+				break
+			}
+		}
+	}
+	c.Issuer = cert.Issuer.String()
+	if cert.SerialNumber != nil {
+		c.Serial = cert.SerialNumber.Text(16)
+	}
+	c.NotBefore = cert.NotBefore.UTC().Format(time.RFC3339)
+	c.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+	c.DNSNames = cert.DNSNames
+	c.Emails = cert.EmailAddresses
+	for _, ip := range cert.IPAddresses {
+		c.IPs = append(c.IPs, ip.String())
+	}
+	for _, u := range cert.URIs {
+		c.URIs = append(c.URIs, u.String())
+	}
+}
@@ -5,6 +5,7 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package transaction
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -13,6 +14,15 @@ import (
 	jschema "github.com/xeipuuv/gojsonschema"
 )
 
+const (
+	// TransientJSON declares a transient value that is unmarshaled as a JSON document
+	TransientJSON = "json"
+	// TransientString declares a transient value that is passed to the flow as a plain string
+	TransientString = "string"
+	// TransientBytes declares a binary transient value that is passed to the flow as a base64 encoded string
+	TransientBytes = "bytes"
+)
+
 // Attribute describes a name and data type
 type Attribute struct {
 	Name string `md:"name"`
@@ -20,25 +30,68 @@ type Attribute struct {
 }
 
 // Settings for the trigger
+// namedArgs accepts a single JSON object argument of parameter names besides positional arguments.
+// initFn is the function name that invokes the default init handler from the chaincode Init, which defaults to "init".
+// contract is the contract name used to route function names of format contract:transaction,
+// and default marks the contract that handles function names without a contract name.
+// title and version describe the contract in the contract metadata.
+// cidAllAttrs extracts all custom attributes of client certificates besides the attributes listed by cid.
+// idempotencyTTL is the duration, e.g., 24h, to keep idempotency records before they can be pruned.
+// migrations are rules for upgrading ledger records of old schema versions by the migration system transaction.
+// systemAccess lists client identities allowed to invoke system transactions that update the ledger, which defaults to admins.
 type Settings struct {
-	CIDAttrs []string `md:"cidattrs"`
+	CIDAttrs       []string       `md:"cidattrs"`
+	CIDAllAttrs    bool           `md:"cidAllAttrs"`
+	NamedArgs      bool           `md:"namedArgs"`
+	InitFn         string         `md:"initFn"`
+	Contract       string         `md:"contract"`
+	Default        bool           `md:"default"`
+	Title          string         `md:"title"`
+	Version        string         `md:"version"`
+	IdempotencyTTL string         `md:"idempotencyTTL"`
+	Migrations     interface{}    `md:"migrations"`
+	SystemAccess   *AccessControl `md:"systemAccess"`
 }
 
 // HandlerSettings for the trigger
 // arguments are of parameter names and associated JSON data type
 // type is any valid JSON type, i.e., string, number, integer, boolean, array, object.
+// transient are of transient keys and associated data type, i.e., json, string, or bytes.
+// strict mode validates parameters and transient attributes against the JSON schemas.
+// defaults are values of optional parameters that are not specified by named arguments.
+// init marks the handler to be invoked when the chaincode is initialized or upgraded.
+// readOnly rejects ledger updates, events, and endorsement policy changes by the transaction.
+// returnSchema is the JSON schema of the transaction result, which is reported in the contract metadata.
+// accessControl lists MSP IDs, OUs, and CID attribute values of clients allowed to invoke the transaction.
+// idempotencyKey is the name of a transient attribute or parameter that contains a client-supplied idempotency key.
+// pattern is a regular expression of transaction names handled by the handler.
+// sensitive lists parameter names, transient keys, and JSON paths of data that are redacted in logs and events.
 type HandlerSettings struct {
-	Name      string       `md:"name,required"`
-	Arguments []*Attribute `md:"arguments"`
+	Name            string                 `md:"name,required"`
+	Arguments       []*Attribute           `md:"arguments"`
+	Transient       []*Attribute           `md:"transient"`
+	Strict          bool                   `md:"strict"`
+	ParameterSchema string                 `md:"parameterSchema"`
+	TransientSchema string                 `md:"transientSchema"`
+	Defaults        map[string]interface{} `md:"defaults"`
+	Init            bool                   `md:"init"`
+	ReadOnly        bool                   `md:"readOnly"`
+	ReturnSchema    string                 `md:"returnSchema"`
+	AccessControl   *AccessControl         `md:"accessControl"`
+	IdempotencyKey  string                 `md:"idempotencyKey"`
+	Pattern         string                 `md:"pattern"`
+	Sensitive       []string               `md:"sensitive"`
 }
 
 // Output of the trigger
+// transaction is the invoked transaction name, which may be matched by a pattern of the handler.
 type Output struct {
-	Parameters map[string]interface{} `md:"parameters"`
-	Transient  map[string]interface{} `md:"transient"`
-	TxID       string                 `md:"txID"`
-	TxTime     string                 `md:"txTime"`
-	CID        map[string]string      `md:"cid"`
+	Parameters  map[string]interface{} `md:"parameters"`
+	Transient   map[string]interface{} `md:"transient"`
+	TxID        string                 `md:"txID"`
+	TxTime      string                 `md:"txTime"`
+	CID         map[string]interface{} `md:"cid"`
+	Transaction string                 `md:"transaction"`
 }
 
 // Reply from the trigger
@@ -64,12 +117,69 @@ func toAttribute(name, value string) *Attribute {
 	}
 }
 
+// construct Attribute from name and declared type of a transient value
+func toTransientAttribute(name, value string) *Attribute {
+	dataType := TransientJSON
+	switch strings.ToLower(value) {
+	case "", TransientJSON, jschema.TYPE_OBJECT:
+		// default to JSON document
+	case TransientString, "text":
+		dataType = TransientString
+	case TransientBytes, "base64", "binary":
+		dataType = TransientBytes
+	default:
+		logger.Warnf("unknown type %s of transient attribute %s, use json instead", value, name)
+	}
+	return &Attribute{
+		Name: name,
+		Type: dataType,
+	}
+}
+
 func (p *Attribute) String() string {
 	return fmt.Sprintf("(%s:%s)", p.Name, p.Type)
 }
 
 // FromMap sets settings from a map
 func (s *Settings) FromMap(values map[string]interface{}) error {
+	var err error
+	if s.NamedArgs, err = coerce.ToBool(values["namedArgs"]); err != nil {
+		return err
+	}
+	if s.InitFn, err = coerce.ToString(values["initFn"]); err != nil {
+		return err
+	}
+	if len(s.InitFn) == 0 {
+		s.InitFn = "init"
+	}
+	if s.Contract, err = coerce.ToString(values["contract"]); err != nil {
+		return err
+	}
+	if s.Default, err = coerce.ToBool(values["default"]); err != nil {
+		return err
+	}
+	if s.CIDAllAttrs, err = coerce.ToBool(values["cidAllAttrs"]); err != nil {
+		return err
+	}
+	if s.Title, err = coerce.ToString(values["title"]); err != nil {
+		return err
+	}
+	if s.Version, err = coerce.ToString(values["version"]); err != nil {
+		return err
+	}
+	if s.IdempotencyTTL, err = coerce.ToString(values["idempotencyTTL"]); err != nil {
+		return err
+	}
+	if len(s.IdempotencyTTL) == 0 {
+		s.IdempotencyTTL = defaultIdempotencyTTL
+	}
+	if s.Migrations, err = coerce.ToAny(values["migrations"]); err != nil {
+		return err
+	}
+	if s.SystemAccess, err = toAccessControl(values["systemAccess"]); err != nil {
+		return err
+	}
+
 	cid, err := coerce.ToString(values["cid"])
 	if err != nil {
 		return err
@@ -98,10 +208,99 @@ func (h *HandlerSettings) FromMap(values map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
-	if len(params) == 0 {
+	h.Arguments = parseAttributes(params, toAttribute)
+
+	trans, err := coerce.ToString(values["transient"])
+	if err != nil {
+		return err
+	}
+	h.Transient = parseAttributes(trans, toTransientAttribute)
+
+	if h.Strict, err = coerce.ToBool(values["strict"]); err != nil {
+		return err
+	}
+	if h.ParameterSchema, err = schemaToString(values["parameterSchema"]); err != nil {
+		return err
+	}
+	if h.TransientSchema, err = schemaToString(values["transientSchema"]); err != nil {
+		return err
+	}
+	if h.Defaults, err = coerce.ToObject(values["defaults"]); err != nil {
+		return err
+	}
+	if h.Init, err = coerce.ToBool(values["init"]); err != nil {
+		return err
+	}
+	if h.ReadOnly, err = coerce.ToBool(values["readOnly"]); err != nil {
+		return err
+	}
+	if h.ReturnSchema, err = schemaToString(values["returnSchema"]); err != nil {
+		return err
+	}
+	if h.AccessControl, err = toAccessControl(values["accessControl"]); err != nil {
+		return err
+	}
+	if h.IdempotencyKey, err = coerce.ToString(values["idempotencyKey"]); err != nil {
+		return err
+	}
+	if h.Pattern, err = coerce.ToString(values["pattern"]); err != nil {
+		return err
+	}
+	if h.Sensitive, err = toStringList(values["sensitive"]); err != nil {
+		return err
+	}
+	return nil
+}
+
+// schemaToString returns a JSON schema setting as string, which may be configured as a string or a JSON object
+func schemaToString(schema interface{}) (string, error) {
+	if schema == nil {
+		return "", nil
+	}
+	if s, ok := schema.(string); ok {
+		return strings.TrimSpace(s), nil
+	}
+	jsonBytes, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// toStringList converts a setting of comma-delimited string or array to a list of strings
+func toStringList(setting interface{}) ([]string, error) {
+	var items []string
+	if list, ok := setting.([]interface{}); ok {
+		for _, v := range list {
+			s, err := coerce.ToString(v)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, s)
+		}
+	} else {
+		s, err := coerce.ToString(setting)
+		if err != nil {
+			return nil, err
+		}
+		items = strings.Split(s, ",")
+	}
+	var result []string
+	for _, v := range items {
+		if p := strings.TrimSpace(v); len(p) > 0 {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// parseAttributes converts comma-delimited name:value pairs to a list of attributes
+func parseAttributes(config string, toAttr func(name, value string) *Attribute) []*Attribute {
+	if len(config) == 0 {
 		return nil
 	}
-	args := strings.Split(strings.TrimSpace(params), ",")
+	var result []*Attribute
+	args := strings.Split(strings.TrimSpace(config), ",")
 	for _, v := range args {
 		pt := strings.Split(strings.TrimSpace(v), ":")
 		if len(pt) == 0 || len(strings.TrimSpace(pt[0])) == 0 {
@@ -111,11 +310,11 @@ func (h *HandlerSettings) FromMap(values map[string]interface{}) error {
 		if len(pt) > 1 {
 			value = strings.TrimSpace(pt[1])
 		}
-		if attr := toAttribute(strings.TrimSpace(pt[0]), value); attr != nil {
-			h.Arguments = append(h.Arguments, attr)
+		if attr := toAttr(strings.TrimSpace(pt[0]), value); attr != nil {
+			result = append(result, attr)
 		}
 	}
-	return nil
+	return result
 }
 
 // FromMap sets trigger output values from a map
@@ -133,7 +332,10 @@ func (o *Output) FromMap(values map[string]interface{}) error {
 	if o.TxTime, err = coerce.ToString(values["txTime"]); err != nil {
 		return err
 	}
-	if o.CID, err = coerce.ToParams(values["cid"]); err != nil {
+	if o.CID, err = coerce.ToObject(values["cid"]); err != nil {
+		return err
+	}
+	if o.Transaction, err = coerce.ToString(values["transaction"]); err != nil {
 		return err
 	}
 
@@ -143,11 +345,12 @@ func (o *Output) FromMap(values map[string]interface{}) error {
 // ToMap converts trigger output to a map
 func (o *Output) ToMap() map[string]interface{} {
 	return map[string]interface{}{
-		"parameters": o.Parameters,
-		"transient":  o.Transient,
-		"txID":       o.TxID,
-		"txTime":     o.TxTime,
-		"cid":        o.CID,
+		"parameters":  o.Parameters,
+		"transient":   o.Transient,
+		"txID":        o.TxID,
+		"txTime":      o.TxTime,
+		"cid":         o.CID,
+		"transaction": o.Transaction,
 	}
 }
 
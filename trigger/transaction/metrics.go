@@ -0,0 +1,171 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are upper bounds in seconds of the transaction latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricsLog is non-zero if a summary of each transaction is logged, which is disabled when metrics are exported to Prometheus.
+// It is accessed atomically, because the metrics server may set it while transactions are executed.
+var metricsLog int32 = 1
+
+// txMetrics collects metrics of all transactions handled by the chaincode
+var txMetrics = &metricsRegistry{handlers: make(map[handlerKey]*handlerMetrics)}
+
+type handlerKey struct {
+	contract    string
+	transaction string
+}
+
+// handlerMetrics collects call counts, latency, and ledger operations of a transaction handler
+type handlerMetrics struct {
+	status   map[int]int64
+	buckets  []int64
+	count    int64
+	sum      float64
+	reads    map[string]int64
+	writes   map[string]int64
+	activity map[string]bool
+}
+
+type metricsRegistry struct {
+	mu       sync.Mutex
+	handlers map[handlerKey]*handlerMetrics
+}
+
+// SetMetricsLog enables or disables the log summary of each transaction.
+// It is disabled when the chaincode exports metrics to Prometheus.
+func SetMetricsLog(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&metricsLog, v)
+}
+
+// MetricsHandler returns HTTP handler that exports transaction metrics in Prometheus text format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		txMetrics.write(w)
+	})
+}
+
+func (m *metricsRegistry) record(s *span, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := handlerKey{contract: s.Contract, transaction: s.Handler}
+	h, ok := m.handlers[key]
+	if !ok {
+		h = &handlerMetrics{
+			status:   make(map[int]int64),
+			buckets:  make([]int64, len(latencyBuckets)),
+			reads:    make(map[string]int64),
+			writes:   make(map[string]int64),
+			activity: make(map[string]bool),
+		}
+		m.handlers[key] = h
+	}
+	h.status[s.Status]++
+	h.count++
+	seconds := elapsed.Seconds()
+	h.sum += seconds
+	for i, b := range latencyBuckets {
+		if seconds <= b {
+			h.buckets[i]++
+		}
+	}
+	for a, c := range s.Activities {
+		h.activity[a] = true
+		h.reads[a] += int64(c.Reads)
+		h.writes[a] += int64(c.Writes)
+	}
+}
+
+// write exports metrics in Prometheus text format
+func (m *metricsRegistry) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []handlerKey
+	for k := range m.handlers {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].contract != keys[j].contract {
+			return keys[i].contract < keys[j].contract
+		}
+		return keys[i].transaction < keys[j].transaction
+	})
+
+	fmt.Fprintln(w, "# HELP fabric_transaction_requests_total Number of transactions by status code.")
+	fmt.Fprintln(w, "# TYPE fabric_transaction_requests_total counter")
+	for _, k := range keys {
+		h := m.handlers[k]
+		var codes []int
+		for c := range h.status {
+			codes = append(codes, c)
+		}
+		sort.Ints(codes)
+		for _, c := range codes {
+			fmt.Fprintf(w, "fabric_transaction_requests_total{%s,status=\"%d\"} %d\n", k.labels(), c, h.status[c])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP fabric_transaction_duration_seconds Latency of transactions.")
+	fmt.Fprintln(w, "# TYPE fabric_transaction_duration_seconds histogram")
+	for _, k := range keys {
+		h := m.handlers[k]
+		for i, b := range latencyBuckets {
+			fmt.Fprintf(w, "fabric_transaction_duration_seconds_bucket{%s,le=\"%s\"} %d\n", k.labels(), strconv.FormatFloat(b, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(w, "fabric_transaction_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", k.labels(), h.count)
+		fmt.Fprintf(w, "fabric_transaction_duration_seconds_sum{%s} %g\n", k.labels(), h.sum)
+		fmt.Fprintf(w, "fabric_transaction_duration_seconds_count{%s} %d\n", k.labels(), h.count)
+	}
+
+	m.writeActivityCounts(w, keys, "reads", func(h *handlerMetrics) map[string]int64 { return h.reads })
+	m.writeActivityCounts(w, keys, "writes", func(h *handlerMetrics) map[string]int64 { return h.writes })
+}
+
+func (m *metricsRegistry) writeActivityCounts(w io.Writer, keys []handlerKey, op string, counts func(*handlerMetrics) map[string]int64) {
+	name := "fabric_transaction_state_" + op + "_total"
+	fmt.Fprintf(w, "# HELP %s Number of ledger %s by activity.\n", name, op)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, k := range keys {
+		h := m.handlers[k]
+		var activities []string
+		for a := range h.activity {
+			activities = append(activities, a)
+		}
+		sort.Strings(activities)
+		for _, a := range activities {
+			fmt.Fprintf(w, "%s{%s,activity=\"%s\"} %d\n", name, k.labels(), escapeLabel(a), counts(h)[a])
+		}
+	}
+}
+
+func (k handlerKey) labels() string {
+	return fmt.Sprintf("contract=\"%s\",transaction=\"%s\"", escapeLabel(k.contract), escapeLabel(k.transaction))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
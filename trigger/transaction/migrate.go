@@ -0,0 +1,143 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/open-dovetail/fabric-chaincode/common"
+	"github.com/pkg/errors"
+	"github.com/project-flogo/core/trigger"
+)
+
+const (
+	// migrateFn is the system transaction that upgrades ledger records of a schema to the latest version
+	migrateFn = "org.open-dovetail:MigrateRecords"
+	// defaultMigratePageSize is the default number of ledger records scanned by a migration transaction
+	defaultMigratePageSize = 100
+)
+
+// MigrateResult is returned by the system transaction that migrates records of a schema.
+// Bookmark is the start key of the next page, which is empty when all records are scanned.
+type MigrateResult struct {
+	Scanned  int    `json:"scanned"`
+	Migrated int    `json:"migrated"`
+	Bookmark string `json:"bookmark,omitempty"`
+}
+
+// migrateRecords upgrades one page of enveloped records of a schema to the latest version of registered migration rules.
+// Arguments are the schema name, and optional page size (default 100), bookmark of the page, and private data collection.
+// Page size is ignored for private data collections, so all records of a collection are scanned in one transaction.
+func migrateRecords(stub shim.ChaincodeStubInterface, args []string) (int, []byte) {
+	if len(args) == 0 || len(args[0]) == 0 {
+		return 400, errorResponse(stub, 400, errors.New("schema name is not specified"))
+	}
+	schema := args[0]
+	target := common.LatestSchemaVersion(schema)
+	if target == 0 {
+		return 400, errorResponse(stub, 400, errors.Errorf("no migration rule is defined for schema %s", schema))
+	}
+	pageSize := defaultMigratePageSize
+	if len(args) > 1 && len(args[1]) > 0 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return 400, errorResponse(stub, 400, errors.Errorf("invalid page size %s", args[1]))
+		}
+		pageSize = n
+	}
+	var bookmark, collection string
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+	if len(args) > 3 {
+		collection = args[3]
+	}
+
+	// pass the stub and write buffer of the transaction to migration subflows, as the trigger passes them to flows
+	buffer := common.NewWriteBuffer()
+	ctxValues := map[string]interface{}{
+		common.FabricStub:     stub,
+		common.FabricTxID:     stub.GetTxID(),
+		common.FabricWriteSet: buffer,
+	}
+	if ts, err := stub.GetTxTimestamp(); err == nil {
+		ctxValues[common.FabricTxTime] = time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.RFC3339Nano)
+	}
+	ctx := trigger.NewContextWithValues(context.Background(), ctxValues)
+	store := common.NewBufferedStore(common.NewChaincodeStore(stub), buffer)
+
+	result, err := migratePage(ctx, store, collection, schema, target, int32(pageSize), bookmark)
+	if err != nil {
+		return 500, errorResponse(stub, 500, err)
+	}
+	logger.Infof("migrated %d of %d records of %s to version %d", result.Migrated, result.Scanned, schema, target)
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return 500, errorResponse(stub, 500, err)
+	}
+	return 200, payload
+}
+
+// migratePage upgrades enveloped records of a schema in a page of the range query of all state keys.
+// Composite keys registered for the schema are updated as the put activity does, i.e., stale keys of the old value are deleted,
+// and new keys are stored after unique keys are verified, so a migration that changes key attributes does not leave orphaned keys.
+func migratePage(ctx context.Context, store common.StateStore, collection, schema string, target int, pageSize int32, bookmark string) (*MigrateResult, error) {
+	iter, md, err := common.GetDataByRange(store, collection, "", "", pageSize, bookmark)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query records of %s", schema)
+	}
+	result := &MigrateResult{}
+	if iter == nil {
+		return result, nil
+	}
+	defer iter.Close()
+
+	keyDefs, uniqueKeys := common.SchemaKeys(schema)
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to iterate records of %s", schema)
+		}
+		result.Scanned++
+		if !common.IsEnvelope(kv.Value) {
+			continue
+		}
+		data, format, err := common.DecodeValue(kv.Value)
+		if err != nil {
+			logger.Warnf("ignore record %s that cannot be decoded: %v", kv.Key, err)
+			continue
+		}
+		if format.Schema != schema || format.Version >= target {
+			continue
+		}
+		upgraded, f, err := common.MigrateValue(ctx, data, format, target)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to migrate record %s", kv.Key)
+		}
+		stale, added := common.DiffCompositeKeys(
+			common.ExtractCompositeKeys(store, keyDefs, kv.Key, data),
+			common.ExtractCompositeKeys(store, keyDefs, kv.Key, upgraded))
+		if err := common.CheckUniqueKeys(store, collection, added, uniqueKeys); err != nil {
+			return nil, errors.Wrapf(err, "failed to migrate record %s", kv.Key)
+		}
+		value, err := common.EncodeValue(upgraded, f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode record %s", kv.Key)
+		}
+		if err := common.PutData(store, collection, kv.Key, value); err != nil {
+			return nil, errors.Wrapf(err, "failed to store record %s", kv.Key)
+		}
+		common.WriteCompositeKeys(store, collection, stale, added)
+		result.Migrated++
+	}
+	if md != nil {
+		result.Bookmark = md.Bookmark
+	}
+	return result, nil
+}
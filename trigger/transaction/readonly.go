@@ -0,0 +1,81 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/pkg/errors"
+)
+
+// readOnlyStub wraps chaincode stub of a read-only transaction,
+// and rejects all ledger updates, events, state-based endorsement changes, and invocations of other chaincodes
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+	txName    string
+	violation error
+}
+
+func newReadOnlyStub(stub shim.ChaincodeStubInterface, txName string) *readOnlyStub {
+	return &readOnlyStub{
+		ChaincodeStubInterface: stub,
+		txName:                 txName,
+	}
+}
+
+// Violation returns the error of the first rejected update, or nil if no update is attempted
+func (s *readOnlyStub) Violation() error {
+	return s.violation
+}
+
+func (s *readOnlyStub) reject(op, key string) error {
+	err := errors.Errorf("%s %s is not allowed in read-only transaction %s", op, key, s.txName)
+	logger.Error(err.Error())
+	if s.violation == nil {
+		s.violation = err
+	}
+	return err
+}
+
+// PutState rejects update of ledger state
+func (s *readOnlyStub) PutState(key string, value []byte) error {
+	return s.reject("PutState", key)
+}
+
+// DelState rejects deletion of ledger state
+func (s *readOnlyStub) DelState(key string) error {
+	return s.reject("DelState", key)
+}
+
+// SetStateValidationParameter rejects update of state-based endorsement policy
+func (s *readOnlyStub) SetStateValidationParameter(key string, ep []byte) error {
+	return s.reject("SetStateValidationParameter", key)
+}
+
+// PutPrivateData rejects update of private data
+func (s *readOnlyStub) PutPrivateData(collection string, key string, value []byte) error {
+	return s.reject("PutPrivateData", collection+"/"+key)
+}
+
+// DelPrivateData rejects deletion of private data
+func (s *readOnlyStub) DelPrivateData(collection, key string) error {
+	return s.reject("DelPrivateData", collection+"/"+key)
+}
+
+// SetPrivateDataValidationParameter rejects update of state-based endorsement policy of private data
+func (s *readOnlyStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return s.reject("SetPrivateDataValidationParameter", collection+"/"+key)
+}
+
+// SetEvent rejects chaincode event
+func (s *readOnlyStub) SetEvent(name string, payload []byte) error {
+	return s.reject("SetEvent", name)
+}
+
+// InvokeChaincode rejects invocation of another chaincode, which may update the ledger of the same channel
+func (s *readOnlyStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	err := s.reject("InvokeChaincode", chaincodeName)
+	return pb.Response{Status: 403, Message: err.Error()}
+}
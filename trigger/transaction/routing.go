@@ -0,0 +1,115 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// wildcard suffix of a handler name matches transaction names of the prefix, and a handler named "*" matches all transactions
+const wildcard = "*"
+
+// route matches transaction names to a handler by a regular expression or a name prefix
+type route struct {
+	name    string
+	pattern string
+	regex   *regexp.Regexp
+	prefix  string
+}
+
+// newRoute returns the route of a handler that specifies a pattern or a wildcard name, or nil if the handler matches only its name
+func newRoute(setting *HandlerSettings) (*route, error) {
+	if len(setting.Pattern) > 0 {
+		regex, err := regexp.Compile("^(?:" + setting.Pattern + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern of transaction %s", setting.Name)
+		}
+		return &route{name: setting.Name, pattern: setting.Pattern, regex: regex}, nil
+	}
+	if strings.HasSuffix(setting.Name, wildcard) {
+		return &route{name: setting.Name, prefix: strings.TrimSuffix(setting.Name, wildcard)}, nil
+	}
+	return nil, nil
+}
+
+func (r *route) match(fn string) bool {
+	if r.regex != nil {
+		return r.regex.MatchString(fn)
+	}
+	return strings.HasPrefix(fn, r.prefix)
+}
+
+// String returns the pattern of the route
+func (r *route) String() string {
+	if r.regex != nil {
+		return r.pattern
+	}
+	return r.prefix + wildcard
+}
+
+// isPattern returns true if the handler matches transaction names by a pattern or a wildcard name
+func (h *HandlerSettings) isPattern() bool {
+	return len(h.Pattern) > 0 || strings.HasSuffix(h.Name, wildcard)
+}
+
+// resolve returns the name of the handler for a transaction name.
+// A transaction is handled by the handler of the same name, or else the first matching handler of a pattern or name prefix,
+// or else the default handler named "*". Init handlers and the configured init function are not resolved,
+// so they are invoked only by the chaincode Init.
+func (t *Trigger) resolve(fn string) (string, bool) {
+	if fn == t.initFn || t.inits[fn] {
+		return "", false
+	}
+	if _, ok := t.handlers[fn]; ok {
+		return fn, true
+	}
+	var fallback string
+	for _, r := range t.routes {
+		if r.prefix == "" && r.regex == nil {
+			// default handler matches only if no other pattern matches
+			fallback = r.name
+			continue
+		}
+		if r.match(fn) && !t.inits[r.name] {
+			return r.name, true
+		}
+	}
+	if t.inits[fallback] {
+		return "", false
+	}
+	return fallback, len(fallback) > 0
+}
+
+// unsupportedError reports a transaction name that is not handled by the trigger, and the supported transactions
+type unsupportedError struct {
+	fn        string
+	supported []string
+}
+
+func (e *unsupportedError) Error() string {
+	return "transaction " + e.fn + " is not supported"
+}
+
+// supportedTransactions returns sorted names and patterns of transactions handled by the trigger
+func (t *Trigger) supportedTransactions() []string {
+	patterns := make(map[string]string)
+	for _, r := range t.routes {
+		patterns[r.name] = r.String()
+	}
+	var result []string
+	for name := range t.handlers {
+		if p, ok := patterns[name]; ok {
+			result = append(result, p)
+		} else {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,106 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+// Package runner executes transactions of a Flogo chaincode app without a Fabric network.
+// It loads an app.json generated by contract2flow or Flogo Web UI, invokes a transaction against
+// an in-process mock stub, and keeps ledger states and private data collections in a JSON file.
+// The runner executable is built by scripts/runner.sh, which imports activities of the app, e.g.,
+//
+//	sample_runner -app sample.json -state state.json createMarble marble1 blue 35 tom
+//	sample_runner -app sample.json -state state.json -transient '{"marble":{"name":"marble2","price":99}}' createPrivateMarble
+//	sample_runner -app sample.json -state state.json -cert user1.pem -mspid Org1MSP transferMarble marble1 jerry
+package runner
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/project-flogo/core/data/expression/script"
+	"github.com/project-flogo/core/data/schema"
+	"github.com/project-flogo/core/support/log"
+)
+
+// Main parses command line arguments, and executes a transaction of the app.
+// Activities and functions used by the app must be imported by the main package.
+func Main() {
+	os.Setenv("FLOGO_RUNNER_TYPE", "DIRECT")
+	os.Setenv("FLOGO_ENGINE_STOP_ON_ERROR", "false")
+	os.Setenv("FLOGO_MAPPING_IGNORE_ERRORS", "true")
+
+	// necessary to access schema of complex object attributes from activity context
+	schema.Enable()
+	schema.DisableValidation()
+
+	appFile := flag.String("app", "app.json", "Flogo app file")
+	stateFile := flag.String("state", "", "JSON file of ledger states and private data, which is updated by successful transactions")
+	transient := flag.String("transient", "", "transient data as a JSON object")
+	certFile := flag.String("cert", "", "PEM file of the client certificate")
+	mspid := flag.String("mspid", "Org1MSP", "MSP ID of the client")
+	isInit := flag.Bool("init", false, "invoke chaincode init instead of a transaction")
+	logLevel := flag.String("log", "INFO", "log level, i.e., DEBUG, INFO, WARN, or ERROR")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] function [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 && !*isInit {
+		flag.Usage()
+		os.Exit(2)
+	}
+	setLogLevel(*logLevel)
+
+	r, err := NewRunner(*appFile, *stateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start runner: %+v\n", err)
+		os.Exit(1)
+	}
+	defer r.Stop()
+
+	if err := r.SetTransient(*transient); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if len(*certFile) > 0 {
+		if err := r.SetCreator(*mspid, *certFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fn := ""
+	var args []string
+	if flag.NArg() > 0 {
+		fn = flag.Arg(0)
+		args = flag.Args()[1:]
+	}
+	resp, events := r.Invoke(fn, args, *isInit)
+	fmt.Printf("status: %d\n", resp.Status)
+	fmt.Printf("payload: %s\n", string(resp.Payload))
+	for _, e := range events {
+		fmt.Printf("event %s: %s\n", e.EventName, string(e.Payload))
+	}
+	if resp.Status >= 400 {
+		// discard updates of failed transaction
+		os.Exit(1)
+	}
+	if err := r.SaveState(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save state: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func setLogLevel(level string) {
+	switch strings.ToUpper(level) {
+	case "FATAL", "PANIC", "ERROR":
+		log.SetLogLevel(log.RootLogger(), log.ErrorLevel)
+	case "WARN", "WARNING":
+		log.SetLogLevel(log.RootLogger(), log.WarnLevel)
+	case "DEBUG", "TRACE":
+		log.SetLogLevel(log.RootLogger(), log.DebugLevel)
+	default:
+		log.SetLogLevel(log.RootLogger(), log.InfoLevel)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package runner
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/pkg/errors"
+)
+
+// privateStub implements private data operations that are not supported by shimtest.MockStub
+type privateStub struct {
+	*shimtest.MockStub
+}
+
+// DelPrivateData deletes a key from a private data collection
+func (s *privateStub) DelPrivateData(collection, key string) error {
+	if m, ok := s.PvtState[collection]; ok {
+		delete(m, key)
+	}
+	return nil
+}
+
+// GetPrivateDataByRange returns private data of keys in the range [startKey, endKey)
+func (s *privateStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	var keys []string
+	for k := range s.PvtState[collection] {
+		if k >= startKey && (len(endKey) == 0 || k < endKey) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	iter := &privateIterator{}
+	for _, k := range keys {
+		iter.kvs = append(iter.kvs, &queryresult.KV{Key: k, Value: s.PvtState[collection][k]})
+	}
+	return iter, nil
+}
+
+// GetPrivateDataByPartialCompositeKey returns private data of composite keys matching the leading attributes
+func (s *privateStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetPrivateDataByRange(collection, prefix, prefix+string(utf8.MaxRune))
+}
+
+// privateIterator iterates private data in key order
+type privateIterator struct {
+	kvs []*queryresult.KV
+	pos int
+}
+
+// HasNext implements shim.StateQueryIteratorInterface
+func (it *privateIterator) HasNext() bool {
+	return it.pos < len(it.kvs)
+}
+
+// Next implements shim.StateQueryIteratorInterface
+func (it *privateIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, errors.New("no more private data")
+	}
+	kv := it.kvs[it.pos]
+	it.pos++
+	return kv, nil
+}
+
+// Close implements shim.StateQueryIteratorInterface
+func (it *privateIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,294 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package runner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	trigger "github.com/open-dovetail/fabric-chaincode/trigger/transaction"
+	"github.com/pkg/errors"
+	"github.com/project-flogo/core/engine"
+)
+
+// bytesTag is the only field of the JSON object that stores a state value as base64, if the value is not compact JSON
+const bytesTag = "$bytes"
+
+// WorldState is the JSON file format of ledger states and private data collections used by the runner
+type WorldState struct {
+	State       map[string]json.RawMessage            `json:"state"`
+	Collections map[string]map[string]json.RawMessage `json:"collections,omitempty"`
+}
+
+// Runner executes transactions of a Flogo chaincode app against an in-process mock stub
+type Runner struct {
+	engine    engine.Engine
+	stub      *shimtest.MockStub
+	stateFile string
+}
+
+// chaincode invokes the transaction trigger the same way as the chaincode shim,
+// and supports private data operations that are not implemented by the mock stub
+type chaincode struct {
+}
+
+// Init implements shim.Chaincode.Init
+func (c *chaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	fn, args := stub.GetFunctionAndParameters()
+	return response(trigger.Init(wrapStub(stub), fn, args))
+}
+
+// Invoke implements shim.Chaincode.Invoke
+func (c *chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	fn, args := stub.GetFunctionAndParameters()
+	return response(trigger.Invoke(wrapStub(stub), fn, args))
+}
+
+func wrapStub(stub shim.ChaincodeStubInterface) shim.ChaincodeStubInterface {
+	if ms, ok := stub.(*shimtest.MockStub); ok {
+		return &privateStub{MockStub: ms}
+	}
+	return stub
+}
+
+func response(status int, payload []byte) pb.Response {
+	resp := pb.Response{
+		Status:  int32(status),
+		Payload: payload,
+	}
+	if status >= shim.ERRORTHRESHOLD {
+		resp.Message = string(payload)
+	}
+	return resp
+}
+
+// NewRunner starts the Flogo engine of an app config file, and loads world state from a JSON file if it is specified
+func NewRunner(appFile, stateFile string) (*Runner, error) {
+	data, err := ioutil.ReadFile(appFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read app file %s", appFile)
+	}
+	config, err := engine.LoadAppConfig(string(data), false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load app config %s", appFile)
+	}
+	e, err := engine.New(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create engine for app %s", appFile)
+	}
+	if err := e.Start(); err != nil {
+		return nil, errors.Wrapf(err, "failed to start engine for app %s", appFile)
+	}
+
+	r := &Runner{
+		engine:    e,
+		stub:      shimtest.NewMockStub(config.Name, new(chaincode)),
+		stateFile: stateFile,
+	}
+	if err := r.LoadState(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Stop stops the Flogo engine
+func (r *Runner) Stop() error {
+	return r.engine.Stop()
+}
+
+// LoadState loads ledger states and private data from the state file, which is ignored if it does not exist
+func (r *Runner) LoadState() error {
+	if len(r.stateFile) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(r.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to read state file %s", r.stateFile)
+	}
+	ws := &WorldState{}
+	if err := json.Unmarshal(data, ws); err != nil {
+		return errors.Wrapf(err, "invalid state file %s", r.stateFile)
+	}
+
+	// mock stub accepts state updates only in a transaction
+	txID := "load-state"
+	r.stub.MockTransactionStart(txID)
+	defer r.stub.MockTransactionEnd(txID)
+	for k, v := range ws.State {
+		value, err := fromRawValue(v)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value of state %s", k)
+		}
+		if err := r.stub.PutState(k, value); err != nil {
+			return errors.Wrapf(err, "failed to load state %s", k)
+		}
+	}
+	for c, kv := range ws.Collections {
+		for k, v := range kv {
+			value, err := fromRawValue(v)
+			if err != nil {
+				return errors.Wrapf(err, "invalid value of private data %s/%s", c, k)
+			}
+			if err := r.stub.PutPrivateData(c, k, value); err != nil {
+				return errors.Wrapf(err, "failed to load private data %s/%s", c, k)
+			}
+		}
+	}
+	return nil
+}
+
+// SaveState writes ledger states and private data to the state file
+func (r *Runner) SaveState() error {
+	if len(r.stateFile) == 0 {
+		return nil
+	}
+	ws := &WorldState{
+		State:       toRawMap(r.stub.State),
+		Collections: make(map[string]map[string]json.RawMessage),
+	}
+	for c, kv := range r.stub.PvtState {
+		ws.Collections[c] = toRawMap(kv)
+	}
+
+	// do not escape HTML characters, so state values are written as is
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(ws); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.stateFile, buf.Bytes(), 0644)
+}
+
+// toRawMap converts state values to JSON, where values that are not compact JSON, e.g., CBOR or protobuf envelopes,
+// are stored as base64 in an object of the only field '$bytes', so they are loaded with the same bytes
+func toRawMap(kv map[string][]byte) map[string]json.RawMessage {
+	result := make(map[string]json.RawMessage)
+	for k, v := range kv {
+		if isCompactJSON(v) && !isBytesValue(v) {
+			result[k] = json.RawMessage(v)
+		} else {
+			s, _ := json.Marshal(map[string]string{bytesTag: base64.StdEncoding.EncodeToString(v)})
+			result[k] = json.RawMessage(s)
+		}
+	}
+	return result
+}
+
+// fromRawValue returns the bytes of a state value stored by toRawMap, where JSON values are compacted
+func fromRawValue(raw json.RawMessage) ([]byte, error) {
+	if isBytesValue(raw) {
+		var v map[string]string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(v[bytesTag])
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isCompactJSON(v []byte) bool {
+	if !utf8.Valid(v) || !json.Valid(v) {
+		return false
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, v); err != nil {
+		return false
+	}
+	return bytes.Equal(buf.Bytes(), v)
+}
+
+// isBytesValue returns true if a JSON value is an object of the only field '$bytes'
+func isBytesValue(v []byte) bool {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(v, &obj); err != nil || len(obj) != 1 {
+		return false
+	}
+	_, ok := obj[bytesTag]
+	return ok
+}
+
+// SetTransient sets transient data of the following transactions from a JSON object.
+// string values are sent as plain strings, and other values are sent as JSON documents.
+func (r *Runner) SetTransient(transient string) error {
+	if len(transient) == 0 {
+		r.stub.TransientMap = nil
+		return nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(transient), &values); err != nil {
+		return errors.Wrapf(err, "transient data must be a JSON object")
+	}
+	tmap := make(map[string][]byte)
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			tmap[k] = []byte(s)
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		tmap[k] = data
+	}
+	r.stub.TransientMap = tmap
+	return nil
+}
+
+// SetCreator sets the client identity of the following transactions from the MSP ID and a PEM cert file
+func (r *Runner) SetCreator(mspid, certFile string) error {
+	cert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read cert file %s", certFile)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspid, IdBytes: cert})
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize client identity")
+	}
+	r.stub.Creator = creator
+	return nil
+}
+
+// Invoke executes a transaction, or the chaincode init if isInit is true.
+// It returns the chaincode response and the events set by the transaction.
+func (r *Runner) Invoke(fn string, args []string, isInit bool) (pb.Response, []*pb.ChaincodeEvent) {
+	ccArgs := [][]byte{[]byte(fn)}
+	for _, a := range args {
+		ccArgs = append(ccArgs, []byte(a))
+	}
+	txID := fmt.Sprintf("tx-%d", time.Now().UnixNano())
+
+	var resp pb.Response
+	if isInit {
+		resp = r.stub.MockInit(txID, ccArgs)
+	} else {
+		resp = r.stub.MockInvoke(txID, ccArgs)
+	}
+
+	var events []*pb.ChaincodeEvent
+	for len(r.stub.ChaincodeEventsChannel) > 0 {
+		events = append(events, <-r.stub.ChaincodeEventsChannel)
+	}
+	return resp, events
+}
@@ -0,0 +1,158 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package runner
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRunner(stateFile string) *Runner {
+	return &Runner{
+		stub:      shimtest.NewMockStub("test", new(chaincode)),
+		stateFile: stateFile,
+	}
+}
+
+func TestWorldState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner")
+	assert.NoError(t, err, "create temp dir should not throw error")
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+
+	data := `{"state":{"marble1":{"color":"blue","size":35}},"collections":{"_implicit_org_Org1MSP":{"marble1":{"price":99}}}}`
+	err = ioutil.WriteFile(stateFile, []byte(data), 0644)
+	assert.NoError(t, err, "write state file should not throw error")
+
+	r := newTestRunner(stateFile)
+	err = r.LoadState()
+	assert.NoError(t, err, "load state should not throw error")
+	assert.Equal(t, `{"color":"blue","size":35}`, string(r.stub.State["marble1"]), "ledger state should be loaded")
+	assert.Equal(t, `{"price":99}`, string(r.stub.PvtState["_implicit_org_Org1MSP"]["marble1"]), "private data should be loaded")
+
+	// update states and write to file
+	values := map[string][]byte{
+		"marble2": []byte("not json"),
+		"marble3": {0x00},
+		"marble4": {0xa2, 0x61, 0x61, 0x01, 0xff, 0xfe},
+		"marble5": []byte(`{"name":"<tom & jerry>"}`),
+		"marble6": []byte(`{"$bytes":"AA=="}`),
+		"marble7": []byte(`{ "color": "red" }`),
+	}
+	r.stub.MockTransactionStart("tx1")
+	for k, v := range values {
+		err = r.stub.PutState(k, v)
+		assert.NoError(t, err, "put state should not throw error")
+	}
+	r.stub.MockTransactionEnd("tx1")
+	pvt := &privateStub{MockStub: r.stub}
+	err = pvt.DelPrivateData("_implicit_org_Org1MSP", "marble1")
+	assert.NoError(t, err, "delete private data should not throw error")
+	err = r.SaveState()
+	assert.NoError(t, err, "save state should not throw error")
+
+	ws := &WorldState{}
+	saved, err := ioutil.ReadFile(stateFile)
+	assert.NoError(t, err, "read state file should not throw error")
+	err = json.Unmarshal(saved, ws)
+	assert.NoError(t, err, "saved state should be valid JSON")
+	assert.Equal(t, 7, len(ws.State), "saved state should contain 7 keys")
+	assert.True(t, isBytesValue(ws.State["marble2"]), "non-JSON value should be saved as base64")
+	assert.True(t, strings.Contains(string(saved), "<tom & jerry>"), "JSON value should not be escaped")
+	assert.Equal(t, 0, len(ws.Collections["_implicit_org_Org1MSP"]), "deleted private data should not be saved")
+
+	// reload the saved states with the same bytes
+	r = newTestRunner(stateFile)
+	err = r.LoadState()
+	assert.NoError(t, err, "load saved state should not throw error")
+	assert.Equal(t, `{"color":"blue","size":35}`, string(r.stub.State["marble1"]), "JSON state should be reloaded")
+	for k, v := range values {
+		assert.Equal(t, v, r.stub.State[k], "state %s should be reloaded with the same bytes", k)
+	}
+}
+
+func TestPrivateRange(t *testing.T) {
+	r := newTestRunner("")
+	pvt := &privateStub{MockStub: r.stub}
+	for _, name := range []string{"marble3", "marble1", "marble2"} {
+		key, err := pvt.CreateCompositeKey("color~name", []string{"blue", name})
+		assert.NoError(t, err, "create composite key should not throw error")
+		err = pvt.PutPrivateData("coll", key, []byte(`{}`))
+		assert.NoError(t, err, "put private data should not throw error")
+	}
+	err := pvt.PutPrivateData("coll", "other", []byte(`{}`))
+	assert.NoError(t, err, "put private data should not throw error")
+
+	iter, err := pvt.GetPrivateDataByPartialCompositeKey("coll", "color~name", []string{"blue"})
+	assert.NoError(t, err, "private data query should not throw error")
+	var names []string
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		assert.NoError(t, err, "iterator should not throw error")
+		_, attrs, err := pvt.SplitCompositeKey(kv.Key)
+		assert.NoError(t, err, "split composite key should not throw error")
+		names = append(names, attrs[1])
+	}
+	assert.Equal(t, []string{"marble1", "marble2", "marble3"}, names, "private data should be returned in key order")
+}
+
+func TestClientIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner")
+	assert.NoError(t, err, "create temp dir should not throw error")
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "user.pem")
+	err = ioutil.WriteFile(certFile, testCert(t, "user1"), 0644)
+	assert.NoError(t, err, "write cert file should not throw error")
+
+	r := newTestRunner("")
+	err = r.SetCreator("Org1MSP", certFile)
+	assert.NoError(t, err, "set creator should not throw error")
+	c, err := cid.New(r.stub)
+	assert.NoError(t, err, "client identity should be parsed from the creator")
+	mspid, _ := c.GetMSPID()
+	assert.Equal(t, "Org1MSP", mspid, "MSP ID should be set")
+	cert, _ := c.GetX509Certificate()
+	assert.Equal(t, "user1", cert.Subject.CommonName, "cert should be set")
+
+	err = r.SetTransient(`{"marble":{"name":"marble1"},"secret":"abc"}`)
+	assert.NoError(t, err, "set transient should not throw error")
+	assert.Equal(t, `{"name":"marble1"}`, string(r.stub.TransientMap["marble"]), "JSON transient should be serialized")
+	assert.Equal(t, "abc", string(r.stub.TransientMap["secret"]), "string transient should be plain string")
+
+	// transaction trigger is not started by the test
+	resp, _ := r.Invoke("getMarble", []string{"marble1"}, false)
+	assert.Equal(t, int32(500), resp.Status, "transaction should fail without trigger")
+	assert.Equal(t, string(resp.Payload), resp.Message, "error payload should be set as response message")
+}
+
+func testCert(t *testing.T, cn string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "generate key should not throw error")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err, "create cert should not throw error")
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
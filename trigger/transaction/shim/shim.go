@@ -6,12 +6,15 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
 
 	shim "github.com/hyperledger/fabric-chaincode-go/shim"
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 	trigger "github.com/open-dovetail/fabric-chaincode/trigger/transaction"
+	"github.com/pkg/errors"
 	_ "github.com/project-flogo/core/data/expression/script"
 	"github.com/project-flogo/core/data/schema"
 	"github.com/project-flogo/core/support/log"
@@ -35,7 +38,7 @@ func init() {
 
 func setLogLevel() {
 	//  get log level from env FLOGO_LOG_LEVEL or CORE_CHAINCODE_LOGGING_LEVEL
-	logLevel := "DEBUG"
+	logLevel := "INFO"
 	if l, ok := os.LookupEnv("FLOGO_LOG_LEVEL"); ok {
 		logLevel = strings.ToUpper(l)
 	} else if l, ok := os.LookupEnv("CORE_CHAINCODE_LOGGING_LEVEL"); ok {
@@ -58,25 +61,148 @@ func setLogLevel() {
 // Init is called during chaincode instantiation to initialize any data,
 // and also calls this function to reset or to migrate data.
 func (t *Contract) Init(stub shim.ChaincodeStubInterface) pb.Response {
-	return shim.Success(nil)
+	fn, args := stub.GetFunctionAndParameters()
+	logger.Debugf("init chaincode fn=%s with %d args", fn, len(args))
+
+	status, payload := trigger.Init(stub, fn, args)
+	return response(status, payload)
 }
 
 // Invoke is called per transaction on the chaincode.
 func (t *Contract) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	fn, args := stub.GetFunctionAndParameters()
-	logger.Debugf("invoke transaction fn=%s, args=%+v", fn, args)
+	logger.Debugf("invoke transaction fn=%s with %d args", fn, len(args))
 
 	status, payload := trigger.Invoke(stub, fn, args)
-	return pb.Response{
+	return response(status, payload)
+}
+
+// response returns chaincode response of a transaction result,
+// and sets the JSON error payload as the response message for SDKs to report the error
+func response(status int, payload []byte) pb.Response {
+	resp := pb.Response{
 		Status:  int32(status),
 		Payload: payload,
 	}
+	if status >= shim.ERRORTHRESHOLD {
+		resp.Message = string(payload)
+	}
+	return resp
+}
+
+// env variables for running chaincode as an external service.
+// TLS key, cert and client CA cert can be specified as PEM content, or as a file path by env of suffix _FILE,
+// e.g., CHAINCODE_TLS_KEY_FILE. TLS is disabled if neither TLS key nor cert is specified.
+// Prometheus metrics are exported at CHAINCODE_METRICS_ADDRESS, which defaults to 0.0.0.0:9090.
+const (
+	envServerAddress = "CHAINCODE_SERVER_ADDRESS"
+	envChaincodeID   = "CHAINCODE_ID"
+	envTLSKey        = "CHAINCODE_TLS_KEY"
+	envTLSCert       = "CHAINCODE_TLS_CERT"
+	envTLSClientCA   = "CHAINCODE_TLS_CLIENT_CACERT"
+	envMetrics       = "CHAINCODE_METRICS_ADDRESS"
+	defaultMetrics   = "0.0.0.0:9090"
+)
+
+// newChaincodeServer returns a chaincode server if the chaincode is configured to run as an external service,
+// or nil if the chaincode should be launched by the peer.
+func newChaincodeServer(cc shim.Chaincode) (*shim.ChaincodeServer, error) {
+	address, ok := os.LookupEnv(envServerAddress)
+	if !ok || len(strings.TrimSpace(address)) == 0 {
+		return nil, nil
+	}
+	ccid := strings.TrimSpace(os.Getenv(envChaincodeID))
+	if len(ccid) == 0 {
+		return nil, errors.Errorf("%s must be set when %s is set", envChaincodeID, envServerAddress)
+	}
+
+	tls, err := tlsProperties()
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("start chaincode %s as external service at %s, TLS enabled: %t", ccid, address, !tls.Disabled)
+	return &shim.ChaincodeServer{
+		CCID:     ccid,
+		Address:  strings.TrimSpace(address),
+		CC:       cc,
+		TLSProps: tls,
+	}, nil
+}
+
+// tlsProperties loads TLS key, cert and client CA cert of the chaincode server from env
+func tlsProperties() (shim.TLSProperties, error) {
+	props := shim.TLSProperties{}
+	var err error
+	if props.Key, err = loadPEM(envTLSKey); err != nil {
+		return props, err
+	}
+	if props.Cert, err = loadPEM(envTLSCert); err != nil {
+		return props, err
+	}
+	if props.ClientCACerts, err = loadPEM(envTLSClientCA); err != nil {
+		return props, err
+	}
+	if len(props.Key) == 0 && len(props.Cert) == 0 {
+		props.Disabled = true
+		return props, nil
+	}
+	if len(props.Key) == 0 || len(props.Cert) == 0 {
+		return props, errors.Errorf("both %s and %s are required to enable TLS", envTLSKey, envTLSCert)
+	}
+	return props, nil
 }
 
-// main function starts up chaincode in the container during instantiate
+// loadPEM returns PEM content of the env variable, or content of the file specified by the env variable of suffix _FILE
+func loadPEM(name string) ([]byte, error) {
+	if v, ok := os.LookupEnv(name); ok && len(strings.TrimSpace(v)) > 0 {
+		return []byte(v), nil
+	}
+	if f, ok := os.LookupEnv(name + "_FILE"); ok && len(strings.TrimSpace(f)) > 0 {
+		data, err := ioutil.ReadFile(strings.TrimSpace(f))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", name+"_FILE")
+		}
+		return data, nil
+	}
+	return nil, nil
+}
+
+// startMetricsServer exports transaction metrics to Prometheus, and disables log summary of transactions
+func startMetricsServer() {
+	address := strings.TrimSpace(os.Getenv(envMetrics))
+	if len(address) == 0 {
+		address = defaultMetrics
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", trigger.MetricsHandler())
+	trigger.SetMetricsLog(false)
+	go func() {
+		logger.Infof("export Prometheus metrics at %s/metrics", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			logger.Errorf("metrics server stopped: %v", err)
+			trigger.SetMetricsLog(true)
+		}
+	}()
+}
+
+// main function starts up chaincode in the container during instantiate,
+// or starts chaincode server if CHAINCODE_SERVER_ADDRESS is set for running chaincode as an external service
 func main() {
 	setLogLevel()
 
+	server, err := newChaincodeServer(new(Contract))
+	if err != nil {
+		fmt.Printf("Error configuring chaincode server: %s", err)
+		os.Exit(1)
+	}
+	if server != nil {
+		startMetricsServer()
+		if err := server.Start(); err != nil {
+			fmt.Printf("Error starting chaincode server: %s", err)
+		}
+		return
+	}
+
 	if err := shim.Start(new(Contract)); err != nil {
 		fmt.Printf("Error starting chaincode: %s", err)
 	}
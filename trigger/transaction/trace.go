@@ -0,0 +1,242 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// unknownActivity attributes ledger operations that are not called from an activity
+const unknownActivity = "unknown"
+
+// span traces a transaction by txID, and counts ledger reads and writes of each activity.
+// Metrics are recorded by the handler of the transaction, so transaction names sent by clients do not add metric labels.
+type span struct {
+	TxID        string                     `json:"txID"`
+	Contract    string                     `json:"contract,omitempty"`
+	Handler     string                     `json:"handler"`
+	Transaction string                     `json:"transaction"`
+	Status      int                        `json:"status"`
+	Duration    float64                    `json:"durationMs"`
+	Reads       int                        `json:"reads"`
+	Writes      int                        `json:"writes"`
+	Activities  map[string]*activityCounts `json:"activities,omitempty"`
+	start       time.Time
+	mu          sync.Mutex
+}
+
+// activityCounts counts ledger reads and writes of an activity
+type activityCounts struct {
+	Reads  int `json:"reads"`
+	Writes int `json:"writes"`
+}
+
+func newSpan(txID, contract, handler, txName string) *span {
+	return &span{
+		TxID:        txID,
+		Contract:    contract,
+		Handler:     handler,
+		Transaction: txName,
+		Activities:  make(map[string]*activityCounts),
+		start:       time.Now(),
+	}
+}
+
+func (s *span) count(activity string, reads, writes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(activity) == 0 {
+		activity = unknownActivity
+	}
+	c, ok := s.Activities[activity]
+	if !ok {
+		c = &activityCounts{}
+		s.Activities[activity] = c
+	}
+	c.Reads += reads
+	c.Writes += writes
+	s.Reads += reads
+	s.Writes += writes
+}
+
+// finish completes the span with the transaction status, and records it in the metrics
+func (s *span) finish(status int) {
+	s.mu.Lock()
+	s.Status = status
+	elapsed := time.Since(s.start)
+	s.Duration = float64(elapsed.Microseconds()) / 1000
+	s.mu.Unlock()
+
+	txMetrics.record(s, elapsed)
+	if atomic.LoadInt32(&metricsLog) != 0 {
+		if data, err := json.Marshal(s); err == nil {
+			logger.Infof("transaction summary: %s", string(data))
+		}
+	}
+}
+
+// traceStub wraps chaincode stub of a transaction, and counts ledger reads and writes in the span of the transaction.
+// It implements common.ActivityStub, so the calls are attributed to the activity that gets the stub.
+type traceStub struct {
+	shim.ChaincodeStubInterface
+	span     *span
+	activity string
+}
+
+func newTraceStub(stub shim.ChaincodeStubInterface, sp *span) *traceStub {
+	return &traceStub{
+		ChaincodeStubInterface: stub,
+		span:                   sp,
+	}
+}
+
+// WithActivity returns a stub that attributes ledger operations to the named activity
+func (s *traceStub) WithActivity(name string) shim.ChaincodeStubInterface {
+	return &traceStub{
+		ChaincodeStubInterface: s.ChaincodeStubInterface,
+		span:                   s.span,
+		activity:               name,
+	}
+}
+
+func (s *traceStub) read() {
+	s.span.count(s.activity, 1, 0)
+}
+
+func (s *traceStub) write() {
+	s.span.count(s.activity, 0, 1)
+}
+
+// GetState counts a ledger read
+func (s *traceStub) GetState(key string) ([]byte, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+// GetStateByRange counts a ledger read
+func (s *traceStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+}
+
+// GetStateByRangeWithPagination counts a ledger read
+func (s *traceStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+}
+
+// GetStateByPartialCompositeKey counts a ledger read
+func (s *traceStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keys)
+}
+
+// GetStateByPartialCompositeKeyWithPagination counts a ledger read
+func (s *traceStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(objectType, keys, pageSize, bookmark)
+}
+
+// GetQueryResult counts a ledger read
+func (s *traceStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetQueryResult(query)
+}
+
+// GetQueryResultWithPagination counts a ledger read
+func (s *traceStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetQueryResultWithPagination(query, pageSize, bookmark)
+}
+
+// GetHistoryForKey counts a ledger read
+func (s *traceStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetHistoryForKey(key)
+}
+
+// GetStateValidationParameter counts a ledger read
+func (s *traceStub) GetStateValidationParameter(key string) ([]byte, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetStateValidationParameter(key)
+}
+
+// GetPrivateData counts a ledger read
+func (s *traceStub) GetPrivateData(collection, key string) ([]byte, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetPrivateData(collection, key)
+}
+
+// GetPrivateDataHash counts a ledger read
+func (s *traceStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetPrivateDataHash(collection, key)
+}
+
+// GetPrivateDataByRange counts a ledger read
+func (s *traceStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetPrivateDataByRange(collection, startKey, endKey)
+}
+
+// GetPrivateDataByPartialCompositeKey counts a ledger read
+func (s *traceStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetPrivateDataByPartialCompositeKey(collection, objectType, keys)
+}
+
+// GetPrivateDataQueryResult counts a ledger read
+func (s *traceStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetPrivateDataQueryResult(collection, query)
+}
+
+// GetPrivateDataValidationParameter counts a ledger read
+func (s *traceStub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	s.read()
+	return s.ChaincodeStubInterface.GetPrivateDataValidationParameter(collection, key)
+}
+
+// PutState counts a ledger write
+func (s *traceStub) PutState(key string, value []byte) error {
+	s.write()
+	return s.ChaincodeStubInterface.PutState(key, value)
+}
+
+// DelState counts a ledger write
+func (s *traceStub) DelState(key string) error {
+	s.write()
+	return s.ChaincodeStubInterface.DelState(key)
+}
+
+// SetStateValidationParameter counts a ledger write
+func (s *traceStub) SetStateValidationParameter(key string, ep []byte) error {
+	s.write()
+	return s.ChaincodeStubInterface.SetStateValidationParameter(key, ep)
+}
+
+// PutPrivateData counts a ledger write
+func (s *traceStub) PutPrivateData(collection, key string, value []byte) error {
+	s.write()
+	return s.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+}
+
+// DelPrivateData counts a ledger write
+func (s *traceStub) DelPrivateData(collection, key string) error {
+	s.write()
+	return s.ChaincodeStubInterface.DelPrivateData(collection, key)
+}
+
+// SetPrivateDataValidationParameter counts a ledger write
+func (s *traceStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	s.write()
+	return s.ChaincodeStubInterface.SetPrivateDataValidationParameter(collection, key, ep)
+}
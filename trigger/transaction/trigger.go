@@ -6,17 +6,17 @@ package transaction
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
-	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/project-flogo/core/data/coerce"
 	"github.com/project-flogo/core/trigger"
 
-	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/open-dovetail/fabric-chaincode/common"
 	"github.com/project-flogo/core/support/log"
@@ -24,7 +24,15 @@ import (
 )
 
 var triggerMd = trigger.NewMetadata(&Settings{}, &HandlerSettings{}, &Output{}, &Reply{})
-var singleton *Trigger
+
+// triggers registered by contract names, so a chaincode can host multiple contracts
+var triggers = map[string]*Trigger{}
+
+// defaultTrigger handles transactions whose function names do not specify a contract
+var defaultTrigger *Trigger
+
+// contractSeparator separates contract name and transaction name in function names, e.g., contract:transaction
+const contractSeparator = ":"
 
 var logger = log.ChildLogger(log.RootLogger(), "trigger-fabric-transaction")
 
@@ -38,22 +46,64 @@ type Factory struct {
 
 // New implements trigger.Factory.New
 func (t *Factory) New(config *trigger.Config) (trigger.Trigger, error) {
-	if singleton == nil {
-		setting := &Settings{}
-		if err := setting.FromMap(config.Settings); err != nil {
-			logger.Warnf("Failed to extract trigger setting config: %+v", err)
-		}
+	setting := &Settings{}
+	if err := setting.FromMap(config.Settings); err != nil {
+		logger.Warnf("Failed to extract trigger setting config: %+v", err)
+	}
+	if _, ok := triggers[setting.Contract]; ok {
+		return nil, errors.Errorf("transaction trigger is already instantiated for contract '%s'", setting.Contract)
+	}
+
+	ttl, err := time.ParseDuration(setting.IdempotencyTTL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid idempotencyTTL '%s'", setting.IdempotencyTTL)
+	}
+	if err := common.LoadMigrationRules(setting.Migrations); err != nil {
+		return nil, err
+	}
 
-		singleton = &Trigger{
-			id:        config.Id,
-			cidAttrs:  setting.CIDAttrs,
-			handlers:  map[string]trigger.Handler{},
-			arguments: map[string][]*Attribute{},
+	systemAccess := setting.SystemAccess
+	if systemAccess == nil {
+		systemAccess = defaultSystemAccess
+	}
+	cidAttrs := setting.CIDAttrs
+	for k := range systemAccess.Attributes {
+		// extract attributes required by access control of system transactions from client identity
+		if !contains(cidAttrs, k) {
+			cidAttrs = append(cidAttrs, k)
 		}
-		return singleton, nil
 	}
-	logger.Warn("Only one transaction trigger can be instantiated")
-	return nil, nil
+
+	trig := &Trigger{
+		id:             config.Id,
+		contract:       setting.Contract,
+		title:          setting.Title,
+		version:        setting.Version,
+		cidAttrs:       cidAttrs,
+		cidAllAttrs:    setting.CIDAllAttrs,
+		namedArgs:      setting.NamedArgs,
+		initFn:         setting.InitFn,
+		handlers:       map[string]trigger.Handler{},
+		arguments:      map[string][]*Attribute{},
+		transient:      map[string][]*Attribute{},
+		validators:     map[string]*validator{},
+		defaults:       map[string]map[string]interface{}{},
+		inits:          map[string]bool{},
+		readOnly:       map[string]bool{},
+		access:         map[string]*AccessControl{},
+		sensitive:      map[string][]string{},
+		redactors:      map[string]*common.Redactor{},
+		idempotency:    map[string]string{},
+		idempotencyTTL: ttl,
+		systemAccess:   systemAccess,
+	}
+	triggers[setting.Contract] = trig
+	if defaultTrigger == nil || setting.Default {
+		// use the first trigger as the default unless another is specified
+		defaultTrigger = trig
+		logger.Infof("contract '%s' is the default contract", setting.Contract)
+	}
+	return trig, nil
 }
 
 // Metadata implements trigger.Factory.Metadata
@@ -63,10 +113,30 @@ func (*Factory) Metadata() *trigger.Metadata {
 
 // Trigger is the Fabric transaction Trigger implementation
 type Trigger struct {
-	id        string
-	cidAttrs  []string
-	handlers  map[string]trigger.Handler
-	arguments map[string][]*Attribute
+	id             string
+	contract       string
+	title          string
+	version        string
+	cidAttrs       []string
+	cidAllAttrs    bool
+	namedArgs      bool
+	initFn         string
+	initName       string
+	handlers       map[string]trigger.Handler
+	arguments      map[string][]*Attribute
+	transient      map[string][]*Attribute
+	validators     map[string]*validator
+	defaults       map[string]map[string]interface{}
+	inits          map[string]bool
+	readOnly       map[string]bool
+	access         map[string]*AccessControl
+	sensitive      map[string][]string
+	redactors      map[string]*common.Redactor
+	routes         []*route
+	idempotency    map[string]string
+	idempotencyTTL time.Duration
+	systemAccess   *AccessControl
+	settings       []*HandlerSettings
 }
 
 // Initialize implements trigger.Init.Initialize
@@ -82,8 +152,50 @@ func (t *Trigger) Initialize(ctx trigger.InitContext) error {
 			continue
 		}
 		t.handlers[setting.Name] = handler
+		t.settings = append(t.settings, setting)
+		r, err := newRoute(setting)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			t.routes = append(t.routes, r)
+		}
 		t.arguments[setting.Name] = setting.Arguments
-		logger.Debugf("transaction %s accepts arguments %v", setting.Name, setting.Arguments)
+		t.transient[setting.Name] = setting.Transient
+		t.defaults[setting.Name] = setting.Defaults
+		t.readOnly[setting.Name] = setting.ReadOnly
+		if len(setting.Sensitive) > 0 {
+			t.sensitive[setting.Name] = setting.Sensitive
+			t.redactors[setting.Name] = common.NewRedactor(setting.Sensitive...)
+		}
+		if len(setting.IdempotencyKey) > 0 && !setting.ReadOnly {
+			t.idempotency[setting.Name] = setting.IdempotencyKey
+		}
+		if setting.AccessControl != nil {
+			t.access[setting.Name] = setting.AccessControl
+			for k := range setting.AccessControl.Attributes {
+				// extract attributes required by access control from client identity
+				if !contains(t.cidAttrs, k) {
+					t.cidAttrs = append(t.cidAttrs, k)
+				}
+			}
+		}
+		if setting.Init {
+			t.inits[setting.Name] = true
+			if len(t.initName) == 0 {
+				// the first init handler is the default init handler
+				t.initName = setting.Name
+				logger.Infof("transaction %s is the default init handler", setting.Name)
+			}
+		}
+		if setting.Strict {
+			v, err := newValidator(setting)
+			if err != nil {
+				return err
+			}
+			t.validators[setting.Name] = v
+		}
+		logger.Debugf("transaction %s accepts arguments %v transient %v", setting.Name, setting.Arguments, setting.Transient)
 	}
 	return nil
 }
@@ -99,45 +211,130 @@ func (t *Trigger) Stop() error {
 	return nil
 }
 
-// Invoke starts the singleton trigger and invokes the action registered in the handler,
-// and returns status code and result as JSON string
-func Invoke(stub shim.ChaincodeStubInterface, fn string, args []string) (int, []byte) {
-	logger.Debugf("fabric.Trigger invokes fn %s with args %+v", fn, args)
+// Invoke invokes the action registered in the handler of a transaction, and returns status code and result as JSON string.
+// The function name may specify the contract of the transaction by the format contract:transaction,
+// or else the transaction is handled by the default contract.
+// A panic of any transaction, including system transactions, is recovered and returned as an error of status 500.
+func Invoke(stub shim.ChaincodeStubInterface, fn string, args []string) (status int, payload []byte) {
+	logger.Debugf("fabric.Trigger invokes fn %s with %d args", fn, len(args))
+	defer recoverPanic(stub, fn, &status, &payload)
 
-	handler, ok := singleton.handlers[fn]
+	if fn == metadataFn {
+		return contractMetadata()
+	}
+	t, name := lookupTrigger(fn)
+	if t == nil {
+		return 500, errorResponse(stub, 500, errors.New("transaction trigger is not initialized"))
+	}
+	if fn == pruneFn || fn == migrateFn {
+		return t.invokeSystem(stub, fn, args)
+	}
+	handler, ok := t.resolve(name)
 	if !ok {
-		msg := fmt.Sprintf("Handler not defined for transaction %s", fn)
-		logger.Errorf("%s\n", msg)
-		return 400, []byte(msg)
+		return 501, errorResponse(stub, 501, &unsupportedError{fn: fn, supported: t.supportedTransactions()})
+	}
+	return t.invoke(stub, handler, name, t.handlers[handler], args)
+}
+
+// invokeSystem executes a system transaction that updates the ledger if the client is allowed by the access control of system transactions
+func (t *Trigger) invokeSystem(stub shim.ChaincodeStubInterface, fn string, args []string) (int, []byte) {
+	client := t.extractCID(stub)
+	if err := t.systemAccess.authorize(client); err != nil {
+		return 403, errorResponse(stub, 403, errors.Wrapf(err, "access denied for system transaction %s", fn))
+	}
+	if fn == pruneFn {
+		return pruneIdempotencyKeys(stub, args)
 	}
+	return migrateRecords(stub, args)
+}
+
+// Init invokes the init handler when the chaincode is initialized or upgraded.
+// It invokes the init handler of the name fn if it is defined, or else the default init handler of the contract.
+// It returns success if no init handler is defined.
+func Init(stub shim.ChaincodeStubInterface, fn string, args []string) (status int, payload []byte) {
+	logger.Debugf("fabric.Trigger initializes fn %s with %d args", fn, len(args))
+	defer recoverPanic(stub, fn, &status, &payload)
+
+	t, name := lookupTrigger(fn)
+	if t == nil {
+		logger.Info("transaction trigger is not initialized")
+		return 200, nil
+	}
+	if !t.inits[name] {
+		name = t.initName
+	}
+	if len(name) == 0 {
+		logger.Info("no init handler is defined")
+		return 200, nil
+	}
+	return t.invoke(stub, name, name, t.handlers[name], args)
+}
+
+// lookupTrigger returns the trigger of the contract specified by a function name of format contract:transaction,
+// and the transaction name. It returns the default trigger if the function name does not specify a known contract.
+func lookupTrigger(fn string) (*Trigger, string) {
+	if i := strings.LastIndex(fn, contractSeparator); i >= 0 {
+		if t, ok := triggers[fn[:i]]; ok {
+			return t, fn[i+1:]
+		}
+	}
+	return defaultTrigger, fn
+}
+
+// invoke executes the action registered in the handler fn for the invoked transaction txName,
+// and returns status code and result as JSON string, or JSON error response if the transaction failed.
+// A panic of the flow is recovered here, so the metrics of the transaction record the status 500.
+func (t *Trigger) invoke(stub shim.ChaincodeStubInterface, fn, txName string, handler trigger.Handler, args []string) (status int, payload []byte) {
+	// record metrics after the status of recovered panic is set
+	sp := newSpan(stub.GetTxID(), t.contract, fn, txName)
+	defer func() { sp.finish(status) }()
+	defer recoverPanic(stub, fn, &status, &payload)
 
 	// extract client ID
-	triggerData := &Output{}
-	triggerData.CID = singleton.extractCID(stub)
+	triggerData := &Output{Transaction: txName}
+	client := t.extractCID(stub)
+	triggerData.CID = client.ToMap()
+	if err := t.access[fn].authorize(client); err != nil {
+		return 403, errorResponse(stub, 403, errors.Wrapf(err, "access denied for transaction %s", fn))
+	}
 
 	// construct transaction parameters
-	paramData, err := prepareParameters(singleton.arguments[fn], args)
+	var missing map[string]bool
+	if t.namedArgs {
+		if named, absent, ok := namedArguments(t.arguments[fn], t.defaults[fn], args); ok {
+			logger.Debug("converted named arguments to positional arguments")
+			args, missing = named, absent
+		}
+	}
+	v, strict := t.validators[fn]
+	var paramData map[string]interface{}
+	var err error
+	if strict {
+		paramData, err = v.prepareStrictParameters(t.arguments[fn], args, missing)
+	} else {
+		paramData, err = prepareParameters(t.arguments[fn], args)
+	}
 	if err != nil {
-		logger.Errorf("%v\n", err)
-		return 400, []byte(err.Error())
+		return 400, errorResponse(stub, 400, err)
 	}
+	redactor := t.redactors[fn]
 	if logger.DebugEnabled() && len(paramData) > 0 {
 		// debug flow data
-		paramBytes, _ := json.Marshal(paramData)
-		logger.Debugf("trigger parameters: %s", string(paramBytes))
+		logger.Debugf("trigger parameters: %s", redactor.String(paramData))
 	}
 	triggerData.Parameters = paramData
 
 	// construct transient attributes
-	transData, err := prepareTransient(stub)
+	transData, err := prepareTransient(stub, t.transient[fn])
+	if err == nil && strict {
+		err = v.validateTransient(transData)
+	}
 	if err != nil {
-		logger.Errorf("%v\n", err)
-		return 400, []byte(err.Error())
+		return 400, errorResponse(stub, 400, err)
 	}
 	if logger.DebugEnabled() {
 		// debug flow data
-		transBytes, _ := json.Marshal(transData)
-		logger.Debugf("trigger transient attributes: %s", string(transBytes))
+		logger.Debugf("trigger transient attributes: %s", redactor.String(transData))
 	}
 	triggerData.Transient = transData
 
@@ -146,26 +343,87 @@ func Invoke(stub shim.ChaincodeStubInterface, fn string, args []string) (int, []
 		triggerData.TxTime = time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.RFC3339Nano)
 	}
 
-	// execute flogo flow, and pass stub, txID and txTime to the flow context
+	// replay the recorded response if the client resubmits a request of the same idempotency key
+	idemKey, err := t.idempotencyKey(stub, fn, triggerData)
+	if err != nil {
+		return 400, errorResponse(stub, 400, err)
+	}
+	var digest string
+	if len(idemKey) > 0 {
+		rec, err := recordedResponse(stub, idemKey)
+		if err != nil {
+			return 500, errorResponse(stub, 500, err)
+		}
+		digest = requestDigest(triggerData, redactor)
+		if rec != nil {
+			if rec.Request != digest {
+				return 409, errorResponse(stub, 409, errors.Errorf("idempotency key is already used by transaction %s with different parameters or transient attributes", rec.TxID))
+			}
+			logger.Infof("transaction %s replays the response of transaction %s", triggerData.TxID, rec.TxID)
+			return rec.Status, rec.Payload
+		}
+	}
+
+	status, payload = t.execute(stub, fn, handler, triggerData, sp)
+	if len(idemKey) > 0 && status < shim.ERRORTHRESHOLD {
+		if err := t.recordResponse(stub, idemKey, digest, triggerData, status, payload); err != nil {
+			return 500, errorResponse(stub, 500, errors.Wrapf(err, "failed to record idempotency key"))
+		}
+	}
+	return status, payload
+}
+
+// execute invokes the flow of a transaction handler with the trigger output,
+// and returns status code and result as JSON string, or JSON error response if the transaction failed.
+func (t *Trigger) execute(stub shim.ChaincodeStubInterface, fn string, handler trigger.Handler, triggerData *Output, sp *span) (int, []byte) {
+	// reject ledger updates of read-only transaction
+	var roStub *readOnlyStub
+	if t.readOnly[fn] {
+		roStub = newReadOnlyStub(stub, fn)
+		stub = roStub
+	}
+
+	// attribute ledger operations of activities to the transaction span
+	stub = newTraceStub(stub, sp)
+
+	// execute flogo flow, and pass stub, txID, txTime and write buffer of the transaction to the flow context
 	logger.Debugf("flogo flow started transaction %s with timestamp %s", triggerData.TxID, triggerData.TxTime)
 	ctxValues := map[string]interface{}{
-		common.FabricStub:   stub,
-		common.FabricTxID:   triggerData.TxID,
-		common.FabricTxTime: triggerData.TxTime,
-		common.FabricCID:    triggerData.CID,
+		common.FabricStub:      stub,
+		common.FabricTxID:      triggerData.TxID,
+		common.FabricTxTime:    triggerData.TxTime,
+		common.FabricCID:       triggerData.CID,
+		common.FabricSensitive: t.sensitive[fn],
+		common.FabricWriteSet:  common.NewWriteBuffer(),
 	}
 	ctx := trigger.NewContextWithValues(context.Background(), ctxValues)
 	results, err := handler.Handle(ctx, triggerData.ToMap())
+	if roStub != nil && roStub.Violation() != nil {
+		return 403, errorResponse(stub, 403, roStub.Violation())
+	}
 	if err != nil {
-		logger.Errorf("flogo flow returned error: %+v", err)
-		return 500, []byte(err.Error())
+		return 500, errorResponse(stub, 500, errors.Wrapf(err, "flogo flow returned error"))
 	}
 
 	// processing reply
 	reply := &Reply{}
 	if err := reply.FromMap(results); err != nil {
-		logger.Errorf("failed to transform flow result: %v\n", err)
-		return 500, []byte(err.Error())
+		return 500, errorResponse(stub, 500, errors.Wrapf(err, "failed to transform flow result"))
+	}
+
+	if reply.Status >= shim.ERRORTHRESHOLD {
+		// flow returned an error
+		if reply.Message == "" {
+			reply.Message = "Flogo flow returned error status"
+		}
+		resp := &ErrorResponse{
+			Code:    reply.Status,
+			Message: reply.Message,
+			TxID:    triggerData.TxID,
+			Details: reply.Returns,
+		}
+		logger.Errorf("transaction %s failed with status %d: %s", resp.TxID, resp.Code, resp.Message)
+		return reply.Status, resp.ToJSON()
 	}
 
 	if reply.Returns == nil {
@@ -176,52 +434,19 @@ func Invoke(stub shim.ChaincodeStubInterface, fn string, args []string) (int, []
 		return reply.Status, []byte(reply.Message)
 	}
 
-	logger.Debugf("Flogo flow returned data: %s", reply.Returns)
+	if logger.DebugEnabled() {
+		logger.Debugf("Flogo flow returned data: %s", t.redactors[fn].String(reply.Returns))
+	}
 	jsonBytes, err := json.Marshal(reply.Returns)
 	if err != nil {
-		logger.Errorf("failed to serialize returned data: %v\n", err)
-		return 500, []byte(err.Error())
+		return 500, errorResponse(stub, 500, errors.Wrapf(err, "failed to serialize returned data"))
 	}
 	return reply.Status, jsonBytes
 }
 
-func (t *Trigger) extractCID(stub shim.ChaincodeStubInterface) map[string]string {
-	// get client identity
-	client := make(map[string]string)
-	c, err := cid.New(stub)
-	if err != nil {
-		logger.Warnf("extractCID(): %v\n", err)
-		client["id"] = "unknown"
-		client["mspid"] = "unknown"
-		client["cn"] = "unknown"
-		return client
-	}
-
-	// retrieve data from client identity
-	if id, err := c.GetID(); err == nil {
-		client["id"] = id
-	}
-	if mspid, err := c.GetMSPID(); err == nil {
-		client["mspid"] = mspid
-	}
-
-	if cert, err := c.GetX509Certificate(); err == nil {
-		client["cn"] = cert.Subject.CommonName
-	}
-
-	// retrieve custom attributes from client identity
-	if t.cidAttrs != nil {
-		for _, k := range t.cidAttrs {
-			if v, ok, err := c.GetAttributeValue(k); err == nil && ok && len(v) > 0 {
-				client[k] = v
-			}
-		}
-	}
-	return client
-}
-
-// construct trigger output transient attributes
-func prepareTransient(stub shim.ChaincodeStubInterface) (map[string]interface{}, error) {
+// construct trigger output transient attributes, and decode values by the declared data types.
+// transient values that are not declared are unmarshaled as JSON documents
+func prepareTransient(stub shim.ChaincodeStubInterface, attrs []*Attribute) (map[string]interface{}, error) {
 	transient := make(map[string]interface{})
 	transMap, err := stub.GetTransient()
 	if err != nil {
@@ -232,21 +457,41 @@ func prepareTransient(stub shim.ChaincodeStubInterface) (map[string]interface{},
 	if len(transMap) == 0 {
 		return transient, nil
 	}
+	types := make(map[string]string)
+	for _, a := range attrs {
+		types[a.Name] = a.Type
+	}
 	for k, v := range transMap {
-		var obj interface{}
-		if err := json.Unmarshal(v, &obj); err != nil {
-			return nil, errors.Wrapf(err, "failed to extract unmarshal data %s", k)
+		obj, err := decodeTransient(v, types[k])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode transient data %s", k)
 		}
-		logger.Debugf("received transient data, name: %s, value: %+v", k, obj)
+		logger.Debugf("received transient data %s", k)
 		transient[k] = obj
 	}
 	return transient, nil
 }
 
+// decodeTransient converts a transient value to flow data of the specified type
+func decodeTransient(data []byte, dataType string) (interface{}, error) {
+	switch dataType {
+	case TransientString:
+		return string(data), nil
+	case TransientBytes:
+		return base64.StdEncoding.EncodeToString(data), nil
+	default:
+		var obj interface{}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}
+}
+
 // construct trigger output parameters for specified parameter index, and values of the parameters
 func prepareParameters(attrs []*Attribute, values []string) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
-	logger.Debugf("prepare parameters %+v values %+v", attrs, values)
+	logger.Debugf("prepare parameters %+v", attrs)
 	if len(attrs) == 0 {
 		logger.Debug("no parameter required for this transaction")
 		return result, nil
@@ -264,6 +509,46 @@ func prepareParameters(attrs []*Attribute, values []string) (map[string]interfac
 	return result, nil
 }
 
+// namedArguments converts a single JSON object argument keyed by parameter names to positional arguments,
+// and uses default values for missing parameters. It also returns the names of parameters that are absent or null without default.
+// returns false if the arguments is not a JSON object containing only parameter names.
+func namedArguments(attrs []*Attribute, defaults map[string]interface{}, args []string) ([]string, map[string]bool, bool) {
+	if len(attrs) == 0 || len(args) != 1 {
+		return nil, nil, false
+	}
+	var named map[string]interface{}
+	if err := json.Unmarshal([]byte(args[0]), &named); err != nil || len(named) == 0 {
+		return nil, nil, false
+	}
+	positions := make(map[string]int)
+	for i, a := range attrs {
+		positions[a.Name] = i
+	}
+	for k := range named {
+		if _, ok := positions[k]; !ok {
+			// not a named parameter, so treat it as a positional argument
+			return nil, nil, false
+		}
+	}
+
+	result := make([]string, len(attrs))
+	missing := make(map[string]bool)
+	for i, a := range attrs {
+		value, ok := named[a.Name]
+		if !ok || value == nil {
+			if value, ok = defaults[a.Name]; !ok || value == nil {
+				// leave it empty, which is rejected if the parameter is required in strict mode
+				missing[a.Name] = true
+				continue
+			}
+		}
+		if s, err := coerce.ToString(value); err == nil {
+			result[i] = s
+		}
+	}
+	return result, missing, true
+}
+
 // unmarshalString returns unmarshaled object if input is a valid JSON object or array,
 // or returns the input string if it is not a valid JSON format
 func unmarshalString(data, jsonType, name string) interface{} {
@@ -272,20 +557,20 @@ func unmarshalString(data, jsonType, name string) interface{} {
 	case jschema.TYPE_ARRAY:
 		var result []interface{}
 		if err := json.Unmarshal([]byte(data), &result); err != nil {
-			logger.Warnf("failed to parse parameter %s as JSON array: data '%s' error %+v", name, data, err)
+			logger.Warnf("failed to parse parameter %s as JSON array", name)
 		}
 		return result
 	case jschema.TYPE_BOOLEAN:
 		b, err := strconv.ParseBool(s)
 		if err != nil {
-			logger.Warnf("failed to convert parameter %s to boolean: data '%s' error %+v", name, data, err)
+			logger.Warnf("failed to convert parameter %s to boolean", name)
 			return false
 		}
 		return b
 	case jschema.TYPE_INTEGER:
 		i, err := strconv.Atoi(s)
 		if err != nil {
-			logger.Warnf("failed to convert parameter %s to integer: data '%s' error %+v", name, data, err)
+			logger.Warnf("failed to convert parameter %s to integer", name)
 			return 0
 		}
 		return i
@@ -293,21 +578,21 @@ func unmarshalString(data, jsonType, name string) interface{} {
 		if !strings.Contains(s, ".") {
 			i, err := strconv.Atoi(s)
 			if err != nil {
-				logger.Warnf("failed to convert parameter %s to integer: data '%s' error %+v", name, data, err)
+				logger.Warnf("failed to convert parameter %s to integer", name)
 				return 0
 			}
 			return i
 		}
 		n, err := strconv.ParseFloat(s, 64)
 		if err != nil {
-			logger.Warnf("failed to convert parameter %s to float: data '%s' error %+v", name, data, err)
+			logger.Warnf("failed to convert parameter %s to float", name)
 			return 0.0
 		}
 		return n
 	case jschema.TYPE_OBJECT:
 		var result map[string]interface{}
 		if err := json.Unmarshal([]byte(data), &result); err != nil {
-			logger.Warnf("failed to convert parameter %s to object: data '%s' error: %+v", name, data, err)
+			logger.Warnf("failed to convert parameter %s to object", name)
 		}
 		return result
 	default:
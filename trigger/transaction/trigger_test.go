@@ -5,12 +5,26 @@ SPDX-License-Identifier: BSD-3-Clause-Open-MPI
 package transaction
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/msp"
 	"github.com/open-dovetail/fabric-chaincode/common"
 	"github.com/project-flogo/core/action"
 	"github.com/project-flogo/core/data/metadata"
@@ -30,7 +44,9 @@ func TestTrigger_Register(t *testing.T) {
 func TestHandlerSettings(t *testing.T) {
 	config := `{
 		"name": "myTransaction",
-		"parameters": "color,size:0"
+		"parameters": "color,size:0",
+		"transient": "marble,secret:string,doc:base64",
+		"sensitive": ["secret", "$.owner.ssn"]
 	}`
 	var configMap map[string]interface{}
 	err := json.Unmarshal([]byte(config), &configMap)
@@ -46,6 +62,140 @@ func TestHandlerSettings(t *testing.T) {
 	assert.Equal(t, "size", setting.Arguments[1].Name)
 	assert.Equal(t, "integer", setting.Arguments[1].Type)
 	assert.Equal(t, "(color:string)", fmt.Sprint(setting.Arguments[0]))
+	assert.Equal(t, 3, len(setting.Transient))
+	assert.Equal(t, "(marble:json)", fmt.Sprint(setting.Transient[0]))
+	assert.Equal(t, "(secret:string)", fmt.Sprint(setting.Transient[1]))
+	assert.Equal(t, "(doc:bytes)", fmt.Sprint(setting.Transient[2]))
+	assert.Equal(t, []string{"secret", "$.owner.ssn"}, setting.Sensitive)
+
+	setting = &HandlerSettings{}
+	err = setting.FromMap(map[string]interface{}{"name": "myTransaction", "sensitive": "secret, owner.ssn"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"secret", "owner.ssn"}, setting.Sensitive)
+}
+
+func TestPrepareTransient(t *testing.T) {
+	stub := shimtest.NewMockStub("mock", nil)
+	attrs := []*Attribute{
+		{Name: "secret", Type: TransientString},
+		{Name: "doc", Type: TransientBytes},
+	}
+	stub.TransientMap = map[string][]byte{
+		"marble": []byte(`{"name": "marble1", "price": 100}`),
+		"secret": []byte("my secret"),
+		"doc":    {0x00, 0xff, 0x10},
+	}
+	trans, err := prepareTransient(stub, attrs)
+	assert.NoError(t, err, "decode transient data should not throw error")
+	marble, ok := trans["marble"].(map[string]interface{})
+	assert.True(t, ok, "undeclared transient value should be a JSON object")
+	assert.Equal(t, "marble1", marble["name"])
+	assert.Equal(t, "my secret", trans["secret"])
+	assert.Equal(t, "AP8Q", trans["doc"])
+
+	// undeclared transient value must be valid JSON
+	stub.TransientMap["text"] = []byte("not json")
+	_, err = prepareTransient(stub, attrs)
+	assert.Error(t, err, "invalid JSON transient value should throw error")
+}
+
+func TestStrictParameters(t *testing.T) {
+	setting := &HandlerSettings{
+		Name:            "createMarble",
+		Strict:          true,
+		ParameterSchema: `{"type":"object","properties":{"name":{"type":"string"},"size":{"type":"integer","minimum":1}},"required":["name","owner"]}`,
+		TransientSchema: `{"type":"object","properties":{"marble":{"type":"object","required":["price"]}}}`,
+	}
+	v, err := newValidator(setting)
+	assert.NoError(t, err, "compile validation schema should not throw error")
+	attrs := []*Attribute{
+		{Name: "name", Type: "string"},
+		{Name: "size", Type: "integer"},
+		{Name: "owner", Type: "string"},
+	}
+
+	params, err := v.prepareStrictParameters(attrs, []string{"marble1", "50", "tom"}, nil)
+	assert.NoError(t, err, "valid parameters should not throw error")
+	assert.Equal(t, 50, params["size"])
+
+	_, err = v.prepareStrictParameters(attrs, []string{"marble1", "123-45-6789", ""}, map[string]bool{"owner": true})
+	verr, ok := err.(*ValidationError)
+	assert.True(t, ok, "invalid parameters should return ValidationError")
+	assert.Equal(t, 2, len(verr.Errors), "2 parameters should be invalid")
+	assert.Contains(t, verr.Errors["size"][0], "not an integer")
+	assert.Contains(t, verr.Errors["owner"][0], "required")
+	assert.NotContains(t, verr.Error(), "123-45-6789", "validation error should not contain the argument")
+
+	// empty string is a valid value of a required string parameter
+	params, err = v.prepareStrictParameters(attrs, []string{"marble1", "", ""}, nil)
+	assert.NoError(t, err, "empty string of required parameter should not throw error")
+	assert.Equal(t, "", params["owner"])
+	_, ok = params["size"]
+	assert.False(t, ok, "empty integer argument should be absent")
+
+	_, err = v.prepareStrictParameters(attrs, []string{"marble1", "0", "tom"}, nil)
+	assert.Error(t, err, "size less than minimum should throw error")
+
+	_, err = v.prepareStrictParameters(attrs, []string{"marble1"}, nil)
+	assert.Error(t, err, "missing arguments should throw error")
+
+	err = v.validateTransient(map[string]interface{}{"marble": map[string]interface{}{"name": "marble1"}})
+	verr, ok = err.(*ValidationError)
+	assert.True(t, ok, "invalid transient should return ValidationError")
+	assert.Equal(t, 1, len(verr.Errors["marble"]), "transient marble should be invalid")
+}
+
+func TestNamedArguments(t *testing.T) {
+	attrs := []*Attribute{
+		{Name: "name", Type: "string"},
+		{Name: "color", Type: "string"},
+		{Name: "size", Type: "integer"},
+		{Name: "owner", Type: "string"},
+	}
+	defaults := map[string]interface{}{"color": "red"}
+
+	args, missing, ok := namedArguments(attrs, defaults, []string{`{"name":"marble1","size":50,"owner":"tom"}`})
+	assert.True(t, ok, "JSON object of parameter names should be named arguments")
+	assert.Equal(t, []string{"marble1", "red", "50", "tom"}, args)
+	assert.Equal(t, 0, len(missing), "no parameter should be missing")
+
+	args, missing, ok = namedArguments(attrs, nil, []string{`{"name":"marble1","owner":""}`})
+	assert.True(t, ok, "partial parameter names should be named arguments")
+	assert.Equal(t, []string{"marble1", "", "", ""}, args)
+	assert.Equal(t, map[string]bool{"color": true, "size": true}, missing, "absent parameters should be missing")
+
+	_, _, ok = namedArguments(attrs, defaults, []string{`{"name":"marble1","weight":10}`})
+	assert.False(t, ok, "unknown parameter name should not be named arguments")
+
+	_, _, ok = namedArguments(attrs, defaults, []string{"marble1", "blue", "50", "tom"})
+	assert.False(t, ok, "multiple arguments should not be named arguments")
+
+	params, err := prepareParameters(attrs, args)
+	assert.NoError(t, err, "named arguments should convert to parameters")
+	assert.Equal(t, "marble1", params["name"])
+}
+
+func TestReadOnlyStub(t *testing.T) {
+	mock := shimtest.NewMockStub("mock", nil)
+	mock.MockTransactionStart("tx1")
+	err := mock.PutState("marble1", []byte(`{"name":"marble1"}`))
+	assert.NoError(t, err, "put state to mock stub should not throw error")
+	mock.MockTransactionEnd("tx1")
+
+	stub := newReadOnlyStub(mock, "getMarble")
+	v, err := stub.GetState("marble1")
+	assert.NoError(t, err, "read-only stub should read state")
+	assert.Equal(t, `{"name":"marble1"}`, string(v))
+	assert.NoError(t, stub.Violation(), "read state should not violate read-only")
+
+	err = stub.PutState("marble1", []byte(`{}`))
+	assert.Error(t, err, "read-only stub should reject PutState")
+	assert.Error(t, stub.DelState("marble1"), "read-only stub should reject DelState")
+	assert.Error(t, stub.PutPrivateData("pdc", "marble1", nil), "read-only stub should reject PutPrivateData")
+	assert.Error(t, stub.SetEvent("event", nil), "read-only stub should reject SetEvent")
+	resp := stub.InvokeChaincode("marble_cc", [][]byte{[]byte("transferMarble")}, "")
+	assert.Equal(t, int32(403), resp.Status, "read-only stub should reject InvokeChaincode")
+	assert.Equal(t, err, stub.Violation(), "violation should be the first rejected update")
 }
 
 type mockAction struct {
@@ -142,4 +292,533 @@ func TestTriggerEval(t *testing.T) {
 	params, ok := output["parameters"].(map[string]interface{})
 	assert.True(t, ok, "input parameters should be a map")
 	assert.Equal(t, "blue", params["color"].(string), "color should be blue")
+
+	// chaincode Init returns success if no init handler is defined
+	status, _ = Init(stub, "", nil)
+	assert.Equal(t, 200, status, "init status should be 200")
+
+	// chaincode Init invokes the default init handler
+	trans.inits["initMarble"] = true
+	trans.initName = "initMarble"
+	status, returns = Init(stub, "", []string{"marble2", "red", "10", "tom"})
+	assert.Equal(t, 200, status, "init status should be 200")
+	err = json.Unmarshal(returns, &output)
+	assert.NoError(t, err, "init result should return a map")
+	params, ok = output["parameters"].(map[string]interface{})
+	assert.True(t, ok, "init parameters should be a map")
+	assert.Equal(t, "tom", params["owner"].(string), "owner should be tom")
+
+	// init function name invokes the default init handler only from the chaincode Init
+	status, _ = Init(stub, "init", []string{"marble2", "red", "10", "tom"})
+	assert.Equal(t, 200, status, "init status of init function should be 200")
+	status, _ = Invoke(stub, "init", []string{"marble2", "red", "10", "tom"})
+	assert.Equal(t, 501, status, "invoke init function should be rejected with status 501")
+	status, _ = Invoke(stub, "initMarble", []string{"marble2", "red", "10", "tom"})
+	assert.Equal(t, 501, status, "invoke init handler should be rejected with status 501")
+
+	// access control rejects unknown client
+	trans.access["initMarble"] = &AccessControl{MSPIDs: []string{"Org1MSP"}}
+	status, _ = Init(stub, "initMarble", []string{"marble1", "blue", "50", "tom"})
+	assert.Equal(t, 403, status, "unauthorized client should be rejected with status 403")
+	delete(trans.access, "initMarble")
+}
+
+func TestAccessControl(t *testing.T) {
+	ac, err := toAccessControl(map[string]interface{}{
+		"mspids":     []interface{}{"Org1MSP", "Org2MSP"},
+		"ous":        []interface{}{"client"},
+		"attributes": map[string]interface{}{"role": []interface{}{"broker", "admin"}, "email": []interface{}{}},
+	})
+	assert.NoError(t, err, "access control setting should not throw error")
+	assert.Equal(t, 2, len(ac.MSPIDs), "access control should allow 2 MSPs")
+
+	client := &ClientIdentity{
+		MSPID: "Org1MSP",
+		OU:    "client,org1",
+		OUs:   []string{"client", "org1"},
+		Attrs: map[string]string{"role": "broker", "email": "tom@example.com"},
+	}
+	assert.NoError(t, ac.authorize(client), "client should be allowed")
+	client.MSPID = "Org3MSP"
+	assert.Error(t, ac.authorize(client), "client of other MSP should be rejected")
+	client.MSPID = "Org2MSP"
+	client.OUs = []string{"peer"}
+	assert.Error(t, ac.authorize(client), "client of other OU should be rejected")
+	client.OUs = []string{"client"}
+	client.Attrs["role"] = "user"
+	assert.Error(t, ac.authorize(client), "client of other role should be rejected")
+	client.Attrs["role"] = "admin"
+	delete(client.Attrs, "email")
+	assert.Error(t, ac.authorize(client), "client without email should be rejected")
+
+	ac, err = toAccessControl(`{}`)
+	assert.NoError(t, err, "empty access control should not throw error")
+	assert.Nil(t, ac, "empty access control should allow all clients")
+	assert.NoError(t, ac.authorize(client), "nil access control should allow all clients")
+}
+
+func TestMultipleContracts(t *testing.T) {
+	config := `{
+      "id": "fabric_transaction_marble",
+      "ref": "#transaction",
+      "settings": {
+        "contract": "marble"
+      },
+      "handlers": [
+        {
+          "settings": {
+            "name": "getMarble",
+            "parameters": "name"
+          },
+          "action": {
+			"id": "test",
+            "ref": "#flow",
+            "settings": {
+              "flowURI": "res://flow:get_marble"
+            }
+          }
+        }
+      ]
+	}`
+
+	var trigConfig trigger.Config
+	err := json.Unmarshal([]byte(config), &trigConfig)
+	assert.NoError(t, err, "unmarshal of trigger config should not throw error")
+	acts := map[string]action.Action{"test": new(mockAction)}
+	trig, err := test.InitTrigger(new(Factory), &trigConfig, acts)
+	assert.NoError(t, err, "initialize trigger should not throw error")
+
+	_, err = new(Factory).New(&trigConfig)
+	assert.Error(t, err, "trigger of the same contract should not be instantiated twice")
+
+	tr, name := lookupTrigger("marble:getMarble")
+	assert.Equal(t, trig, tr, "contract name should route to the marble trigger")
+	assert.Equal(t, "getMarble", name)
+	tr, name = lookupTrigger("getMarble")
+	assert.Equal(t, defaultTrigger, tr, "bare transaction name should route to the default trigger")
+	assert.Equal(t, "getMarble", name)
+
+	stub := shimtest.NewMockStub("mock", nil)
+	status, _ := Invoke(stub, "marble:getMarble", []string{"marble1"})
+	assert.Equal(t, 200, status, "transaction of marble contract should succeed")
+	if defaultTrigger != trig {
+		status, _ = Invoke(stub, "getMarble", []string{"marble1"})
+		assert.Equal(t, 501, status, "transaction of marble contract is not defined in the default contract")
+	}
+}
+
+func TestContractMetadata(t *testing.T) {
+	setting := &HandlerSettings{}
+	err := setting.FromMap(map[string]interface{}{
+		"name":            "getMarble",
+		"parameters":      "name,size:0",
+		"parameterSchema": `{"type":"object","properties":{"name":{"type":"string","maxLength":20}}}`,
+		"returnSchema":    map[string]interface{}{"type": "object"},
+		"readOnly":        true,
+	})
+	assert.NoError(t, err, "handler settings should not throw error")
+	tm := transactionMetadata(setting)
+	assert.Equal(t, []string{"evaluate"}, tm.Tag, "read-only transaction should be tagged as evaluate")
+	assert.Equal(t, 2, len(tm.Parameters), "transaction should have 2 parameters")
+	assert.Equal(t, 20.0, tm.Parameters[0].Schema.(map[string]interface{})["maxLength"], "parameter schema should be used")
+	assert.Equal(t, map[string]interface{}{"type": "integer"}, tm.Parameters[1].Schema, "parameter type should be used without schema")
+	assert.Equal(t, map[string]interface{}{"type": "object"}, tm.Returns, "return schema should be reported")
+
+	stub := shimtest.NewMockStub("mock", nil)
+	status, result := Invoke(stub, metadataFn, nil)
+	assert.Equal(t, 200, status, "GetMetadata should succeed")
+	md := &ContractChaincodeMetadata{}
+	err = json.Unmarshal(result, md)
+	assert.NoError(t, err, "contract metadata should be a JSON document")
+	assert.Equal(t, len(triggers), len(md.Contracts), "metadata should describe all contracts")
+	marble, ok := md.Contracts["marble"]
+	assert.True(t, ok, "metadata should describe the marble contract")
+	assert.Equal(t, "getMarble", marble.Transactions[0].Name, "marble contract should contain getMarble")
+	assert.Equal(t, []string{"submit"}, marble.Transactions[0].Tag, "transaction should be tagged as submit")
+}
+
+type panicHandler struct {
+	trigger.Handler
+}
+
+func (h *panicHandler) Handle(ctx context.Context, triggerData interface{}) (map[string]interface{}, error) {
+	panic("activity failure")
+}
+
+// panicStub panics when a system transaction reads the client identity
+type panicStub struct {
+	*shimtest.MockStub
+}
+
+func (s *panicStub) GetCreator() ([]byte, error) {
+	panic("ledger failure")
+}
+
+func TestErrorResponse(t *testing.T) {
+	trig := &Trigger{handlers: map[string]trigger.Handler{}}
+	stub := shimtest.NewMockStub("mock", nil)
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	status, payload := trig.invoke(stub, "panic", "panic", &panicHandler{}, nil)
+	assert.Equal(t, 500, status, "panic should be recovered as status 500")
+	resp := &ErrorResponse{}
+	err := json.Unmarshal(payload, resp)
+	assert.NoError(t, err, "error response should be a JSON document")
+	assert.Equal(t, 500, resp.Code, "error code should be 500")
+	assert.Equal(t, "tx1", resp.TxID, "error response should contain the txID")
+	assert.Contains(t, resp.Message, "activity failure", "error message should describe the panic")
+
+	// panic of a system transaction is recovered by Invoke
+	status, payload = Invoke(&panicStub{MockStub: stub}, pruneFn, nil)
+	assert.Equal(t, 500, status, "panic of system transaction should be recovered as status 500")
+	err = json.Unmarshal(payload, resp)
+	assert.NoError(t, err, "error response should be a JSON document")
+	assert.Contains(t, resp.Message, "ledger failure", "error message should describe the panic")
+
+	verr := &ValidationError{Message: "invalid transaction parameters"}
+	verr.add("size", "'abc' is not an integer")
+	err = json.Unmarshal(errorResponse(stub, 400, verr), resp)
+	assert.NoError(t, err, "validation error response should be a JSON document")
+	assert.Equal(t, 400, resp.Code, "error code should be 400")
+	assert.Equal(t, verr.Message, resp.Message, "error message should be the validation message")
+	details, ok := resp.Details.(map[string]interface{})
+	assert.True(t, ok, "validation errors should be reported as details")
+	assert.Equal(t, 1, len(details["size"].([]interface{})), "details should contain the invalid field")
+}
+
+func TestMetrics(t *testing.T) {
+	stub := shimtest.NewMockStub("mock", nil)
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	sp := newSpan("tx1", "metrics", "putMarble", "putMarble")
+	ts := newTraceStub(stub, sp)
+	var as common.ActivityStub = ts
+	put := as.WithActivity("put_1")
+	err := put.PutState("marble1", []byte(`{"color":"blue"}`))
+	assert.NoError(t, err, "put state should not throw error")
+	_, err = put.GetState("marble1")
+	assert.NoError(t, err, "get state should not throw error")
+	_, err = ts.GetState("marble1")
+	assert.NoError(t, err, "get state should not throw error")
+	assert.Equal(t, 2, sp.Reads, "span should count 2 reads")
+	assert.Equal(t, 1, sp.Writes, "span should count 1 write")
+	assert.Equal(t, 1, sp.Activities["put_1"].Writes, "write should be attributed to the activity")
+	assert.Equal(t, 1, sp.Activities[unknownActivity].Reads, "read without activity should be attributed to unknown")
+	sp.finish(200)
+
+	var buf bytes.Buffer
+	txMetrics.write(&buf)
+	out := buf.String()
+	assert.Contains(t, out, `fabric_transaction_requests_total{contract="metrics",transaction="putMarble",status="200"} 1`)
+	assert.Contains(t, out, `fabric_transaction_duration_seconds_count{contract="metrics",transaction="putMarble"} 1`)
+	assert.Contains(t, out, `fabric_transaction_state_writes_total{contract="metrics",transaction="putMarble",activity="put_1"} 1`)
+	assert.Contains(t, out, `fabric_transaction_state_reads_total{contract="metrics",transaction="putMarble",activity="put_1"} 1`)
+
+	// transactions routed to a wildcard handler are recorded by the handler
+	for _, name := range []string{"getMarble", "deleteMarble"} {
+		newSpan("tx2", "metrics", "*", name).finish(200)
+	}
+	buf.Reset()
+	txMetrics.write(&buf)
+	out = buf.String()
+	assert.Contains(t, out, `fabric_transaction_requests_total{contract="metrics",transaction="*",status="200"} 2`)
+	assert.NotContains(t, out, `contract="metrics",transaction="getMarble"`, "transaction name should not be a metric label")
+}
+
+// newCreator returns a serialized identity of a self-signed client certificate of an MSP and OUs
+func newCreator(t *testing.T, mspid string, ous ...string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "generate key should not throw error")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "user1", OrganizationalUnit: ous},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err, "create cert should not throw error")
+	sid, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspid,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	assert.NoError(t, err, "marshal identity should not throw error")
+	return sid
+}
+
+func TestSystemAccess(t *testing.T) {
+	setting := &Settings{}
+	err := setting.FromMap(map[string]interface{}{"systemAccess": `{"mspids": ["Org1MSP"], "ous": ["admin"]}`})
+	assert.NoError(t, err, "system access setting should be valid")
+	assert.Equal(t, []string{"Org1MSP"}, setting.SystemAccess.MSPIDs, "system access should allow Org1MSP")
+
+	trig := &Trigger{systemAccess: setting.SystemAccess}
+	stub := shimtest.NewMockStub("system", nil)
+	for creator, expected := range map[string]int{"Org1MSP:client": 403, "Org2MSP:admin": 403, "Org1MSP:admin": 200} {
+		id := strings.Split(creator, ":")
+		stub.Creator = newCreator(t, id[0], id[1])
+		stub.MockTransactionStart("tx1")
+		status, _ := trig.invokeSystem(stub, pruneFn, nil)
+		stub.MockTransactionEnd("tx1")
+		assert.Equal(t, expected, status, "prune by %s should return status %d", creator, expected)
+	}
+
+	// only admins are allowed by default
+	trig.systemAccess = defaultSystemAccess
+	stub.Creator = newCreator(t, "Org2MSP", "client")
+	stub.MockTransactionStart("tx2")
+	status, _ := trig.invokeSystem(stub, migrateFn, []string{"marble"})
+	stub.MockTransactionEnd("tx2")
+	assert.Equal(t, 403, status, "migration by client should be rejected by default")
+}
+
+func TestClientIdentity(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "generate key should not throw error")
+	attrs := `{"attrs":{"role":"broker","email":"tom@example.com","level":"3"}}`
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(255),
+		Subject:        pkix.Name{CommonName: "user1", OrganizationalUnit: []string{"org1", "Client"}},
+		NotBefore:      time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:       time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:       []string{"user1.org1.example.com"},
+		EmailAddresses: []string{"user1@org1.example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		ExtraExtensions: []pkix.Extension{{
+			Id:    asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1},
+			Value: []byte(attrs),
+		}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err, "create cert should not throw error")
+	sid, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   "Org1MSP",
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	assert.NoError(t, err, "marshal identity should not throw error")
+
+	stub := shimtest.NewMockStub("identity", nil)
+	stub.Creator = sid
+	trig := &Trigger{cidAttrs: []string{"role"}}
+	client := trig.extractCID(stub)
+	assert.Equal(t, "Org1MSP", client.MSPID, "MSP ID should be extracted")
+	assert.Equal(t, "user1", client.CN, "common name should be extracted")
+	assert.Equal(t, "org1,Client", client.OU, "OU should be joined by comma")
+	assert.Equal(t, "client", client.NodeRole, "node role should be derived from OU")
+	assert.Equal(t, "ff", client.Serial, "serial number should be hex string")
+	assert.Equal(t, "CN=user1,OU=org1+OU=Client", client.Issuer, "issuer DN of self-signed cert should be extracted")
+	assert.Equal(t, "2030-01-01T00:00:00Z", client.NotAfter, "validity should be RFC3339 time")
+	assert.Equal(t, []string{"10.0.0.1"}, client.IPs, "IP SANs should be extracted")
+	assert.Equal(t, map[string]string{"role": "broker"}, client.Attrs, "only configured attributes should be extracted")
+
+	trig.cidAllAttrs = true
+	client = trig.extractCID(stub)
+	assert.Equal(t, 3, len(client.Attrs), "all attributes should be extracted")
+	cid := client.ToMap()
+	assert.Equal(t, "broker", cid["role"], "custom attribute should be set as top-level property")
+	assert.Equal(t, []interface{}{"user1.org1.example.com"}, cid["dnsNames"], "DNS SANs should be array")
+}
+
+type countHandler struct {
+	trigger.Handler
+	count int
+}
+
+func (h *countHandler) Handle(ctx context.Context, triggerData interface{}) (map[string]interface{}, error) {
+	h.count++
+	return map[string]interface{}{"status": 200, "returns": map[string]interface{}{"count": h.count}}, nil
+}
+
+func TestIdempotency(t *testing.T) {
+	trig := &Trigger{
+		handlers:       map[string]trigger.Handler{},
+		arguments:      map[string][]*Attribute{"putMarble": {{Name: "name", Type: "string"}}},
+		idempotency:    map[string]string{"putMarble": "requestId"},
+		idempotencyTTL: -time.Minute,
+	}
+	handler := &countHandler{}
+	stub := shimtest.NewMockStub("mock", nil)
+
+	stub.MockTransactionStart("tx1")
+	stub.TransientMap = map[string][]byte{"requestId": []byte(`"req1"`)}
+	status, payload := trig.invoke(stub, "putMarble", "putMarble", handler, []string{"marble1"})
+	stub.MockTransactionEnd("tx1")
+	assert.Equal(t, 200, status, "first submission should succeed")
+	assert.Equal(t, `{"count":1}`, string(payload), "first submission should execute the flow")
+
+	stub.MockTransactionStart("tx2")
+	status, payload = trig.invoke(stub, "putMarble", "putMarble", handler, []string{"marble1"})
+	stub.MockTransactionEnd("tx2")
+	assert.Equal(t, 200, status, "repeated submission should succeed")
+	assert.Equal(t, `{"count":1}`, string(payload), "repeated submission should replay the recorded response")
+	assert.Equal(t, 1, handler.count, "repeated submission should not execute the flow")
+
+	stub.MockTransactionStart("tx3")
+	status, _ = trig.invoke(stub, "putMarble", "putMarble", handler, []string{"marble2"})
+	stub.MockTransactionEnd("tx3")
+	assert.Equal(t, 409, status, "reuse of idempotency key for other parameters should be rejected")
+
+	stub.MockTransactionStart("tx4")
+	stub.TransientMap = nil
+	status, payload = trig.invoke(stub, "putMarble", "putMarble", handler, []string{"marble1"})
+	stub.MockTransactionEnd("tx4")
+	assert.Equal(t, 200, status, "submission without idempotency key should succeed")
+	assert.Equal(t, `{"count":2}`, string(payload), "submission without idempotency key should execute the flow")
+
+	// transient data is part of the request
+	stub.MockTransactionStart("tx5")
+	stub.TransientMap = map[string][]byte{"requestId": []byte(`"req2"`), "price": []byte(`10`)}
+	trig.transient = map[string][]*Attribute{"putMarble": {{Name: "requestId", Type: "json"}, {Name: "price", Type: "json"}}}
+	status, _ = trig.invoke(stub, "putMarble", "putMarble", handler, []string{"marble1"})
+	stub.MockTransactionEnd("tx5")
+	assert.Equal(t, 200, status, "submission with transient data should succeed")
+	stub.MockTransactionStart("tx6")
+	stub.TransientMap = map[string][]byte{"requestId": []byte(`"req2"`), "price": []byte(`20`)}
+	status, _ = trig.invoke(stub, "putMarble", "putMarble", handler, []string{"marble1"})
+	stub.MockTransactionEnd("tx6")
+	assert.Equal(t, 409, status, "reuse of idempotency key for other transient data should be rejected")
+
+	// prune expired records by pages
+	store := common.NewMemoryStore()
+	for k, v := range stub.State {
+		assert.NoError(t, store.PutState("", k, v), "copy state should not throw error")
+	}
+	now := time.Now()
+	result, err := prunePage(store, now, 1, "")
+	assert.NoError(t, err, "prune should not throw error")
+	assert.Equal(t, 1, result.Scanned, "prune should scan 1 record")
+	assert.Equal(t, 1, result.Pruned, "expired record should be pruned")
+	assert.NotEmpty(t, result.Bookmark, "prune should return bookmark of the next page")
+	result, err = prunePage(store, now, 1, result.Bookmark)
+	assert.NoError(t, err, "prune should not throw error")
+	assert.Equal(t, 1, result.Pruned, "expired record of the next page should be pruned")
+
+	stub.MockTransactionStart("tx7")
+	status, payload = pruneIdempotencyKeys(stub, []string{"x"})
+	stub.MockTransactionEnd("tx7")
+	assert.Equal(t, 400, status, "invalid page size should be rejected")
+}
+
+func TestRouting(t *testing.T) {
+	trig := &Trigger{handlers: map[string]trigger.Handler{}, initFn: "init"}
+	for _, s := range []*HandlerSettings{
+		{Name: "createMarble"},
+		{Name: "versioned", Pattern: `v[0-9]+\.createMarble`},
+		{Name: "query*"},
+		{Name: "*"},
+	} {
+		trig.handlers[s.Name] = &countHandler{}
+		r, err := newRoute(s)
+		assert.NoError(t, err, "route should not throw error")
+		if r != nil {
+			trig.routes = append(trig.routes, r)
+		}
+	}
+	_, err := newRoute(&HandlerSettings{Name: "bad", Pattern: "v[0-9"})
+	assert.Error(t, err, "invalid pattern should throw error")
+
+	name, ok := trig.resolve("createMarble")
+	assert.True(t, ok && name == "createMarble", "exact name should match its handler")
+	name, ok = trig.resolve("v2.createMarble")
+	assert.True(t, ok && name == "versioned", "regular expression should match the handler")
+	name, ok = trig.resolve("queryMarblesByOwner")
+	assert.True(t, ok && name == "query*", "prefix should match the handler")
+	name, ok = trig.resolve("transferMarble")
+	assert.True(t, ok && name == "*", "unknown transaction should be handled by the default handler")
+
+	// init handlers are invoked only by the chaincode Init
+	trig.handlers["seedMarble"] = &countHandler{}
+	trig.inits = map[string]bool{"seedMarble": true}
+	trig.initName = "seedMarble"
+	_, ok = trig.resolve("init")
+	assert.False(t, ok, "init function should not be resolved for a regular transaction")
+	_, ok = trig.resolve("seedMarble")
+	assert.False(t, ok, "init handler should not be resolved for a regular transaction")
+	delete(trig.handlers, "seedMarble")
+	trig.inits = nil
+
+	delete(trig.handlers, "*")
+	trig.routes = trig.routes[:2]
+	_, ok = trig.resolve("transferMarble")
+	assert.False(t, ok, "unknown transaction should not be matched")
+	assert.Equal(t, []string{"createMarble", "query*", `v[0-9]+\.createMarble`}, trig.supportedTransactions())
+
+	stub := shimtest.NewMockStub("mock", nil)
+	resp := &ErrorResponse{}
+	err = json.Unmarshal(errorResponse(stub, 501, &unsupportedError{fn: "transferMarble", supported: trig.supportedTransactions()}), resp)
+	assert.NoError(t, err, "error response should be a JSON document")
+	assert.Equal(t, 3, len(resp.Details.(map[string]interface{})["transactions"].([]interface{})), "error should list supported transactions")
+}
+
+func TestMigrateRecords(t *testing.T) {
+	err := common.RegisterMigrationRules([]*common.MigrationRule{{
+		Schema:    "asset",
+		Version:   1,
+		Transform: []*common.TransformOp{{Op: "default", Path: "status", Value: "active"}},
+	}})
+	assert.NoError(t, err, "register migration rules should not throw error")
+
+	store := common.NewMemoryStore()
+	v1 := &common.ValueFormat{Schema: "asset", Version: 1, Encoding: common.EncodingJSON}
+	for _, k := range []string{"asset1", "asset2", "asset3"} {
+		value, _ := common.EncodeValue(map[string]interface{}{"id": k}, v1)
+		store.PutState("", k, value)
+	}
+	store.PutState("", "other", []byte(`{"id":"other"}`))
+
+	result, err := migratePage(context.Background(), store, "", "asset", 2, 2, "")
+	assert.NoError(t, err, "migrate first page should not throw error")
+	assert.Equal(t, &MigrateResult{Scanned: 2, Migrated: 2, Bookmark: "asset3"}, result, "first page should migrate 2 records")
+	result, err = migratePage(context.Background(), store, "", "asset", 2, 2, result.Bookmark)
+	assert.NoError(t, err, "migrate second page should not throw error")
+	assert.Equal(t, &MigrateResult{Scanned: 2, Migrated: 1}, result, "second page should skip plain JSON record")
+
+	value, _ := store.GetState("", "asset3")
+	data, f, err := common.DecodeValue(value)
+	assert.NoError(t, err, "decode migrated record should not throw error")
+	assert.Equal(t, 2, f.Version, "migrated record should be version 2")
+	assert.Equal(t, "active", data.(map[string]interface{})["status"], "migrated record should have default status")
+
+	stub := shimtest.NewMockStub("mock", nil)
+	status, _ := migrateRecords(stub, []string{"unknown"})
+	assert.Equal(t, 400, status, "migration of schema without rules should be rejected")
+
+	// composite keys of a changed key attribute are updated
+	err = common.RegisterMigrationRules([]*common.MigrationRule{{
+		Schema:    "vehicle",
+		Version:   1,
+		Transform: []*common.TransformOp{{Op: "move", From: "plate", Path: "registration.plate"}, {Op: "replace", Path: "color", Value: "blue"}},
+	}})
+	assert.NoError(t, err, "register migration rules should not throw error")
+	keyDefs := map[string][]string{"plate~id": {"$.registration.plate", "$.id"}, "color~id": {"$.color", "$.id"}}
+	assert.NoError(t, common.RegisterSchemaKeys("vehicle", keyDefs, []string{"plate~id"}), "register schema keys should not throw error")
+
+	store = common.NewMemoryStore()
+	v1 = &common.ValueFormat{Schema: "vehicle", Version: 1, Encoding: common.EncodingJSON}
+	for k, plate := range map[string]string{"car1": "ABC123", "car2": "ABC123"} {
+		value, _ := common.EncodeValue(map[string]interface{}{"id": k, "plate": plate, "color": "red"}, v1)
+		store.PutState("", k, value)
+		ck, _ := store.CreateCompositeKey("color~id", []string{"red", k})
+		store.PutState("", ck, []byte{0})
+	}
+	_, err = migratePage(context.Background(), store, "", "vehicle", 2, 1, "")
+	assert.NoError(t, err, "migrate first vehicle should not throw error")
+	iter, _, _ := store.GetStateByPartialCompositeKey("", "plate~id", []string{"ABC123"}, 0, "")
+	assert.True(t, iter.HasNext(), "unique key of the new plate attribute should be stored")
+	iter.Close()
+	for color, count := range map[string]int{"red": 1, "blue": 1} {
+		iter, _, _ = store.GetStateByPartialCompositeKey("", "color~id", []string{color}, 0, "")
+		n := 0
+		for iter.HasNext() {
+			iter.Next()
+			n++
+		}
+		iter.Close()
+		assert.Equal(t, count, n, "stale color key should be replaced by the key of the new color %s", color)
+	}
+
+	_, err = migratePage(context.Background(), store, "", "vehicle", 2, 1, "car2")
+	assert.Error(t, err, "migration should reject a duplicate unique key")
+	assert.Contains(t, err.Error(), "unique key plate~id", "error should report the unique key")
 }
@@ -0,0 +1,172 @@
+/*
+SPDX-License-Identifier: BSD-3-Clause-Open-MPI
+*/
+
+package transaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	jschema "github.com/xeipuuv/gojsonschema"
+)
+
+// ValidationError reports invalid transaction input as lists of error messages by field name
+type ValidationError struct {
+	Message string              `json:"message"`
+	Errors  map[string][]string `json:"errors"`
+}
+
+// Error implements error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Message, e.Errors)
+}
+
+// ToJSON returns serialized validation error for the transaction response
+func (e *ValidationError) ToJSON() []byte {
+	jsonBytes, err := json.Marshal(e)
+	if err != nil {
+		return []byte(e.Error())
+	}
+	return jsonBytes
+}
+
+func (e *ValidationError) add(field, msg string) {
+	if e.Errors == nil {
+		e.Errors = make(map[string][]string)
+	}
+	e.Errors[field] = append(e.Errors[field], msg)
+}
+
+// validator checks transaction parameters and transient attributes against the JSON schemas of a transaction
+type validator struct {
+	parameters *jschema.Schema
+	transient  *jschema.Schema
+}
+
+// newValidator compiles JSON schemas configured for parameters and transient attributes of a strict handler
+func newValidator(setting *HandlerSettings) (*validator, error) {
+	v := &validator{}
+	var err error
+	if len(setting.ParameterSchema) > 0 {
+		if v.parameters, err = jschema.NewSchema(jschema.NewStringLoader(setting.ParameterSchema)); err != nil {
+			return nil, errors.Wrapf(err, "invalid parameter schema for transaction %s", setting.Name)
+		}
+	}
+	if len(setting.TransientSchema) > 0 {
+		if v.transient, err = jschema.NewSchema(jschema.NewStringLoader(setting.TransientSchema)); err != nil {
+			return nil, errors.Wrapf(err, "invalid transient schema for transaction %s", setting.Name)
+		}
+	}
+	return v, nil
+}
+
+// prepareStrictParameters converts arguments to parameters, and validates them against the parameter schema.
+// Parameters of missing named arguments are absent, and so they must not be required by the schema.
+// An empty argument is an empty string parameter, or absent if the parameter is not a string.
+func (v *validator) prepareStrictParameters(attrs []*Attribute, values []string, missing map[string]bool) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if len(values) != len(attrs) {
+		return nil, &ValidationError{
+			Message: fmt.Sprintf("transaction requires %d arguments, but received %d", len(attrs), len(values)),
+		}
+	}
+
+	verr := &ValidationError{Message: "invalid transaction parameters"}
+	for i, s := range values {
+		if missing[attrs[i].Name] {
+			continue
+		}
+		if len(strings.TrimSpace(s)) == 0 && attrs[i].Type != jschema.TYPE_STRING {
+			continue
+		}
+		obj, err := parseString(s, attrs[i].Type)
+		if err != nil {
+			verr.add(attrs[i].Name, err.Error())
+			continue
+		}
+		result[attrs[i].Name] = obj
+	}
+	validateSchema(v.parameters, result, verr)
+
+	if len(verr.Errors) > 0 {
+		return nil, verr
+	}
+	return result, nil
+}
+
+// validateTransient validates transient attributes against the transient schema
+func (v *validator) validateTransient(transient map[string]interface{}) error {
+	verr := &ValidationError{Message: "invalid transient attributes"}
+	validateSchema(v.transient, transient, verr)
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// validateSchema adds schema violations of data to the validation error by field names
+func validateSchema(schema *jschema.Schema, data map[string]interface{}, verr *ValidationError) {
+	if schema == nil {
+		return
+	}
+	result, err := schema.Validate(jschema.NewGoLoader(data))
+	if err != nil {
+		verr.add(jschema.STRING_CONTEXT_ROOT, err.Error())
+		return
+	}
+	for _, e := range result.Errors() {
+		field := e.Field()
+		if p, ok := e.Details()["property"].(string); ok && field == jschema.STRING_CONTEXT_ROOT {
+			// report missing required property by the property name
+			field = p
+		}
+		verr.add(field, e.Description())
+	}
+}
+
+// parseString returns the value of a string argument as the specified JSON type, or error if it is invalid.
+// The error does not contain the argument, which may be sensitive.
+func parseString(data, jsonType string) (interface{}, error) {
+	s := strings.TrimSpace(data)
+	switch jsonType {
+	case jschema.TYPE_ARRAY:
+		var result []interface{}
+		if err := json.Unmarshal([]byte(s), &result); err != nil {
+			return nil, errors.New("value is not a JSON array")
+		}
+		return result, nil
+	case jschema.TYPE_BOOLEAN:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, errors.New("value is not a boolean")
+		}
+		return b, nil
+	case jschema.TYPE_INTEGER:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.New("value is not an integer")
+		}
+		return i, nil
+	case jschema.TYPE_NUMBER:
+		if i, err := strconv.Atoi(s); err == nil {
+			return i, nil
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, errors.New("value is not a number")
+		}
+		return n, nil
+	case jschema.TYPE_OBJECT:
+		var result map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &result); err != nil {
+			return nil, errors.New("value is not a JSON object")
+		}
+		return result, nil
+	default:
+		return s, nil
+	}
+}